@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 
 	"github.com/toversus/monkey/token"
@@ -15,6 +16,11 @@ type Node interface {
 	// String prints AST nodes for debugging ans compares them with other AST nodes,
 	// and it is handy in tests.
 	String() string
+
+	// Pos returns the position of the first char belonging to the node.
+	Pos() token.Position
+	// End returns the position just after the last char belonging to the node.
+	End() token.Position
 }
 
 // Statement doesn't produce a value.
@@ -46,6 +52,20 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 // String returns whole program back as a string for readable tests.
 func (p *Program) String() string {
 	var out bytes.Buffer
@@ -57,26 +77,68 @@ func (p *Program) String() string {
 	return out.String()
 }
 
+// Exports returns the names bound by the top-level let statements of the program,
+// which are exported when the program is imported as a module.
+// The names starting with '_' are private to the module.
+func (p *Program) Exports() []string {
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, s := range p.Statements {
+		let, ok := s.(*LetStatement)
+		if !ok {
+			continue
+		}
+		for _, name := range let.Names() {
+			if strings.HasPrefix(name.Value, "_") || seen[name.Value] {
+				continue
+			}
+			seen[name.Value] = true
+			names = append(names, name.Value)
+		}
+	}
+
+	return names
+}
+
 // LetStatement ...
 type LetStatement struct {
 	// Token is the token.LET token.
 	Token token.Token
 
-	// Name is the identifier of the binding.
+	// Name is the identifier of the binding, which is nil if Pattern destructures the value.
 	Name *Identifier
 
+	// Pattern is the array pattern or the hash pattern which binds the parts of the value,
+	// made of identifiers and nested patterns only.
+	Pattern Expression
+
 	// Value for the expression produces the value.
 	Value Expression
 }
 
+// Names returns the identifiers bound by the statement, except the wildcards in the pattern.
+func (ls *LetStatement) Names() []*Identifier {
+	if ls.Pattern == nil {
+		return []*Identifier{ls.Name}
+	}
+	return PatternNames(ls.Pattern)
+}
+
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position  { return endOf(ls.Value, ls.Token) }
 
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -101,6 +163,8 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 
 func (i *Identifier) String() string { return i.Value }
 
@@ -114,6 +178,8 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position  { return endOf(rs.ReturnValue, rs.Token) }
 
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
@@ -140,6 +206,8 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExpressionStatement) End() token.Position  { return endOf(es.Expression, es.Token) }
 
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
@@ -156,6 +224,8 @@ type IntegerLiteral struct {
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
 
 type FloatLiteral struct {
 	Token token.Token
@@ -165,6 +235,8 @@ type FloatLiteral struct {
 func (fl *FloatLiteral) expressionNode()      {}
 func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type PrefixExpression struct {
 	Token token.Token
@@ -176,6 +248,8 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position  { return endOf(pe.Right, pe.Token) }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -198,6 +272,8 @@ type InfixExpression struct {
 
 func (oe *InfixExpression) expressionNode()      {}
 func (oe *InfixExpression) TokenLiteral() string { return oe.Token.Literal }
+func (oe *InfixExpression) Pos() token.Position  { return posOf(oe.Left, oe.Token) }
+func (oe *InfixExpression) End() token.Position  { return endOf(oe.Right, oe.Token) }
 func (oe *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -210,6 +286,36 @@ func (oe *InfixExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression stores the value into the variable or the element of array or hash.
+//
+//	<identifier> = <expression>
+//	<expression>[<expression>] += <expression>
+//
+// It is evaluated to the assigned value.
+type AssignExpression struct {
+	// Token is the assignment operator.
+	Token    token.Token
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return posOf(ae.Target, ae.Token) }
+func (ae *AssignExpression) End() token.Position  { return endOf(ae.Value, ae.Token) }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -218,6 +324,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 // IfExpression represents the structure of If expression in Monkey which looks like this:
 //   if (<condition>) <consequence> else <alternative>
@@ -233,6 +341,16 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return endOf(ie.Condition, ie.Token)
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -249,13 +367,333 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// WhileExpression repeats the body as long as the condition is truthy.
+//
+//	while (<condition>) <body>
+//
+// Loops are expressions like if, and they are evaluated to null.
+type WhileExpression struct {
+	Token     token.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (we *WhileExpression) expressionNode()      {}
+func (we *WhileExpression) TokenLiteral() string { return we.Token.Literal }
+func (we *WhileExpression) Pos() token.Position  { return we.Token.Pos }
+func (we *WhileExpression) End() token.Position {
+	if we.Body != nil {
+		return we.Body.End()
+	}
+	return endOf(we.Condition, we.Token)
+}
+func (we *WhileExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(we.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(we.Body.String())
+
+	return out.String()
+}
+
+// ForExpression runs the body for each element of the iterable, which is bound to Variable.
+//
+//	for (<variable> in <iterable>) <body>
+//
+// Arrays yield their elements, strings their characters and hashes their keys.
+type ForExpression struct {
+	Token    token.Token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fe *ForExpression) expressionNode()      {}
+func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForExpression) Pos() token.Position  { return fe.Token.Pos }
+func (fe *ForExpression) End() token.Position {
+	if fe.Body != nil {
+		return fe.Body.End()
+	}
+	return endOf(fe.Iterable, fe.Token)
+}
+func (fe *ForExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fe.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fe.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fe.Body.String())
+
+	return out.String()
+}
+
+// BreakStatement leaves the innermost loop.
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+
+// ContinueStatement skips the rest of the body of the innermost loop.
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+
+// ThrowStatement raises the exception with the value, which is caught by the enclosing try expression.
+//
+//	throw <value>;
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) End() token.Position  { return endOf(ts.Value, ts.Token) }
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+// TryExpression evaluates the block, and the catch block with the exception bound to Parameter
+// if the block raises one. The finally block runs at last whether the exception is raised or not.
+//
+//	try <block> catch (<parameter>) <catch> finally <finally>
+//
+// Either the catch block or the finally block can be omitted, and so can the parameter.
+// The try expression results in the value of the block or the catch block.
+type TryExpression struct {
+	Token     token.Token // the 'try' token
+	Block     *BlockStatement
+	Parameter *Identifier
+	Catch     *BlockStatement
+	Finally   *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) End() token.Position {
+	if te.Finally != nil {
+		return te.Finally.End()
+	}
+	if te.Catch != nil {
+		return te.Catch.End()
+	}
+	return endOf(te.Block, te.Token)
+}
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.Parameter != nil {
+			out.WriteString("(" + te.Parameter.String() + ") ")
+		}
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+// MatchExpression compares the subject with the patterns of the arms in order, and results in the body
+// of the first arm whose pattern matches and whose guard, if any, is truthy. It results in null if none does.
+//
+//	match (<subject>) { <pattern> => <body>, <pattern> if <guard> => { <body> }, ... }
+//
+// The patterns are the literals, the identifiers binding the values, "_" matching anything,
+// ArrayPattern and HashPattern.
+type MatchExpression struct {
+	Token   token.Token // the 'match' token
+	Subject Expression
+	Arms    []*MatchArm
+
+	// Rbrace is the position just after the closing brace.
+	Rbrace token.Position
+}
+
+// MatchArm is the arm of the match expression. Guard is nil if the arm has no guard,
+// and Body holds the single expression if the arm has no braces.
+type MatchArm struct {
+	Pattern Expression
+	Guard   Expression
+	Body    *BlockStatement
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) End() token.Position {
+	if me.Rbrace.IsValid() {
+		return me.Rbrace
+	}
+	return endOf(me.Subject, me.Token)
+}
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		s := arm.Pattern.String()
+		if arm.Guard != nil {
+			s += " if " + arm.Guard.String()
+		}
+		arms = append(arms, s+" => "+arm.Body.String())
+	}
+
+	out.WriteString("match ")
+	out.WriteString(me.Subject.String())
+	out.WriteString(" { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// ArrayPattern matches the array of as many elements as the patterns, each of which matches its element.
+// If Rest is not nil, it matches the array of at least as many elements, and Rest is bound
+// to the array of the remaining elements.
+//
+//	[<pattern>, <pattern>, ...<identifier>]
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []Expression
+	Rest     *Identifier
+
+	// Rbracket is the position just after the closing bracket.
+	Rbracket token.Position
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+func (ap *ArrayPattern) End() token.Position {
+	if ap.Rbracket.IsValid() {
+		return ap.Rbracket
+	}
+	return ap.Token.End
+}
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern matches the hash which has all of the keys, whose values match their patterns.
+// The keys are string or integer literals, and the other keys of the hash are ignored.
+// The key written as an identifier is the string literal of its name, and the key without
+// the pattern binds the identifier of the same name.
+//
+//	{<key>: <pattern>, <key>: <pattern>, <identifier>, ...}
+type HashPattern struct {
+	Token token.Token // the '{' token
+	Pairs []*PatternPair
+
+	// Rbrace is the position just after the closing brace.
+	Rbrace token.Position
+}
+
+// PatternPair is the key of the hash pattern and the pattern of its value.
+type PatternPair struct {
+	Key   Expression
+	Value Expression
+}
+
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) End() token.Position {
+	if hp.Rbrace.IsValid() {
+		return hp.Rbrace
+	}
+	return hp.Token.End
+}
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for _, pair := range hp.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// PatternNames returns the identifiers bound by the pattern in the order of appearance,
+// except the wildcards.
+func PatternNames(pattern Expression) []*Identifier {
+	names := []*Identifier{}
+
+	var walk func(Expression)
+	walk = func(pattern Expression) {
+		switch pattern := pattern.(type) {
+		case *Identifier:
+			if pattern.Value != "_" {
+				names = append(names, pattern)
+			}
+		case *ArrayPattern:
+			for _, el := range pattern.Elements {
+				walk(el)
+			}
+			if pattern.Rest != nil {
+				walk(pattern.Rest)
+			}
+		case *HashPattern:
+			for _, pair := range pattern.Pairs {
+				walk(pair.Value)
+			}
+		}
+	}
+	walk(pattern)
+
+	return names
+}
+
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+
+	// Rbrace is the position just after the closing brace.
+	Rbrace token.Position
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position {
+	if bs.Rbrace.IsValid() {
+		return bs.Rbrace
+	}
+	if len(bs.Statements) > 0 {
+		return bs.Statements[len(bs.Statements)-1].End()
+	}
+	return bs.Token.End
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -270,32 +708,60 @@ func (bs *BlockStatement) String() string {
 // followed by a block statement which is the function's body.FunctionLiteral
 //   fn <parameters> <block statement>
 // parameters are just a list of identifiers with comma-separated.
-//   fn (<parameter one>, <parameter two>, <parameter three>, ...)
+// The last parameters can have default values, and the rest parameter collects the remaining arguments.
+//   fn (<parameter one>, <parameter two> = <expression>, ...<rest>)
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+
+	// Defaults holds the default value of each parameter, which is nil for the required ones.
+	// Defaults is nil if no parameter has a default value.
+	Defaults []Expression
+	// Rest is the rest parameter bound to the array of the remaining arguments, if any.
+	Rest *Identifier
+
+	// Name is the name of the let statement binding the function, if any.
+	Name string
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
-	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
-
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(strings.Join(ParameterStrings(fl.Parameters, fl.Defaults, fl.Rest), ", "))
 	out.WriteString(")")
 	out.WriteString(fl.Body.String())
 
 	return out.String()
 }
 
+// ParameterStrings formats the parameters of the function with their default values and the rest parameter.
+func ParameterStrings(params []*Identifier, defaults []Expression, rest *Identifier) []string {
+	strs := []string{}
+	for i, p := range params {
+		if i < len(defaults) && defaults[i] != nil {
+			strs = append(strs, p.String()+" = "+defaults[i].String())
+			continue
+		}
+		strs = append(strs, p.String())
+	}
+	if rest != nil {
+		strs = append(strs, "..."+rest.String())
+	}
+	return strs
+}
+
 // CallExpression represents call expression.
 //   <expression>(<comma separated expressions>)
 // It may follow the identifier (expression) and has arguments (list of expressions):
@@ -307,10 +773,20 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+
+	// Rparen is the position just after the closing parenthesis.
+	Rparen token.Position
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return posOf(ce.Function, ce.Token) }
+func (ce *CallExpression) End() token.Position {
+	if ce.Rparen.IsValid() {
+		return ce.Rparen
+	}
+	return ce.Token.End
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -327,6 +803,33 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// SpreadExpression passes the elements of the array as the arguments of the call.
+// It is only allowed in the arguments of call expressions.
+//
+//	...<expression>
+type SpreadExpression struct {
+	Token token.Token // the '...' token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) Pos() token.Position  { return se.Token.Pos }
+func (se *SpreadExpression) End() token.Position  { return endOf(se.Value, se.Token) }
+func (se *SpreadExpression) String() string       { return "..." + se.Value.String() }
+
+// NullLiteral has no syntax of its own. It only appears in the nodes produced by macros
+// to put the null value back into the program.
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (nl *NullLiteral) expressionNode()      {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) String() string       { return "null" }
+func (nl *NullLiteral) Pos() token.Position  { return nl.Token.Pos }
+func (nl *NullLiteral) End() token.Position  { return nl.Token.End }
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -335,14 +838,63 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
+
+// InterpolatedString is the string with the interpolated expressions
+//
+//	"hello ${<expression>}, bye"
+//
+// Parts holds the texts around the expressions as *StringLiteral and the expressions in order.
+// The empty texts are omitted.
+type InterpolatedString struct {
+	Token token.Token // the token.STRING_HEAD token
+	Parts []Expression
+
+	// Quote is the position just after the closing double quote.
+	Quote token.Position
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) End() token.Position {
+	if is.Quote.IsValid() {
+		return is.Quote
+	}
+	return is.Token.End
+}
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+
+	for _, part := range is.Parts {
+		if text, ok := part.(*StringLiteral); ok {
+			out.WriteString(text.String())
+			continue
+		}
+		out.WriteString("${" + part.String() + "}")
+	}
+
+	return out.String()
+}
 
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+
+	// Rbracket is the position just after the closing bracket.
+	Rbracket token.Position
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position {
+	if al.Rbracket.IsValid() {
+		return al.Rbracket
+	}
+	return al.Token.End
+}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -364,10 +916,20 @@ type IndexExpression struct {
 	Token token.Token
 	Left  Expression
 	Index Expression
+
+	// Rbracket is the position just after the closing bracket.
+	Rbracket token.Position
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return posOf(ie.Left, ie.Token) }
+func (ie *IndexExpression) End() token.Position {
+	if ie.Rbracket.IsValid() {
+		return ie.Rbracket
+	}
+	return endOf(ie.Index, ie.Token)
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -385,10 +947,20 @@ func (ie *IndexExpression) String() string {
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+
+	// Rbrace is the position just after the closing brace.
+	Rbrace token.Position
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position {
+	if hl.Rbrace.IsValid() {
+		return hl.Rbrace
+	}
+	return hl.Token.End
+}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -412,6 +984,13 @@ type MacroLiteral struct {
 
 func (ml *MacroLiteral) expressionNode()      {}
 func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Pos }
+func (ml *MacroLiteral) End() token.Position {
+	if ml.Body != nil {
+		return ml.Body.End()
+	}
+	return ml.Token.End
+}
 func (ml *MacroLiteral) String() string {
 	var out bytes.Buffer
 
@@ -428,3 +1007,45 @@ func (ml *MacroLiteral) String() string {
 
 	return out.String()
 }
+
+// ImportExpression evaluates the module at the path once and results in the hash of its exports.
+//
+//	import("lib/strings")
+type ImportExpression struct {
+	Token token.Token // the 'import' token
+	Path  *StringLiteral
+
+	// Rparen is the position just after the closing parenthesis.
+	Rparen token.Position
+}
+
+func (ie *ImportExpression) expressionNode()      {}
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *ImportExpression) End() token.Position {
+	if ie.Rparen.IsValid() {
+		return ie.Rparen
+	}
+	return ie.Token.End
+}
+func (ie *ImportExpression) String() string {
+	return fmt.Sprintf("%s(%q)", ie.TokenLiteral(), ie.Path.Value)
+}
+
+// posOf returns the starting position of the node, or the position of the fallback token
+// when the node is missing, e.g. because of the parse errors or the macro expansion.
+func posOf(node Node, fallback token.Token) token.Position {
+	if node == nil || !node.Pos().IsValid() {
+		return fallback.Pos
+	}
+	return node.Pos()
+}
+
+// endOf returns the end position of the node, or the end of the fallback token
+// when the node is missing.
+func endOf(node Node, fallback token.Token) token.Position {
+	if node == nil || !node.End().IsValid() {
+		return fallback.End
+	}
+	return node.End()
+}
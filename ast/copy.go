@@ -0,0 +1,259 @@
+package ast
+
+// Copy returns a deep copy of the node. Modify changes the tree in place, so the tree
+// which is used more than once, e.g. the body of a macro, is copied before being modified.
+// The nodes of the unknown types are shared with the original tree.
+func Copy(node Node) Node {
+	switch node := node.(type) {
+	case *Program:
+		c := *node
+		c.Statements = copyStatements(node.Statements)
+		return &c
+
+	case *ExpressionStatement:
+		c := *node
+		c.Expression = copyExpression(node.Expression)
+		return &c
+
+	case *LetStatement:
+		c := *node
+		c.Name = copyIdentifier(node.Name)
+		c.Pattern = copyExpression(node.Pattern)
+		c.Value = copyExpression(node.Value)
+		return &c
+
+	case *ReturnStatement:
+		c := *node
+		c.ReturnValue = copyExpression(node.ReturnValue)
+		return &c
+
+	case *BlockStatement:
+		return copyBlock(node)
+
+	case *Identifier:
+		return copyIdentifier(node)
+
+	case *IntegerLiteral:
+		c := *node
+		return &c
+
+	case *FloatLiteral:
+		c := *node
+		return &c
+
+	case *Boolean:
+		c := *node
+		return &c
+
+	case *StringLiteral:
+		c := *node
+		return &c
+
+	case *NullLiteral:
+		c := *node
+		return &c
+
+	case *AssignExpression:
+		c := *node
+		c.Target = copyExpression(node.Target)
+		c.Value = copyExpression(node.Value)
+		return &c
+
+	case *PrefixExpression:
+		c := *node
+		c.Right = copyExpression(node.Right)
+		return &c
+
+	case *InfixExpression:
+		c := *node
+		c.Left = copyExpression(node.Left)
+		c.Right = copyExpression(node.Right)
+		return &c
+
+	case *IfExpression:
+		c := *node
+		c.Condition = copyExpression(node.Condition)
+		c.Consequence = copyBlock(node.Consequence)
+		c.Alternative = copyBlock(node.Alternative)
+		return &c
+
+	case *WhileExpression:
+		c := *node
+		c.Condition = copyExpression(node.Condition)
+		c.Body = copyBlock(node.Body)
+		return &c
+
+	case *ForExpression:
+		c := *node
+		c.Variable = copyIdentifier(node.Variable)
+		c.Iterable = copyExpression(node.Iterable)
+		c.Body = copyBlock(node.Body)
+		return &c
+
+	case *BreakStatement:
+		c := *node
+		return &c
+
+	case *ContinueStatement:
+		c := *node
+		return &c
+
+	case *ThrowStatement:
+		c := *node
+		c.Value = copyExpression(node.Value)
+		return &c
+
+	case *TryExpression:
+		c := *node
+		c.Block = copyBlock(node.Block)
+		c.Parameter = copyIdentifier(node.Parameter)
+		c.Catch = copyBlock(node.Catch)
+		c.Finally = copyBlock(node.Finally)
+		return &c
+
+	case *MatchExpression:
+		c := *node
+		c.Subject = copyExpression(node.Subject)
+		c.Arms = make([]*MatchArm, len(node.Arms))
+		for i, arm := range node.Arms {
+			c.Arms[i] = &MatchArm{
+				Pattern: copyExpression(arm.Pattern),
+				Guard:   copyExpression(arm.Guard),
+				Body:    copyBlock(arm.Body),
+			}
+		}
+		return &c
+
+	case *ArrayPattern:
+		c := *node
+		c.Elements = copyExpressions(node.Elements)
+		c.Rest = copyIdentifier(node.Rest)
+		return &c
+
+	case *HashPattern:
+		c := *node
+		c.Pairs = make([]*PatternPair, len(node.Pairs))
+		for i, pair := range node.Pairs {
+			c.Pairs[i] = &PatternPair{Key: copyExpression(pair.Key), Value: copyExpression(pair.Value)}
+		}
+		return &c
+
+	case *FunctionLiteral:
+		c := *node
+		c.Parameters = copyIdentifiers(node.Parameters)
+		c.Defaults = copyExpressions(node.Defaults)
+		c.Rest = copyIdentifier(node.Rest)
+		c.Body = copyBlock(node.Body)
+		return &c
+
+	case *SpreadExpression:
+		c := *node
+		c.Value = copyExpression(node.Value)
+		return &c
+
+	case *MacroLiteral:
+		c := *node
+		c.Parameters = copyIdentifiers(node.Parameters)
+		c.Body = copyBlock(node.Body)
+		return &c
+
+	case *CallExpression:
+		c := *node
+		c.Function = copyExpression(node.Function)
+		c.Arguments = copyExpressions(node.Arguments)
+		return &c
+
+	case *ArrayLiteral:
+		c := *node
+		c.Elements = copyExpressions(node.Elements)
+		return &c
+
+	case *InterpolatedString:
+		c := *node
+		c.Parts = copyExpressions(node.Parts)
+		return &c
+
+	case *ImportExpression:
+		c := *node
+		path := *node.Path
+		c.Path = &path
+		return &c
+
+	case *IndexExpression:
+		c := *node
+		c.Left = copyExpression(node.Left)
+		c.Index = copyExpression(node.Index)
+		return &c
+
+	case *HashLiteral:
+		c := *node
+		c.Pairs = make(map[Expression]Expression, len(node.Pairs))
+		for key, value := range node.Pairs {
+			c.Pairs[copyExpression(key)] = copyExpression(value)
+		}
+		return &c
+
+	default:
+		return node
+	}
+}
+
+func copyExpression(exp Expression) Expression {
+	if exp == nil {
+		return nil
+	}
+	c, _ := Copy(exp).(Expression)
+	return c
+}
+
+func copyExpressions(exps []Expression) []Expression {
+	if exps == nil {
+		return nil
+	}
+	c := make([]Expression, len(exps))
+	for i, exp := range exps {
+		c[i] = copyExpression(exp)
+	}
+	return c
+}
+
+func copyStatements(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	c := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		if stmt != nil {
+			c[i], _ = Copy(stmt).(Statement)
+		}
+	}
+	return c
+}
+
+func copyIdentifier(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	c := *ident
+	return &c
+}
+
+func copyIdentifiers(idents []*Identifier) []*Identifier {
+	if idents == nil {
+		return nil
+	}
+	c := make([]*Identifier, len(idents))
+	for i, ident := range idents {
+		c[i] = copyIdentifier(ident)
+	}
+	return c
+}
+
+func copyBlock(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	c := *block
+	c.Statements = copyStatements(block.Statements)
+	return &c
+}
@@ -51,6 +51,10 @@ func TestModify(t *testing.T) {
 			&InfixExpression{Left: two(), Operator: "+", Right: one()},
 			&InfixExpression{Left: two(), Operator: "+", Right: two()},
 		},
+		{
+			&AssignExpression{Target: one(), Operator: "=", Value: one()},
+			&AssignExpression{Target: two(), Operator: "=", Value: two()},
+		},
 		{
 			&PrefixExpression{Operator: "-", Right: one()},
 			&PrefixExpression{Operator: "-", Right: two()},
@@ -117,6 +121,119 @@ func TestModify(t *testing.T) {
 			&ArrayLiteral{Elements: []Expression{one(), one()}},
 			&ArrayLiteral{Elements: []Expression{two(), two()}},
 		},
+		{
+			&InterpolatedString{Parts: []Expression{one(), one()}},
+			&InterpolatedString{Parts: []Expression{two(), two()}},
+		},
+		{
+			&WhileExpression{
+				Condition: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&WhileExpression{
+				Condition: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ForExpression{
+				Variable: &Identifier{Value: "x"},
+				Iterable: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&ForExpression{
+				Variable: &Identifier{Value: "x"},
+				Iterable: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{one(), one()}},
+			&CallExpression{Function: two(), Arguments: []Expression{two(), two()}},
+		},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{&SpreadExpression{Value: one()}}},
+			&CallExpression{Function: two(), Arguments: []Expression{&SpreadExpression{Value: two()}}},
+		},
+		{
+			&FunctionLiteral{
+				Parameters: []*Identifier{{Value: "a"}, {Value: "b"}},
+				Defaults:   []Expression{nil, one()},
+				Rest:       &Identifier{Value: "c"},
+				Body:       &BlockStatement{Statements: []Statement{}},
+			},
+			&FunctionLiteral{
+				Parameters: []*Identifier{{Value: "a"}, {Value: "b"}},
+				Defaults:   []Expression{nil, two()},
+				Rest:       &Identifier{Value: "c"},
+				Body:       &BlockStatement{Statements: []Statement{}},
+			},
+		},
+		{
+			&MatchExpression{
+				Subject: one(),
+				Arms: []*MatchArm{
+					{
+						Pattern: &ArrayPattern{Elements: []Expression{one(), &Identifier{Value: "x"}}},
+						Guard:   one(),
+						Body: &BlockStatement{
+							Statements: []Statement{
+								&ExpressionStatement{Expression: one()},
+							},
+						},
+					},
+					{
+						Pattern: &HashPattern{Pairs: []*PatternPair{{Key: one(), Value: one()}}},
+						Body:    &BlockStatement{Statements: []Statement{}},
+					},
+				},
+			},
+			&MatchExpression{
+				Subject: two(),
+				Arms: []*MatchArm{
+					{
+						Pattern: &ArrayPattern{Elements: []Expression{two(), &Identifier{Value: "x"}}},
+						Guard:   two(),
+						Body: &BlockStatement{
+							Statements: []Statement{
+								&ExpressionStatement{Expression: two()},
+							},
+						},
+					},
+					{
+						// The keys are literals compared as they are.
+						Pattern: &HashPattern{Pairs: []*PatternPair{{Key: one(), Value: two()}}},
+						Body:    &BlockStatement{Statements: []Statement{}},
+					},
+				},
+			},
+		},
+		{
+			&LetStatement{
+				Pattern: &ArrayPattern{Elements: []Expression{one()}, Rest: &Identifier{Value: "r"}},
+				Value:   one(),
+			},
+			&LetStatement{
+				Pattern: &ArrayPattern{Elements: []Expression{two()}, Rest: &Identifier{Value: "r"}},
+				Value:   two(),
+			},
+		},
 	}
 
 	for _, test := range tests {
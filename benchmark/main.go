@@ -38,7 +38,11 @@ func main() {
 
 	l := lexer.New(input)
 	p := parser.New(l)
-	program := p.ParseProgram()
+	program, diagnostics := evaluator.ExpandProgram(p.ParseProgram(), object.NewEnvironment())
+	if len(diagnostics) != 0 {
+		fmt.Printf("macro error: %s", diagnostics[0])
+		return
+	}
 	fmt.Println(*engine)
 
 	if *engine == "vm" {
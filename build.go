@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/toversus/monkey/compiler"
+)
+
+// bytecodeExt is the extension of the serialized bytecode written by the build command by default.
+const bytecodeExt = ".mkc"
+
+// build compiles the script and writes the serialized bytecode, which the run command loads
+// without parsing the source code again. It returns the exit code of the process.
+func build(args []string) int {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	output := fs.String("o", "", "write the bytecode to the `file` instead of <script>"+bytecodeExt)
+	strip := fs.Bool("strip", false, "omit the function names and line tables used by stack traces")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: monkey build [-o file] [-strip] <file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	bytecode, ok := compileFile(path, string(src), nil)
+	if !ok {
+		return 1
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + bytecodeExt
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = compiler.Encode(f, bytecode, !*strip)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *output, err)
+		return 1
+	}
+
+	return 0
+}
@@ -57,6 +57,11 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
 }
 
+// Version identifies the set of opcodes and their operands.
+// It must be incremented whenever the definitions change, so that serialized bytecode
+// compiled for another set of opcodes is rejected instead of being misinterpreted.
+const Version = 12
+
 // Opcode has an arbitary but unique value and is the first byte in the instruction.
 type Opcode byte
 
@@ -107,6 +112,39 @@ const (
 	OpClosure // send a message to wrap the specified compiled function in an closure
 
 	OpGetFree // get binding for free variables
+
+	OpGetIter  // replace the iterable on top of the stack with its iterator
+	OpIterNext // push the next element of the iterator below and true, or only false at the end
+
+	OpSetFree      // set binding for free variables through their cells
+	OpCaptureLocal // push the cell of the local variable to be captured by a closure
+	OpCaptureFree  // push the cell of the free variable to be captured by a nested closure
+
+	OpSetIndex // store the value into the element, taking the object, the index and the value off the stack
+	OpDup      // duplicate N elements on top of the stack
+
+	OpCurrentClosure // push the closure currently executing for the self-reference
+
+	OpTailCall // function call in tail position which reuses the frame of the caller
+
+	OpMod                // '%'
+	OpGreaterThanOrEqual // '>=', and '<=' is generated reordering of code like OpGreaterThan.
+
+	OpConcat // takes N values off the stack and concatenates their string representations
+
+	OpImport // push the exports of the module cached in the global, running the module at the first import
+
+	OpThrow   // raise the exception with the value taken off the stack
+	OpCatch   // replace the exception on top of the stack with the value the catch block receives
+	OpRethrow // raise the exception taken off the stack again after the finally block
+
+	OpMatchValue // replace the value and the literal on top of the stack with whether they are equal
+	OpMatchArray // replace the value on top of the stack with whether it is an array of N elements, or at least N if flagged
+	OpMatchHash  // replace the value and N keys above it with whether it is a hash having all of the keys
+
+	OpSlice // replace the array on top of the stack with the new array of its elements from the index N
+
+	OpCallSpread // function call with the arguments concatenated from N arrays on the stack
 )
 
 var definitions = map[Opcode]*Definition{
@@ -156,6 +194,39 @@ var definitions = map[Opcode]*Definition{
 	OpClosure: {"OpClosure", []int{2, 1}}, // the constant index and the count of free variables
 
 	OpGetFree: {"OpGetFree", []int{1}},
+
+	OpGetIter:  {"OpGetIter", []int{}},
+	OpIterNext: {"OpIterNext", []int{}},
+
+	OpSetFree:      {"OpSetFree", []int{1}},
+	OpCaptureLocal: {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:  {"OpCaptureFree", []int{1}},
+
+	OpSetIndex: {"OpSetIndex", []int{}},
+	OpDup:      {"OpDup", []int{1}},
+
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	OpTailCall: {"OpTailCall", []int{1}},
+
+	OpMod:                {"OpMod", []int{}},
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+
+	OpConcat: {"OpConcat", []int{2}},
+
+	OpImport: {"OpImport", []int{2, 2}}, // the global caching the exports and the constant index of the module
+
+	OpThrow:   {"OpThrow", []int{}},
+	OpCatch:   {"OpCatch", []int{}},
+	OpRethrow: {"OpRethrow", []int{}},
+
+	OpMatchValue: {"OpMatchValue", []int{}},
+	OpMatchArray: {"OpMatchArray", []int{2, 1}}, // the number of elements and whether more elements are allowed
+	OpMatchHash:  {"OpMatchHash", []int{2}},
+
+	OpSlice: {"OpSlice", []int{2}},
+
+	OpCallSpread: {"OpCallSpread", []int{1}},
 }
 
 // Lookup gets to the definition of opcode.
@@ -1,6 +1,10 @@
 package code
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/toversus/monkey/token"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -90,3 +94,35 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLineTableLookup(t *testing.T) {
+	lines := LineTable{
+		{Offset: 0, Pos: token.Position{Line: 1, Column: 1}},
+		{Offset: 3, Pos: token.Position{Line: 2, Column: 5}},
+		{Offset: 7, Pos: token.Position{Line: 4, Column: 2}},
+	}
+
+	tests := []struct {
+		offset   int
+		expected int
+	}{
+		{0, 1},
+		{2, 1},
+		{3, 2},
+		{6, 2},
+		{7, 4},
+		{100, 4},
+	}
+
+	for _, test := range tests {
+		pos := lines.Lookup(test.offset)
+		if pos.Line != test.expected {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d",
+				test.offset, test.expected, pos.Line)
+		}
+	}
+
+	if pos := (LineTable{}).Lookup(0); pos.IsValid() {
+		t.Errorf("empty line table returned valid position: %s", pos)
+	}
+}
@@ -0,0 +1,25 @@
+package code
+
+// Handler is an entry of the exception table. The exception raised by the instructions
+// from Start up to End, exclusive, is handled by the instructions at Target, which find
+// the exception pushed on the stack of Depth values above the locals of the function.
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Depth  int
+}
+
+// HandlerTable is the exception table of a function. The inner try expressions come first,
+// so the first entry covering the offset of the instruction handles the exception raised by it.
+type HandlerTable []Handler
+
+// Lookup returns the handler of the exception raised by the instruction at the given offset.
+func (ht HandlerTable) Lookup(offset int) (Handler, bool) {
+	for _, h := range ht {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
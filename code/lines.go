@@ -0,0 +1,29 @@
+package code
+
+import (
+	"sort"
+
+	"github.com/toversus/monkey/token"
+)
+
+// LineEntry associates the instruction starting at Offset with the position in the source code
+// which the instruction was compiled from.
+type LineEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable maps the offsets in Instructions back to the source code.
+// The entries are sorted by Offset and an entry covers every instruction
+// until the offset of the next entry.
+type LineTable []LineEntry
+
+// Lookup returns the source position of the instruction at the given offset.
+// It returns the zero value of token.Position if there is no entry covering the offset.
+func (lt LineTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return lt[i-1].Pos
+}
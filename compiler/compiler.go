@@ -3,10 +3,12 @@ package compiler
 import (
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/toversus/monkey/ast"
 	"github.com/toversus/monkey/code"
 	"github.com/toversus/monkey/object"
+	"github.com/toversus/monkey/token"
 )
 
 // Compiler holds the generated bytecode for instructions
@@ -18,6 +20,32 @@ type Compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// pos is the position of the node being compiled, which is recorded
+	// in the line table of the emitted instructions.
+	pos token.Position
+
+	// tail tells that the node compiled next is in tail position,
+	// where a call is compiled into OpTailCall.
+	tail bool
+
+	// importer finds the modules imported by the program, which can't import without it.
+	importer object.Importer
+
+	// modules are the modules compiled so far by their paths, and loading are the paths
+	// of the modules being compiled, from the outermost one, to detect the import cycle.
+	modules map[string]compiledModule
+	loading []string
+
+	// globals is the symbol table of the main program, which defines the globals caching the modules.
+	globals *SymbolTable
+}
+
+// compiledModule is the module compiled into a function, which returns its exports
+// after storing them in the global.
+type compiledModule struct {
+	global   int
+	constant int
 }
 
 // New implements constructor of Compiler struct.
@@ -50,24 +78,44 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	return compiler
 }
 
+// SetImporter enables the imports in the program being compiled.
+func (c *Compiler) SetImporter(importer object.Importer) {
+	c.importer = importer
+}
+
 // Compile has empty method right now.
 func (c *Compiler) Compile(node ast.Node) error {
+	if node != nil && node.Pos().IsValid() {
+		outer := c.pos
+		c.pos = node.Pos()
+		defer func() { c.pos = outer }()
+	}
+
+	// Only the node given right after setting tail is in tail position, not its children.
+	tail := c.tail
+	c.tail = false
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
 			if err := c.Compile(s); err != nil {
-				return nil
+				return err
 			}
 		}
 
 	case *ast.ExpressionStatement:
+		c.tail = tail
 		if err := c.Compile(node.Expression); err != nil {
 			return err
 		}
 		c.emit(code.OpPop)
 
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
+		}
+
+		if node.Operator == "<" || node.Operator == "<=" {
 			err := c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -75,15 +123,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 			err = c.Compile(node.Left)
 			if err != nil {
-				return nil
+				return err
+			}
+
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterThanOrEqual)
 			}
-			c.emit(code.OpGreaterThan)
 			return nil
 		}
 
 		err := c.Compile(node.Left)
 		if err != nil {
-			return nil
+			return err
 		}
 
 		err = c.Compile(node.Right)
@@ -100,14 +153,18 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 
 	case *ast.IntegerLiteral:
@@ -136,7 +193,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 
 	case *ast.IfExpression:
@@ -148,6 +205,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// Emit an 'OpJumpNotTruthy' with a bogus value
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
+		c.tail = tail
 		err = c.Compile(node.Consequence)
 		if err != nil {
 			return err
@@ -166,6 +224,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
+			c.tail = tail
 			err := c.Compile(node.Alternative)
 			if err != nil {
 				return err
@@ -179,38 +238,151 @@ func (c *Compiler) Compile(node ast.Node) error {
 		afterAltenativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAltenativePos)
 
+	case *ast.AssignExpression:
+		return c.compileAssignment(node)
+
+	case *ast.WhileExpression:
+		start := len(c.currentInstructions())
+
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.enterLoop(start)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+
+		end := len(c.currentInstructions())
+		c.changeOperand(exitPos, end)
+		c.leaveLoop(end)
+
+		c.emit(code.OpNull)
+
+	case *ast.ForExpression:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+
+		// The iterator stays on the stack until the end of the loop.
+		c.emit(code.OpGetIter)
+
+		start := c.emit(code.OpIterNext)
+		exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.storeSymbol(c.symbolTable.Define(node.Variable.Value))
+
+		c.enterLoop(start)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+
+		end := len(c.currentInstructions())
+		c.changeOperand(exitPos, end)
+		c.leaveLoop(end)
+
+		c.emit(code.OpPop)
+		c.emit(code.OpNull)
+
+	case *ast.BreakStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return fmt.Errorf("%s: break outside loop", node.Pos())
+		}
+		if c.scopes[c.scopeIndex].finally > l.finally {
+			return fmt.Errorf("%s: break out of finally block", node.Pos())
+		}
+		c.popToLoop(l)
+		if err := c.inlineFinally(l.tries); err != nil {
+			return err
+		}
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return fmt.Errorf("%s: continue outside loop", node.Pos())
+		}
+		if c.scopes[c.scopeIndex].finally > l.finally {
+			return fmt.Errorf("%s: continue out of finally block", node.Pos())
+		}
+		c.popToLoop(l)
+		if err := c.inlineFinally(l.tries); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, l.start)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.TryExpression:
+		return c.compileTry(node)
+
+	case *ast.MatchExpression:
+		return c.compileMatch(node, tail)
+
+	case *ast.SpreadExpression:
+		return fmt.Errorf("%s: spread is only allowed in call arguments", node.Pos())
+
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
+		for i, s := range node.Statements {
+			c.tail = tail && i == len(node.Statements)-1
 			if err := c.Compile(s); err != nil {
 				return err
 			}
 		}
 
 	case *ast.LetStatement:
+		if node.Pattern != nil {
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+			return c.compileDestructure(node.Pattern)
+		}
+
 		symbol := c.symbolTable.Define(node.Name.Value)
 
 		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeSymbol(symbol)
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable: %s", node.Value)
+			return fmt.Errorf("%s: undefined variable: %s", node.Pos(), node.Value)
 		}
 
 		c.loadSymbol(symbol)
 
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
 
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			err := c.Compile(part)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpConcat, len(node.Parts))
+
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			err := c.Compile(el)
@@ -260,12 +432,45 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpIndex)
 
 	case *ast.FunctionLiteral:
+		// The function bound by the let statement to a local variable refers to itself as the closure
+		// currently executing, unless the variable may be bound to another value afterwards.
+		// Otherwise the name is resolved to the variable like the evaluator does.
+		selfReference := node.Name != "" && c.scopeIndex > 0 && !c.scopes[c.scopeIndex].rebound[node.Name]
+
 		c.enterScope()
+		c.scopes[c.scopeIndex].rebound = reboundNames(node)
 
-		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
+		if selfReference {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		params := make([]Symbol, len(node.Parameters))
+		for i, p := range node.Parameters {
+			params[i] = c.symbolTable.DefineParameter(p.Value)
+		}
+		if node.Rest != nil {
+			c.symbolTable.DefineParameter(node.Rest.Value)
 		}
 
+		// The default values are stored in order from the first missing argument,
+		// where the call starts, and the call with all of the arguments starts at the body.
+		var entries []int
+		for i, value := range node.Defaults {
+			if value == nil {
+				continue
+			}
+			entries = append(entries, len(c.currentInstructions()))
+			if err := c.Compile(value); err != nil {
+				return err
+			}
+			c.storeSymbol(params[i])
+		}
+		if entries != nil {
+			entries = append(entries, len(c.currentInstructions()))
+		}
+
+		// The value of the last statement is returned from the function.
+		c.tail = true
 		err := c.Compile(node.Body)
 		if err != nil {
 			return err
@@ -281,35 +486,75 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.Names()
+		lines := c.scopes[c.scopeIndex].lines
+		handlers := c.handlerTable()
 		instructions := c.leaveScope()
 
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			c.captureSymbol(s)
+			freeNames[i] = s.Name
 		}
 
 		compiledFn := &object.CompiledFunction{
-			Instructions:  instructions,
-			NumLocals:     numLocals,
-			NumParameters: len(node.Parameters),
+			Instructions:   instructions,
+			NumLocals:      numLocals,
+			NumParameters:  len(node.Parameters),
+			DefaultEntries: entries,
+			Variadic:       node.Rest != nil,
+			Name:           node.Name,
+			Lines:          lines,
+			Handlers:       handlers,
+			LocalNames:     localNames,
+			FreeNames:      freeNames,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 
 	case *ast.ReturnStatement:
+		if c.scopes[c.scopeIndex].module {
+			return fmt.Errorf("%s: return outside function", node.Pos())
+		}
+		if c.scopes[c.scopeIndex].finally > 0 {
+			return fmt.Errorf("%s: return out of finally block", node.Pos())
+		}
+
+		// The main program has no frame for the call to reuse, and the call in the try expression
+		// must not leave the frame whose handlers catch its exceptions.
+		c.tail = c.scopeIndex > 0 && len(c.scopes[c.scopeIndex].tries) == 0
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
 
+		if err := c.inlineFinally(0); err != nil {
+			return err
+		}
 		c.emit(code.OpReturnValue)
 
+	case *ast.ImportExpression:
+		return c.compileImport(node)
+
+	case *ast.MacroLiteral:
+		return fmt.Errorf("%s: macro must be defined by a top-level let statement and expanded before compilation",
+			node.Pos())
+
 	case *ast.CallExpression:
+		if name, ok := macroBuiltinCall(node, c.symbolTable); ok {
+			return fmt.Errorf("%s: %s is only supported in the body of macro", node.Pos(), name)
+		}
+
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
 		}
 
+		if hasSpread(node.Arguments) {
+			return c.compileSpreadCall(node.Arguments)
+		}
+
 		for _, a := range node.Arguments {
 			err := c.Compile(a)
 			if err != nil {
@@ -317,20 +562,206 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
-		c.emit(code.OpCall, len(node.Arguments))
+		// The call in tail position is still followed by the return,
+		// which returns the result when the callee turns out to be a builtin at runtime.
+		if tail && !isBuiltinCall(node, c.symbolTable) {
+			c.emit(code.OpTailCall, len(node.Arguments))
+		} else {
+			c.emit(code.OpCall, len(node.Arguments))
+		}
 	}
 
 	return nil
 }
 
+// compileImport compiles the module at the first import, and emits the instruction
+// which runs the module only if its exports are not cached in the global yet.
+func (c *Compiler) compileImport(node *ast.ImportExpression) error {
+	if c.importer == nil {
+		return fmt.Errorf("%s: cannot import %q: imports are not enabled", node.Pos(), node.Path.Value)
+	}
+
+	path, err := c.importer.Resolve(node.Pos().Filename, node.Path.Value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", node.Pos(), err)
+	}
+
+	if c.modules == nil {
+		c.modules = map[string]compiledModule{}
+		c.globals = c.globalSymbolTable()
+	}
+
+	module, ok := c.modules[path]
+	if !ok {
+		for i, loading := range c.loading {
+			if loading == path {
+				cycle := append(c.loading[i:len(c.loading):len(c.loading)], path)
+				return fmt.Errorf("%s: import cycle: %s", node.Pos(), strings.Join(cycle, " -> "))
+			}
+		}
+
+		program, err := c.importer.Load(path)
+		if err != nil {
+			return fmt.Errorf("%s: %s", node.Pos(), err)
+		}
+
+		c.loading = append(c.loading, path)
+		module, err = c.compileModule(path, program)
+		c.loading = c.loading[:len(c.loading)-1]
+		if err != nil {
+			return err
+		}
+		c.modules[path] = module
+	}
+
+	c.emit(code.OpImport, module.global, module.constant)
+	return nil
+}
+
+// compileModule compiles the module into a function, whose locals are the namespace of the module
+// seeing only the builtins. The function returns the hash of its exports after caching it in the global,
+// which the name containing '@' keeps from being referred to by the program.
+func (c *Compiler) compileModule(path string, program *ast.Program) (compiledModule, error) {
+	global := c.globals.Define("module@" + path)
+
+	importer := c.symbolTable
+	c.symbolTable = NewSymbolTable()
+	for i, v := range object.Builtins {
+		c.symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	c.enterScope()
+	c.scopes[c.scopeIndex].module = true
+	c.scopes[c.scopeIndex].rebound = reboundNames(program)
+
+	for _, s := range program.Statements {
+		if err := c.Compile(s); err != nil {
+			c.leaveScope()
+			c.symbolTable = importer
+			return compiledModule{}, err
+		}
+	}
+
+	exports := program.Exports()
+	for _, name := range exports {
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: name}))
+		symbol, _ := c.symbolTable.Resolve(name)
+		c.loadSymbol(symbol)
+	}
+	c.emit(code.OpHash, len(exports)*2)
+	c.emit(code.OpDup, 1)
+	c.emit(code.OpSetGlobal, global.Index)
+	c.emit(code.OpReturnValue)
+
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.Names()
+	lines := c.scopes[c.scopeIndex].lines
+	handlers := c.handlerTable()
+	instructions := c.leaveScope()
+	c.symbolTable = importer
+
+	fn := &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		Name:         "<module>",
+		Lines:        lines,
+		Handlers:     handlers,
+		LocalNames:   localNames,
+	}
+
+	return compiledModule{global: global.Index, constant: c.addConstant(fn)}, nil
+}
+
+// macroBuiltinCall checks whether the call is quote or unquote, which are only evaluated
+// during the macro expansion, unless the name is bound to a user-defined function.
+func macroBuiltinCall(node *ast.CallExpression, s *SymbolTable) (string, bool) {
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok || (ident.Value != "quote" && ident.Value != "unquote") {
+		return "", false
+	}
+
+	if _, ok := s.Resolve(ident.Value); ok {
+		return "", false
+	}
+	return ident.Value, true
+}
+
+// isBuiltinCall checks whether the callee is known to be a builtin at compile time,
+// which has no frame to reuse for the tail call.
+func isBuiltinCall(node *ast.CallExpression, s *SymbolTable) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok {
+		return false
+	}
+
+	symbol, ok := s.Resolve(ident.Value)
+	return ok && symbol.Scope == BuiltinScope
+}
+
+func hasSpread(args []ast.Expression) bool {
+	for _, a := range args {
+		if _, ok := a.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSpreadCall compiles the arguments into the arrays concatenated by the call:
+// each spread array as it is, and each run of the other arguments into a new array.
+// The call is never a tail call, since the number of the arguments is unknown until runtime.
+func (c *Compiler) compileSpreadCall(args []ast.Expression) error {
+	parts, pending := 0, 0
+	flush := func() {
+		if pending > 0 {
+			c.emit(code.OpArray, pending)
+			parts++
+			pending = 0
+		}
+	}
+
+	for _, a := range args {
+		spread, ok := a.(*ast.SpreadExpression)
+		if !ok {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+			pending++
+			continue
+		}
+
+		flush()
+		if err := c.Compile(spread.Value); err != nil {
+			return err
+		}
+		parts++
+	}
+	flush()
+
+	c.emit(code.OpCallSpread, parts)
+	return nil
+}
+
 // Bytecode represents what VM will recieve.
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Handlers:     c.handlerTable(),
+		GlobalNames:  c.globalSymbolTable().Names(),
 	}
 }
 
+// globalSymbolTable returns the outermost symbol table which holds the global variables.
+func (c *Compiler) globalSymbolTable() *SymbolTable {
+	s := c.symbolTable
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
 	c.constants = append(c.constants, obj)
 	return len(c.constants) - 1
@@ -354,9 +785,26 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	updateInstructions := append(c.currentInstructions(), ins...)
 
 	c.scopes[c.scopeIndex].instructions = updateInstructions
+	c.addLine(posNewInstruction)
+
 	return posNewInstruction
 }
 
+// addLine records the position of the node being compiled for the instruction at offset
+// unless the previous entry of the line table already covers it.
+func (c *Compiler) addLine(offset int) {
+	if !c.pos.IsValid() {
+		return
+	}
+
+	lines := c.scopes[c.scopeIndex].lines
+	if len(lines) > 0 && lines[len(lines)-1].Pos == c.pos {
+		return
+	}
+
+	c.scopes[c.scopeIndex].lines = append(lines, code.LineEntry{Offset: offset, Pos: c.pos})
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Position: pos}
@@ -382,6 +830,17 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	c.truncateLines(last.Position)
+}
+
+// truncateLines drops the entries of the line table for the removed instructions.
+func (c *Compiler) truncateLines(offset int) {
+	lines := c.scopes[c.scopeIndex].lines
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= offset {
+		lines = lines[:len(lines)-1]
+	}
+	c.scopes[c.scopeIndex].lines = lines
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -428,6 +887,41 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+func (c *Compiler) enterLoop(start int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loop{start: start, tries: len(scope.tries), finally: scope.finally})
+}
+
+// leaveLoop points the jumps for break in the innermost loop to end.
+func (c *Compiler) leaveLoop(end int) {
+	loops := c.scopes[c.scopeIndex].loops
+	for _, pos := range loops[len(loops)-1].breaks {
+		c.changeOperand(pos, end)
+	}
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+// popToLoop pops the values left on the stack by the expressions which break or continue is
+// used in, e.g. the operands before it, so that the loop finds the stack as it was at its start.
+func (c *Compiler) popToLoop(l *loop) {
+	ins := c.currentInstructions()
+	depths := stackDepths(ins, c.scopes[c.scopeIndex].handlers)
+
+	for depth := depths[len(ins)]; depth > depths[l.start]; depth-- {
+		c.emit(code.OpPop)
+	}
+}
+
+// currentLoop returns nil outside loops. Loops don't reach into the functions defined in them
+// because every function has its own scope.
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
@@ -445,6 +939,179 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// compileLogical compiles && and || into the conditional jumps which skip the right side
+// when the left side decides the result. The result is always true or false.
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	// The jumps to the instructions pushing the result are patched after emitting them.
+	var falseJumps, endJumps []int
+
+	leftPos := c.emit(code.OpJumpNotTruthy, 9999)
+	if node.Operator == "&&" {
+		falseJumps = append(falseJumps, leftPos)
+	} else {
+		c.emit(code.OpTrue)
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		c.changeOperand(leftPos, len(c.currentInstructions()))
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	falseJumps = append(falseJumps, c.emit(code.OpJumpNotTruthy, 9999))
+	c.emit(code.OpTrue)
+	endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+
+	for _, pos := range falseJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.emit(code.OpFalse)
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// compoundOperators maps the compound assignments to the instructions of their operators.
+var compoundOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+// compileAssignment leaves the assigned value on the stack as the result of the expression.
+// The object and the index of the target are evaluated only once, and duplicated on the stack
+// when the compound assignment reads the element before storing into it.
+func (c *Compiler) compileAssignment(node *ast.AssignExpression) error {
+	op, compound := compoundOperators[node.Operator]
+	if !compound && node.Operator != "=" {
+		return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("%s: cannot assign to undefined variable %s", target.Pos(), target.Value)
+		}
+		if symbol.Scope == BuiltinScope {
+			return fmt.Errorf("%s: cannot assign to builtin %s", target.Pos(), target.Value)
+		}
+
+		if compound {
+			c.loadSymbol(symbol)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(code.OpDup, 2)
+			c.emit(code.OpIndex)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+
+		c.emit(code.OpSetIndex)
+
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+
+	return nil
+}
+
+// reboundNames collects the names which the assignments or more than one definition bind
+// in the function or the module. The ones in the nested functions are included as well,
+// which may only make the names refer to the variables where they don't have to.
+func reboundNames(node ast.Node) map[string]bool {
+	rebound := map[string]bool{}
+	defined := map[string]bool{}
+	define := func(names ...*ast.Identifier) {
+		for _, name := range names {
+			if defined[name.Value] {
+				rebound[name.Value] = true
+			}
+			defined[name.Value] = true
+		}
+	}
+
+	ast.Modify(node, func(n ast.Node) ast.Node {
+		switch n := n.(type) {
+		case *ast.AssignExpression:
+			if ident, ok := n.Target.(*ast.Identifier); ok {
+				rebound[ident.Value] = true
+			}
+		case *ast.LetStatement:
+			define(n.Names()...)
+		case *ast.ForExpression:
+			define(n.Variable)
+		case *ast.TryExpression:
+			if n.Parameter != nil {
+				define(n.Parameter)
+			}
+		case *ast.MatchExpression:
+			for _, arm := range n.Arms {
+				define(ast.PatternNames(arm.Pattern)...)
+			}
+		}
+		return n
+	})
+	return rebound
+}
+
+// captureSymbol pushes the cell of the variable instead of its value,
+// so that the closure shares the variable with the enclosing function.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	case FunctionScope:
+		// The closure never changes, so it is captured in a new cell.
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// storeSymbol emits the instruction to take the value off the stack and store it into the variable.
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
 	}
 }
 
@@ -453,6 +1120,13 @@ func (c *Compiler) loadSymbol(s Symbol) {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+
+	// Lines maps the instructions of the main program back to the source code.
+	Lines code.LineTable
+	// Handlers is the exception table of the main program.
+	Handlers code.HandlerTable
+	// GlobalNames are the names of the global variables by their indexes.
+	GlobalNames []string
 }
 
 type EmittedInstruction struct {
@@ -464,4 +1138,42 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	lines code.LineTable
+
+	// loops is the stack of the loops enclosing the code being compiled in this scope.
+	loops []*loop
+
+	// module tells that the scope is the top level of the module, which can't return.
+	module bool
+
+	// tries is the stack of the try expressions enclosing the code being compiled in this scope,
+	// and handlers are the entries of the exception table for the try expressions compiled so far.
+	tries    []*tryBlock
+	handlers []pendingHandler
+
+	// finally counts the finally blocks enclosing the code being compiled in this scope,
+	// which can't be left by return, break and continue.
+	finally int
+
+	// rebound holds the names which may be bound again after their definitions in this scope.
+	rebound map[string]bool
+
+	// matches counts the match expressions enclosing the code being compiled in this scope,
+	// which names the hidden variables holding their subjects.
+	matches int
+}
+
+// loop remembers where break and continue jump to in the loop being compiled.
+// The end of the loop is not known until its body is compiled,
+// so the positions of the jumps for break are patched afterwards.
+// The try expressions entered in the loop, from the index tries of their stack, run the finally blocks
+// before break and continue leave them, while the finally blocks entered in the loop, counted above
+// finally, can't be left by break and continue.
+type loop struct {
+	start  int
+	breaks []int
+
+	tries   int
+	finally int
 }
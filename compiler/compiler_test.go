@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/toversus/monkey/object"
@@ -136,6 +137,16 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "5 % 2",
+			expectedConstants: []interface{}{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "-1",
 			expectedConstants: []interface{}{1},
@@ -275,107 +286,855 @@ func TestBooleanExpressions(t *testing.T) {
 			},
 		},
 		{
-			input:             "1.5 < 2.5",
-			expectedConstants: []interface{}{2.5, 1.5},
+			input:             "1.5 < 2.5",
+			expectedConstants: []interface{}{2.5, 1.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1.5 == 2.5",
+			expectedConstants: []interface{}{1.5, 2.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true == false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true != false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpFalse),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 17),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpJump, 17),
+				// 0016
+				code.Make(code.OpFalse),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			if (true) { 10 }; 3333;
+			`,
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull), // alternative block.
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			if (true) { 10 } else { 20 }; 3333;
+			`,
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 13),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `while (true) { if (false) { break; } 1; }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 23),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0008
+				code.Make(code.OpJump, 23),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016
+				code.Make(code.OpConstant, 0),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpJump, 0),
+				// 0023
+				code.Make(code.OpNull),
+				// 0024
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `for (x in [1]) { continue; }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpGetIter),
+				// 0007
+				code.Make(code.OpIterNext),
+				// 0008
+				code.Make(code.OpJumpNotTruthy, 20),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpJump, 7),
+				// 0017
+				code.Make(code.OpJump, 7),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpNull),
+				// 0022
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let x = 1; x += 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let a = [1]; a[0] *= 2;`,
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { let a = 1; fn() { a = 2 } }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(g) { return g(1); }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(g) { g(g()) + 1 }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(g) { if (g()) { g() } else { len([]) } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpJumpNotTruthy, 14),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpJump, 21),
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(g) { while (true) { g() } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumpNotTruthy, 12),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 0),
+					code.Make(code.OpNull),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "try { throw 1; } catch (e) { e }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpThrow),
+				// 0004
+				code.Make(code.OpNull),
+				// 0005
+				code.Make(code.OpJump, 15),
+				// 0008
+				code.Make(code.OpCatch),
+				// 0009
+				code.Make(code.OpSetGlobal, 0),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 + try { 2 } finally { 3 }",
+			expectedConstants: []interface{}{1, 2, 3, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpConstant, 2),
+				// 0009
+				code.Make(code.OpPop),
+				// 0010
+				code.Make(code.OpJump, 18),
+				// 0013
+				code.Make(code.OpConstant, 3),
+				// 0016
+				code.Make(code.OpPop),
+				// 0017
+				code.Make(code.OpRethrow),
+				// 0018
+				code.Make(code.OpAdd),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a, b = 1, c = a) { c }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let f = fn(...xs) { xs }; f(1, ...[2], 3, 4)`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				2,
+				3,
+				4,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpCallSpread, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	arityTests := []struct {
+		input        string
+		wantParams   int
+		wantEntries  []int
+		wantVariadic bool
+		wantRequired int
+	}{
+		{"fn(a, b) { a }", 2, nil, false, 2},
+		{"fn(a, b = 1, c = a) { c }", 3, []int{0, 5, 9}, false, 1},
+		{"fn(a, ...b) { b }", 1, nil, true, 1},
+		{"fn(a = 1, ...b) { b }", 1, []int{0, 5}, true, 0},
+	}
+
+	for _, test := range arityTests {
+		compiler := New()
+		if err := compiler.Compile(parse(test.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		fn := compiler.Bytecode().Constants[len(compiler.Bytecode().Constants)-1].(*object.CompiledFunction)
+		if fn.NumParameters != test.wantParams || fmt.Sprint(fn.DefaultEntries) != fmt.Sprint(test.wantEntries) ||
+			fn.Variadic != test.wantVariadic || fn.NumRequired() != test.wantRequired {
+			t.Errorf("wrong arity of %q. got params=%d, entries=%v, variadic=%t, required=%d",
+				test.input, fn.NumParameters, fn.DefaultEntries, fn.Variadic, fn.NumRequired())
+		}
+	}
+
+	if err := New().Compile(&ast.Program{Statements: []ast.Statement{
+		&ast.ExpressionStatement{Expression: &ast.SpreadExpression{Value: &ast.Identifier{Value: "x"}}},
+	}}); err == nil || !strings.Contains(err.Error(), "spread is only allowed in call arguments") {
+		t.Errorf("wrong error for spread outside call. got=%v", err)
+	}
+}
+
+func TestLetDestructuring(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let [a, ...r] = [1]; let {k} = a;`,
+			expectedConstants: []interface{}{1, 0, "k"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpSlice, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(p) { let [[x], _] = p; x }`,
+			expectedConstants: []interface{}{
+				0,
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDup, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpIndex),
+					code.Make(code.OpDup, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpIndex),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpDup, 1),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpIndex),
+					code.Make(code.OpPop),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `match (1) { [a] if a => a, {"k": 2} => 3 }`,
+			expectedConstants: []interface{}{1, 0, "k", 2, 3},
 			expectedInstructions: []code.Instructions{
+				// 0000
 				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpMatchArray, 1, 0),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 38),
+				// 0016
+				code.Make(code.OpGetGlobal, 0),
+				// 0019
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				// 0022
+				code.Make(code.OpIndex),
+				// 0023
+				code.Make(code.OpSetGlobal, 1),
+				// 0026
+				code.Make(code.OpGetGlobal, 1),
+				// 0029
+				code.Make(code.OpJumpNotTruthy, 38),
+				// 0032
+				code.Make(code.OpGetGlobal, 1),
+				// 0035
+				code.Make(code.OpJump, 71),
+				// 0038
+				code.Make(code.OpGetGlobal, 0),
+				// 0041
+				code.Make(code.OpConstant, 2),
+				// 0044
+				code.Make(code.OpMatchHash, 1),
+				// 0047
+				code.Make(code.OpJumpNotTruthy, 70),
+				// 0050
+				code.Make(code.OpGetGlobal, 0),
+				// 0053
+				code.Make(code.OpConstant, 2),
+				// 0056
+				code.Make(code.OpIndex),
+				// 0057
+				code.Make(code.OpConstant, 3),
+				// 0060
+				code.Make(code.OpMatchValue),
+				// 0061
+				code.Make(code.OpJumpNotTruthy, 70),
+				// 0064
+				code.Make(code.OpConstant, 4),
+				// 0067
+				code.Make(code.OpJump, 71),
+				// 0070
+				code.Make(code.OpNull),
+				// 0071
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "1.5 == 2.5",
-			expectedConstants: []interface{}{1.5, 2.5},
+			// The arm is in tail position, and the nested match holds its subject in another variable.
+			input: "fn(x) { match (x) { _ => match (x) { y => x(y) } } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpSetLocal, 3),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 3),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpJump, 22),
+					code.Make(code.OpNull),
+					code.Make(code.OpJump, 26),
+					code.Make(code.OpNull),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpEqual),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpPop),
 			},
 		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestExceptionTables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected code.HandlerTable
+	}{
+		{"try { throw 1; } catch (e) { e }", code.HandlerTable{{Start: 0, End: 5, Target: 8, Depth: 0}}},
+		// The exception is pushed above the left operand of the addition.
+		{"1 + try { 2 } finally { 3 }", code.HandlerTable{{Start: 3, End: 6, Target: 13, Depth: 1}}},
+		// The inner handler comes first.
 		{
-			input:             "true == false",
-			expectedConstants: []interface{}{},
-			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpFalse),
-				code.Make(code.OpEqual),
-				code.Make(code.OpPop),
+			"try { try { 1 } catch { 2 } } catch { 3 }",
+			code.HandlerTable{
+				{Start: 0, End: 3, Target: 6, Depth: 0},
+				{Start: 0, End: 11, Target: 14, Depth: 0},
 			},
 		},
+	}
+
+	for _, test := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(test.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		handlers := compiler.Bytecode().Handlers
+		if len(handlers) != len(test.expected) {
+			t.Fatalf("wrong number of handlers for %q. want=%+v, got=%+v", test.input, test.expected, handlers)
+		}
+		for i, h := range test.expected {
+			if handlers[i] != h {
+				t.Errorf("wrong handler %d for %q. want=%+v, got=%+v", i, test.input, h, handlers[i])
+			}
+		}
+	}
+
+	// The finally block inlined for return is not protected by the handler running it again.
+	compiler := New()
+	if err := compiler.Compile(parse("fn() { try { return 1; } finally { 2 } }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn := compiler.Bytecode().Constants[4].(*object.CompiledFunction)
+	expected := code.HandlerTable{
+		{Start: 0, End: 3, Target: 16, Depth: 0},
+		{Start: 7, End: 9, Target: 16, Depth: 0},
+	}
+	if len(fn.Handlers) != len(expected) || fn.Handlers[0] != expected[0] || fn.Handlers[1] != expected[1] {
+		t.Errorf("wrong handlers. want=%+v, got=%+v", expected, fn.Handlers)
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
 		{
-			input:             "true != false",
-			expectedConstants: []interface{}{},
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpFalse),
-				code.Make(code.OpNotEqual),
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "!true",
-			expectedConstants: []interface{}{},
+			input: `
+			let wrapper = fn() {
+				let walk = fn(x) { fn() { walk(x) } };
+				walk;
+			};
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpBang),
-				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
 			},
 		},
-	}
-
-	runCompilerTests(t, tests)
-}
-
-func TestConditionals(t *testing.T) {
-	tests := []compilerTestCase{
 		{
+			// The global function refers to the global variable, which may be bound to another function.
 			input: `
-			if (true) { 10 }; 3333;
+			let walk = fn(x) { fn() { walk(x) } };
 			`,
-			expectedConstants: []interface{}{10, 3333},
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			expectedInstructions: []code.Instructions{
-				// 0000
-				code.Make(code.OpTrue),
-				// 0001
-				code.Make(code.OpJumpNotTruthy, 10),
-				// 0004
-				code.Make(code.OpConstant, 0),
-				// 0007
-				code.Make(code.OpJump, 11),
-				// 0010
-				code.Make(code.OpNull), // alternative block.
-				// 0011
-				code.Make(code.OpPop),
-				// 0012
-				code.Make(code.OpConstant, 1),
-				// 0015
-				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
 			},
 		},
 		{
+			// The local function assigned later refers to the variable through its cell.
 			input: `
-			if (true) { 10 } else { 20 }; 3333;
+			let wrapper = fn() {
+				let f = fn() { f };
+				f = 1;
+			};
 			`,
-			expectedConstants: []interface{}{10, 20, 3333},
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			expectedInstructions: []code.Instructions{
-				// 0000
-				code.Make(code.OpTrue),
-				// 0001
-				code.Make(code.OpJumpNotTruthy, 10),
-				// 0004
-				code.Make(code.OpConstant, 0),
-				// 0007
-				code.Make(code.OpJump, 13),
-				// 0010
-				code.Make(code.OpConstant, 1),
-				// 0013
-				code.Make(code.OpPop),
-				// 0014
-				code.Make(code.OpConstant, 2),
-				// 0017
-				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
 			},
 		},
 	}
@@ -452,6 +1211,18 @@ func TestStringExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `"a ${1} b ${"c"}"`,
+			expectedConstants: []interface{}{"a ", 1, " b ", "c"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConcat, 4),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -909,7 +1680,7 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -939,13 +1710,13 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -993,15 +1764,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1018,6 +1789,142 @@ func TestClosures(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantError string
+	}{
+		{"foobar", "1:1: undefined variable: foobar"},
+		{"let x = 1;\nx + y", "2:5: undefined variable: y"},
+		{"fn() {\n  1 + foo\n}", "2:7: undefined variable: foo"},
+		{"let m = fn() { macro(x) { x } };", "1:16: macro must be defined by a top-level let statement and expanded before compilation"},
+		{"x = 1", "1:1: cannot assign to undefined variable x"},
+		{"len += 1", "1:1: cannot assign to builtin len"},
+		{"break;", "1:1: break outside loop"},
+		{"while (true) { fn() { continue; } }", "1:23: continue outside loop"},
+		{"fn() { try { 1 } finally { return 2; } }", "1:28: return out of finally block"},
+		{"while (true) { try { 1 } finally { break; } }", "1:36: break out of finally block"},
+		{"for (x in []) { try { 1 } finally { continue; } }", "1:37: continue out of finally block"},
+		{"quote(1 + 2)", "1:1: quote is only supported in the body of macro"},
+		{"let f = fn(x) { unquote(x) };", "1:17: unquote is only supported in the body of macro"},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+		if err.Error() != test.wantError {
+			t.Errorf("wrong compiler error. want=%q, got=%q", test.wantError, err)
+		}
+	}
+}
+
+// mapImporter imports the modules from the source code in the map by their names.
+type mapImporter map[string]string
+
+func (m mapImporter) Resolve(importer, name string) (string, error) {
+	if _, ok := m[name]; !ok {
+		return "", fmt.Errorf("module %q not found", name)
+	}
+	return name, nil
+}
+
+func (m mapImporter) Load(path string) (*ast.Program, error) {
+	p := parser.New(lexer.NewWithFilename(path, m[path]))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", p.Errors()[0])
+	}
+	return program, nil
+}
+
+func TestImports(t *testing.T) {
+	importer := mapImporter{"m": "let a = 1; let _b = 2;"}
+
+	compiler := New()
+	compiler.SetImporter(importer)
+	if err := compiler.Compile(parse(`import("m"); import("m")`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	err := testInstructions([]code.Instructions{
+		code.Make(code.OpImport, 0, 3),
+		code.Make(code.OpPop),
+		code.Make(code.OpImport, 0, 3),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	err = testConstants(t, []interface{}{
+		1,
+		2,
+		"a",
+		[]code.Instructions{
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpSetLocal, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpSetLocal, 1),
+			code.Make(code.OpConstant, 2),
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpHash, 2),
+			code.Make(code.OpDup, 1),
+			code.Make(code.OpSetGlobal, 0),
+			code.Make(code.OpReturnValue),
+		},
+	}, bytecode.Constants[:4])
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+	if len(bytecode.Constants) != 4 {
+		t.Errorf("module is compiled more than once. got %d constants", len(bytecode.Constants))
+	}
+	if bytecode.GlobalNames[0] != "module@m" {
+		t.Errorf("wrong global caching the module. got=%q", bytecode.GlobalNames[0])
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	importer := mapImporter{
+		"a":      `import("b")`,
+		"b":      `let x = 1; import("a")`,
+		"leaks":  "let y = x;",
+		"return": "let f = fn() { return 1; };\nreturn f();",
+		"broken": "let = 1;",
+	}
+
+	tests := []struct {
+		input     string
+		wantError string
+	}{
+		{`import("a")`, "b:1:12: import cycle: a -> b -> a"},
+		{`let x = 1; import("leaks")`, "leaks:1:9: undefined variable: x"},
+		{`import("return")`, "return:2:1: return outside function"},
+		{`import("missing")`, `1:1: module "missing" not found`},
+		{`import("broken")`, "1:1: broken:1:5: expected next token to be IDENT, got = instead"},
+	}
+
+	for _, test := range tests {
+		compiler := New()
+		compiler.SetImporter(importer)
+		err := compiler.Compile(parse(test.input))
+		if err == nil || err.Error() != test.wantError {
+			t.Errorf("wrong compiler error. want=%q, got=%v", test.wantError, err)
+		}
+	}
+
+	err := New().Compile(parse(`import("a")`))
+	if err == nil || err.Error() != `1:1: cannot import "a": imports are not enabled` {
+		t.Errorf("wrong compiler error without importer. got=%v", err)
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	// Helper method allows us to remove duplicated logic in test functions
 	// by defining test helpers.
@@ -0,0 +1,261 @@
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/toversus/monkey/code"
+	"github.com/toversus/monkey/object"
+	"github.com/toversus/monkey/token"
+)
+
+// Disassemble writes the human-readable listing of the bytecode to w: the constant pool,
+// the main program and every compiled function in the constant pool.
+// Operands referring to constants and variables are annotated with what they resolve to,
+// jump targets are shown as labels, and sources maps the names of the files the bytecode is compiled from,
+// including the imported modules, to their code, which is used to annotate the instructions with their lines.
+// It may be nil, and the lines of the files missing in it are left unannotated.
+func Disassemble(w io.Writer, bytecode *Bytecode, sources map[string]string) error {
+	bw := bufio.NewWriter(w)
+	d := &disassembler{
+		w:         bw,
+		constants: bytecode.Constants,
+		globals:   bytecode.GlobalNames,
+		sources:   sources,
+		lines:     map[string][]string{},
+	}
+
+	d.printConstants()
+
+	main := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Lines:        bytecode.Lines,
+		Handlers:     bytecode.Handlers,
+	}
+	fmt.Fprintf(bw, "\n<main>:\n")
+	d.printFunction(main)
+
+	for i, c := range bytecode.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(bw, "\n%s (constant %d, %s, locals=%d, free=%d):\n",
+			functionLabel(fn), i, describeParameters(fn), fn.NumLocals, len(fn.FreeNames))
+		d.printFunction(fn)
+	}
+
+	return bw.Flush()
+}
+
+type disassembler struct {
+	w         *bufio.Writer
+	constants []object.Object
+	globals   []string
+	sources   map[string]string
+
+	// lines caches the sources split into the lines by the file names.
+	lines map[string][]string
+}
+
+func (d *disassembler) printConstants() {
+	fmt.Fprintf(d.w, "constants:\n")
+	for i, c := range d.constants {
+		fmt.Fprintf(d.w, "  %04d %s %s\n", i, c.Type(), describeConstant(c))
+	}
+}
+
+// printFunction prints the instructions of the function, preceded by the label of every jump target
+// and the source line whenever the position of instructions moves to another line,
+// followed by the entries of the default values and the exception table.
+func (d *disassembler) printFunction(fn *object.CompiledFunction) {
+	ins := fn.Instructions
+	labels := jumpLabels(ins, fn.Handlers, fn.DefaultEntries)
+
+	var last token.Position
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(d.w, "  %04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		if pos := fn.Lines.Lookup(i); pos.IsValid() && (pos.Filename != last.Filename || pos.Line != last.Line) {
+			last = pos
+			fmt.Fprintf(d.w, "  ; %s%s\n", pos, d.sourceLine(pos))
+		}
+
+		if label, ok := labels[i]; ok {
+			fmt.Fprintf(d.w, "%s:\n", label)
+		}
+
+		text := def.Name
+		for j, o := range operands {
+			if j == 0 && isJump(code.Opcode(ins[i])) {
+				text += " " + labels[o]
+				continue
+			}
+			text += " " + strconv.Itoa(o)
+		}
+
+		if note := d.annotate(fn, code.Opcode(ins[i]), operands); note != "" {
+			fmt.Fprintf(d.w, "  %04d %-24s ; %s\n", i, text, note)
+		} else {
+			fmt.Fprintf(d.w, "  %04d %s\n", i, text)
+		}
+
+		i += 1 + read
+	}
+
+	// A jump may target the end of the instructions.
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(d.w, "%s:\n", label)
+	}
+
+	if len(fn.DefaultEntries) > 0 {
+		fmt.Fprintf(d.w, "  defaults:\n")
+		last := len(fn.DefaultEntries) - 1
+		for i, entry := range fn.DefaultEntries[:last] {
+			fmt.Fprintf(d.w, "    %s -> %s\n", nameAt(fn.LocalNames, fn.NumRequired()+i), labels[entry])
+		}
+		fmt.Fprintf(d.w, "    <body> -> %s\n", labels[fn.DefaultEntries[last]])
+	}
+
+	if len(fn.Handlers) > 0 {
+		fmt.Fprintf(d.w, "  handlers:\n")
+		for _, h := range fn.Handlers {
+			fmt.Fprintf(d.w, "    %04d-%04d -> %s depth=%d\n", h.Start, h.End, labels[h.Target], h.Depth)
+		}
+	}
+}
+
+// sourceLine returns the text of the line at the position in its file prefixed with a separator,
+// or empty string if the source is unknown.
+func (d *disassembler) sourceLine(pos token.Position) string {
+	lines, ok := d.lines[pos.Filename]
+	if !ok {
+		if source, ok := d.sources[pos.Filename]; ok && source != "" {
+			lines = strings.Split(source, "\n")
+		}
+		d.lines[pos.Filename] = lines
+	}
+
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	return "  " + strings.TrimSpace(lines[pos.Line-1])
+}
+
+// annotate resolves the operand of the instruction into the constant or the name of the variable.
+func (d *disassembler) annotate(fn *object.CompiledFunction, op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant, code.OpClosure:
+		if operands[0] < len(d.constants) {
+			return describeConstant(d.constants[operands[0]])
+		}
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return nameAt(d.globals, operands[0])
+	case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+		return nameAt(fn.LocalNames, operands[0])
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		return nameAt(fn.FreeNames, operands[0])
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+	}
+	return ""
+}
+
+// jumpLabels names the targets of the jumps, the handlers and the entries of the default values
+// in the order of their offsets.
+func jumpLabels(ins code.Instructions, handlers code.HandlerTable, entries []int) map[int]string {
+	targets := []int{}
+	seen := map[int]bool{}
+
+	for _, h := range handlers {
+		if !seen[h.Target] {
+			seen[h.Target] = true
+			targets = append(targets, h.Target)
+		}
+	}
+
+	for _, entry := range entries {
+		if !seen[entry] {
+			seen[entry] = true
+			targets = append(targets, entry)
+		}
+	}
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			i++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		if isJump(code.Opcode(ins[i])) && !seen[operands[0]] {
+			seen[operands[0]] = true
+			targets = append(targets, operands[0])
+		}
+
+		i += 1 + read
+	}
+
+	sort.Ints(targets)
+
+	labels := make(map[int]string, len(targets))
+	for i, t := range targets {
+		labels[t] = fmt.Sprintf("L%d", i)
+	}
+	return labels
+}
+
+func isJump(op code.Opcode) bool {
+	return op == code.OpJump || op == code.OpJumpNotTruthy
+}
+
+func describeConstant(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.CompiledFunction:
+		return functionLabel(obj)
+	default:
+		return obj.Inspect()
+	}
+}
+
+// describeParameters formats the number of the parameters, followed by the number of the default values
+// and the rest parameter if the function has them.
+func describeParameters(fn *object.CompiledFunction) string {
+	text := fmt.Sprintf("params=%d", fn.NumParameters)
+	if len(fn.DefaultEntries) > 0 {
+		text += fmt.Sprintf(", defaults=%d", fn.NumParameters-fn.NumRequired())
+	}
+	if fn.Variadic {
+		text += ", rest"
+	}
+	return text
+}
+
+func functionLabel(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "fn <anonymous>"
+	}
+	return "fn " + fn.Name
+}
+
+func nameAt(names []string, i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return ""
+}
@@ -0,0 +1,217 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let x = 5;
+let f = fn(a) {
+  let inc = fn() { a + x };
+  if (a > 1) { inc() } else { len("ab") }
+};
+f(x);`
+
+	expected := `constants:
+  0000 INTEGER 5
+  0001 COMPILED_FUNCTION_OBJ fn inc
+  0002 INTEGER 1
+  0003 STRING "ab"
+  0004 COMPILED_FUNCTION_OBJ fn f
+
+<main>:
+  ; 1:9  let x = 5;
+  0000 OpConstant 0             ; 5
+  0003 OpSetGlobal 0            ; x
+  ; 2:9  let f = fn(a) {
+  0006 OpClosure 4 0            ; fn f
+  0010 OpSetGlobal 1            ; f
+  ; 6:1  f(x);
+  0013 OpGetGlobal 1            ; f
+  0016 OpGetGlobal 0            ; x
+  0019 OpCall 1
+  0021 OpPop
+
+fn inc (constant 1, params=0, locals=0, free=1):
+  ; 3:20  let inc = fn() { a + x };
+  0000 OpGetFree 0              ; a
+  0002 OpGetGlobal 0            ; x
+  0005 OpAdd
+  0006 OpReturnValue
+
+fn f (constant 4, params=1, locals=2, free=0):
+  ; 3:13  let inc = fn() { a + x };
+  0000 OpCaptureLocal 0         ; a
+  0002 OpClosure 1 1            ; fn inc
+  0006 OpSetLocal 1             ; inc
+  ; 4:7  if (a > 1) { inc() } else { len("ab") }
+  0008 OpGetLocal 0             ; a
+  0010 OpConstant 2             ; 1
+  0013 OpGreaterThan
+  0014 OpJumpNotTruthy L0
+  0017 OpGetLocal 1             ; inc
+  0019 OpTailCall 0
+  0021 OpJump L1
+L0:
+  0024 OpGetBuiltin 0           ; len
+  0026 OpConstant 3             ; "ab"
+  0029 OpCall 1
+L1:
+  0031 OpReturnValue
+`
+
+	program := parse(input)
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, compiler.Bytecode(), map[string]string{"": input}); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestDisassembleHandlers(t *testing.T) {
+	input := `try { throw 1; } catch (e) { e }`
+
+	expected := `constants:
+  0000 INTEGER 1
+
+<main>:
+  ; 1:13  try { throw 1; } catch (e) { e }
+  0000 OpConstant 0             ; 1
+  0003 OpThrow
+  0004 OpNull
+  0005 OpJump L1
+L0:
+  0008 OpCatch
+  0009 OpSetGlobal 0            ; e
+  0012 OpGetGlobal 0            ; e
+L1:
+  0015 OpPop
+  handlers:
+    0000-0005 -> L0 depth=0
+`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, compiler.Bytecode(), map[string]string{"": input}); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestDisassembleDefaults(t *testing.T) {
+	input := `let f = fn(a, b = a, ...c) { c };
+f(...[1]);`
+
+	expected := `constants:
+  0000 COMPILED_FUNCTION_OBJ fn f
+  0001 INTEGER 1
+
+<main>:
+  ; 1:9  let f = fn(a, b = a, ...c) { c };
+  0000 OpClosure 0 0            ; fn f
+  0004 OpSetGlobal 0            ; f
+  ; 2:1  f(...[1]);
+  0007 OpGetGlobal 0            ; f
+  0010 OpConstant 1             ; 1
+  0013 OpArray 1
+  0016 OpCallSpread 1
+  0018 OpPop
+
+fn f (constant 0, params=2, defaults=1, rest, locals=3, free=0):
+  ; 1:19  let f = fn(a, b = a, ...c) { c };
+L0:
+  0000 OpGetLocal 0             ; a
+  0002 OpSetLocal 1             ; b
+L1:
+  0004 OpGetLocal 2             ; c
+  0006 OpReturnValue
+  defaults:
+    b -> L0
+    <body> -> L1
+`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, compiler.Bytecode(), map[string]string{"": input}); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestDisassembleImports(t *testing.T) {
+	input := `// the script
+let m = import("m");`
+	importer := mapImporter{"m": "let twice = fn(x) { x * 2 };"}
+
+	// The lines are taken from the source of the file each position is in.
+	expected := `constants:
+  0000 INTEGER 2
+  0001 COMPILED_FUNCTION_OBJ fn twice
+  0002 STRING "twice"
+  0003 COMPILED_FUNCTION_OBJ fn <module>
+
+<main>:
+  ; 2:9  let m = import("m");
+  0000 OpImport 1 3
+  0005 OpSetGlobal 0            ; m
+
+fn twice (constant 1, params=1, locals=1, free=0):
+  ; m:1:21  let twice = fn(x) { x * 2 };
+  0000 OpGetLocal 0             ; x
+  0002 OpConstant 0             ; 2
+  0005 OpMul
+  0006 OpReturnValue
+
+fn <module> (constant 3, params=0, locals=1, free=0):
+  ; m:1:13  let twice = fn(x) { x * 2 };
+  0000 OpClosure 1 0            ; fn twice
+  0004 OpSetLocal 0             ; twice
+  ; 2:9  let m = import("m");
+  0006 OpConstant 2             ; "twice"
+  0009 OpGetLocal 0             ; twice
+  0011 OpHash 2
+  0014 OpDup 1
+  0016 OpSetGlobal 1            ; module@m
+  0019 OpReturnValue
+`
+
+	compiler := New()
+	compiler.SetImporter(importer)
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	sources := map[string]string{"": input, "m": importer["m"]}
+	if err := Disassemble(&out, compiler.Bytecode(), sources); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,293 @@
+package compiler
+
+import (
+	"github.com/toversus/monkey/ast"
+	"github.com/toversus/monkey/code"
+)
+
+// tryBlock is the try expression being compiled. Its finally block is inlined wherever return,
+// break or continue leaves the try expression, and the inlined copies are excluded from the instructions
+// protected by its handlers, which would otherwise run the finally block again for their exceptions.
+type tryBlock struct {
+	finally *ast.BlockStatement
+
+	// gaps are the ranges of the inlined finally blocks, in the order of their offsets.
+	gaps [][2]int
+}
+
+// protected splits the range of the instructions from start to end around the gaps.
+func (t *tryBlock) protected(start, end int) [][2]int {
+	ranges := [][2]int{}
+	for _, gap := range t.gaps {
+		if gap[0] >= end {
+			break
+		}
+		if gap[0] > start {
+			ranges = append(ranges, [2]int{start, gap[0]})
+		}
+		start = gap[1]
+	}
+	if start < end {
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges
+}
+
+// pendingHandler is the entry of the exception table whose depth of the stack is not known
+// until the whole function is compiled. It is the depth at entry, the start of the try expression.
+type pendingHandler struct {
+	code.Handler
+	entry int
+}
+
+// compileTry compiles the try expression into the instructions below, where the handlers
+// are entered with the exception pushed on the stack.
+//
+//	<block>
+//	OpJump L0
+//	OpCatch               ; handles the exceptions from <block>
+//	<store the parameter>
+//	<catch>
+//	L0:
+//	<finally>
+//	OpJump L1
+//	<finally>             ; handles the exceptions from <block> and <catch>
+//	OpRethrow
+//	L1:
+//
+// The catch block and the finally block are only compiled if they are present.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	try := &tryBlock{finally: node.Finally}
+	c.scopes[c.scopeIndex].tries = append(c.scopes[c.scopeIndex].tries, try)
+
+	start := len(c.currentInstructions())
+	if err := c.compileBlockValue(node.Block); err != nil {
+		return err
+	}
+	blockEnd := len(c.currentInstructions())
+
+	var afterCatch int
+	if node.Catch != nil {
+		// Only the finally block handles the exceptions from the catch block.
+		if node.Finally == nil {
+			c.popTry()
+		}
+		afterCatch = c.emit(code.OpJump, 9999)
+
+		c.addHandler(try, start, blockEnd, len(c.currentInstructions()))
+		c.emit(code.OpCatch)
+		if node.Parameter != nil {
+			c.storeSymbol(c.symbolTable.Define(node.Parameter.Value))
+		} else {
+			c.emit(code.OpPop)
+		}
+
+		if err := c.compileBlockValue(node.Catch); err != nil {
+			return err
+		}
+		c.changeOperand(afterCatch, len(c.currentInstructions()))
+	}
+
+	if node.Finally == nil {
+		return nil
+	}
+
+	c.popTry()
+	end := len(c.currentInstructions())
+
+	if err := c.compileFinally(node.Finally); err != nil {
+		return err
+	}
+	afterFinally := c.emit(code.OpJump, 9999)
+
+	c.addHandler(try, start, end, len(c.currentInstructions()))
+	if err := c.compileFinally(node.Finally); err != nil {
+		return err
+	}
+	c.emit(code.OpRethrow)
+
+	c.changeOperand(afterFinally, len(c.currentInstructions()))
+	return nil
+}
+
+// compileBlockValue compiles the block leaving its value on the stack,
+// which is null unless the block ends with an expression.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// compileFinally compiles the finally block, which leaves nothing on the stack.
+func (c *Compiler) compileFinally(block *ast.BlockStatement) error {
+	c.scopes[c.scopeIndex].finally++
+	defer func() { c.scopes[c.scopeIndex].finally-- }()
+
+	return c.Compile(block)
+}
+
+// inlineFinally compiles the finally blocks of the try expressions which return, break or continue
+// leaves, from the innermost one to the one at the index from of the stack.
+// The exceptions from an inlined finally block are handled by the try expressions enclosing it.
+func (c *Compiler) inlineFinally(from int) error {
+	tries := c.scopes[c.scopeIndex].tries
+
+	for i := len(tries) - 1; i >= from; i-- {
+		if tries[i].finally == nil {
+			continue
+		}
+
+		start := len(c.currentInstructions())
+		if err := c.compileFinally(tries[i].finally); err != nil {
+			return err
+		}
+		gap := [2]int{start, len(c.currentInstructions())}
+
+		for _, t := range tries[i:] {
+			t.gaps = append(t.gaps, gap)
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) popTry() {
+	tries := c.scopes[c.scopeIndex].tries
+	c.scopes[c.scopeIndex].tries = tries[:len(tries)-1]
+}
+
+// addHandler adds the entries of the exception table which protect the instructions
+// from start to end with the handler at target.
+func (c *Compiler) addHandler(try *tryBlock, start, end, target int) {
+	scope := &c.scopes[c.scopeIndex]
+
+	for _, r := range try.protected(start, end) {
+		scope.handlers = append(scope.handlers, pendingHandler{
+			Handler: code.Handler{Start: r[0], End: r[1], Target: target},
+			entry:   start,
+		})
+	}
+}
+
+// handlerTable completes the exception table of the current scope with the depths of the stack.
+func (c *Compiler) handlerTable() code.HandlerTable {
+	pending := c.scopes[c.scopeIndex].handlers
+	if len(pending) == 0 {
+		return nil
+	}
+
+	depths := stackDepths(c.currentInstructions(), pending)
+
+	table := make(code.HandlerTable, len(pending))
+	for i, h := range pending {
+		table[i] = h.Handler
+		// The try expression in the unreachable code is never entered.
+		if depth := depths[h.entry]; depth > 0 {
+			table[i].Depth = depth
+		}
+	}
+	return table
+}
+
+// stackDepths computes the depth of the stack above the locals before each instruction
+// by following the jumps from the start of the function, and the handlers from the starts
+// of their try expressions. The depth is -1 for the unreachable instructions.
+func stackDepths(ins code.Instructions, handlers []pendingHandler) []int {
+	depths := make([]int, len(ins)+1)
+	for i := range depths {
+		depths[i] = -1
+	}
+
+	work := []int{}
+	visit := func(offset, depth int) {
+		if offset <= len(ins) && depths[offset] < 0 {
+			depths[offset] = depth
+			work = append(work, offset)
+		}
+	}
+
+	visit(0, 0)
+	for len(work) > 0 {
+		for len(work) > 0 {
+			offset := work[len(work)-1]
+			work = work[:len(work)-1]
+			if offset == len(ins) {
+				continue
+			}
+
+			def, err := code.Lookup(ins[offset])
+			if err != nil {
+				continue
+			}
+			operands, read := code.ReadOperands(def, ins[offset+1:])
+			next := offset + 1 + read
+			depth := depths[offset]
+
+			switch op := code.Opcode(ins[offset]); op {
+			case code.OpJump:
+				visit(operands[0], depth)
+
+			case code.OpJumpNotTruthy:
+				visit(operands[0], depth-1)
+				visit(next, depth-1)
+
+			case code.OpIterNext:
+				// OpJumpNotTruthy following it leaves the loop with only the iterator,
+				// or goes on with the element pushed.
+				if next < len(ins) && code.Opcode(ins[next]) == code.OpJumpNotTruthy {
+					visit(int(code.ReadUint16(ins[next+1:])), depth)
+					visit(next+3, depth+1)
+				}
+
+			case code.OpReturnValue, code.OpReturn, code.OpThrow, code.OpRethrow:
+				// The execution doesn't go on to the next instruction.
+
+			default:
+				visit(next, depth+stackEffect(op, operands))
+			}
+		}
+
+		// The handler finds the exception pushed at the depth of the start of its try expression.
+		for _, h := range handlers {
+			if depths[h.entry] >= 0 {
+				visit(h.Target, depths[h.entry]+1)
+			}
+		}
+	}
+
+	return depths
+}
+
+// stackEffect returns how many values the instruction leaves on the stack
+// more than it takes off, except the jumps and the instructions leaving the function.
+func stackEffect(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpCaptureLocal, code.OpCaptureFree, code.OpCurrentClosure, code.OpImport:
+		return 1
+	case code.OpPop, code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+		code.OpSetGlobal, code.OpSetLocal, code.OpSetFree, code.OpIndex, code.OpMatchValue:
+		return -1
+	case code.OpSetIndex:
+		return -2
+	case code.OpArray, code.OpHash, code.OpConcat:
+		return 1 - operands[0]
+	case code.OpCall, code.OpTailCall, code.OpCallSpread, code.OpMatchHash:
+		return -operands[0]
+	case code.OpClosure:
+		return 1 - operands[1]
+	case code.OpDup:
+		return operands[0]
+	default:
+		return 0
+	}
+}
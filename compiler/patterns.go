@@ -0,0 +1,222 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/toversus/monkey/ast"
+	"github.com/toversus/monkey/code"
+	"github.com/toversus/monkey/object"
+)
+
+// compileMatch compiles the match expression into the instructions below, where each test of the pattern
+// and the guard jumps to the next arm when it fails. The subject is stored in the hidden variable,
+// from which each test loads the part of the subject it looks at, so the stack is left as it is
+// whichever test fails.
+//
+//	<subject>
+//	<store the subject>
+//	<tests of the pattern>  ; OpJumpNotTruthy L0 for each test
+//	<guard>
+//	OpJumpNotTruthy L0
+//	<body>
+//	OpJump END
+//	L0:
+//	<the next arm>
+//	OpNull                  ; no arm matches
+//	END:
+func (c *Compiler) compileMatch(node *ast.MatchExpression, tail bool) error {
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+
+	// The match expressions in the arms hold their subjects in the other variables.
+	subject := c.symbolTable.Define(fmt.Sprintf("match@%d", c.scopes[c.scopeIndex].matches))
+	c.storeSymbol(subject)
+
+	c.scopes[c.scopeIndex].matches++
+	defer func() { c.scopes[c.scopeIndex].matches-- }()
+
+	ends := []int{}
+	for _, arm := range node.Arms {
+		fails := []int{}
+
+		if err := c.compilePattern(arm.Pattern, subject, nil, &fails); err != nil {
+			return err
+		}
+
+		if arm.Guard != nil {
+			if err := c.Compile(arm.Guard); err != nil {
+				return err
+			}
+			fails = append(fails, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+
+		c.tail = tail
+		if err := c.compileBlockValue(arm.Body); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(code.OpJump, 9999))
+
+		for _, pos := range fails {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+	}
+
+	c.emit(code.OpNull)
+
+	for _, pos := range ends {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compilePattern compiles the tests of the pattern against the part of the subject at the path,
+// which is the constant indices of the elements and the values indexed from the subject in order,
+// and the stores of the values bound by the pattern. The positions of the jumps taken when the tests fail
+// are appended to fails.
+func (c *Compiler) compilePattern(pattern ast.Expression, subject Symbol, path []int, fails *[]int) error {
+	load := func() {
+		c.loadSymbol(subject)
+		for _, index := range path {
+			c.emit(code.OpConstant, index)
+			c.emit(code.OpIndex)
+		}
+	}
+
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value == "_" {
+			return nil
+		}
+		load()
+		c.storeSymbol(c.symbolTable.Define(pattern.Value))
+
+	case *ast.ArrayPattern:
+		hasRest := 0
+		if pattern.Rest != nil {
+			hasRest = 1
+		}
+		load()
+		c.emit(code.OpMatchArray, len(pattern.Elements), hasRest)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for i, element := range pattern.Elements {
+			index := c.addConstant(&object.Integer{Value: int64(i)})
+			if err := c.compilePattern(element, subject, append(path[:len(path):len(path)], index), fails); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil && pattern.Rest.Value != "_" {
+			load()
+			c.emit(code.OpSlice, len(pattern.Elements))
+			c.storeSymbol(c.symbolTable.Define(pattern.Rest.Value))
+		}
+
+	case *ast.HashPattern:
+		load()
+		keys := make([]int, len(pattern.Pairs))
+		for i, pair := range pattern.Pairs {
+			key, err := patternKey(pair.Key)
+			if err != nil {
+				return err
+			}
+			keys[i] = c.addConstant(key)
+			c.emit(code.OpConstant, keys[i])
+		}
+		c.emit(code.OpMatchHash, len(pattern.Pairs))
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for i, pair := range pattern.Pairs {
+			if err := c.compilePattern(pair.Value, subject, append(path[:len(path):len(path)], keys[i]), fails); err != nil {
+				return err
+			}
+		}
+
+	default:
+		// The literal is compared with the value.
+		load()
+		if err := c.Compile(pattern); err != nil {
+			return err
+		}
+		c.emit(code.OpMatchValue)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+
+	return nil
+}
+
+// compileDestructure compiles the pattern of the let statement into the instructions below,
+// which bind the parts of the value on top of the stack and take it off. The parts are indexed
+// like the index expression does, so the missing elements and keys are null.
+//
+//	OpDup 1
+//	OpConstant <index>  ; or the key of the hash pattern
+//	OpIndex
+//	<store or destructure the element>
+//	...
+//	OpDup 1
+//	OpSlice N           ; the rest of the elements
+//	<store the rest>
+//	OpPop
+func (c *Compiler) compileDestructure(pattern ast.Expression) error {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value == "_" {
+			c.emit(code.OpPop)
+			return nil
+		}
+		c.storeSymbol(c.symbolTable.Define(pattern.Value))
+		return nil
+
+	case *ast.ArrayPattern:
+		for i, element := range pattern.Elements {
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(i)}))
+			c.emit(code.OpIndex)
+			if err := c.compileDestructure(element); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpSlice, len(pattern.Elements))
+			if err := c.compileDestructure(pattern.Rest); err != nil {
+				return err
+			}
+		}
+
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			key, err := patternKey(pair.Key)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpDup, 1)
+			c.emit(code.OpConstant, c.addConstant(key))
+			c.emit(code.OpIndex)
+			if err := c.compileDestructure(pair.Value); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("%s: literal pattern %s is not allowed in let statement", pattern.Pos(), pattern)
+	}
+
+	c.emit(code.OpPop)
+	return nil
+}
+
+// patternKey returns the constant of the key of the hash pattern.
+func patternKey(key ast.Expression) (object.Object, error) {
+	switch key := key.(type) {
+	case *ast.StringLiteral:
+		return &object.String{Value: key.Value}, nil
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: key.Value}, nil
+	default:
+		return nil, fmt.Errorf("%s: the key of hash pattern must be a string or integer literal, got %s", key.Pos(), key)
+	}
+}
@@ -0,0 +1,441 @@
+package compiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/toversus/monkey/code"
+	"github.com/toversus/monkey/object"
+	"github.com/toversus/monkey/token"
+)
+
+// The serialized bytecode starts with the header below, followed by the instructions of the main program
+// and the constant pool. Every function, including the main program, is followed by its exception table.
+// All numbers are encoded in big endian like the operands.
+//   magic "MNKY" | format version (uint16) | opcode set version (uint16) | flags (uint8)
+// When the debug info is included, the table of file names comes right after the header,
+// and every function is followed by its name and line table.
+const (
+	// FormatVersion is the version of the layout of the serialized bytecode.
+	FormatVersion = 4
+
+	magic = "MNKY"
+
+	flagDebugInfo = 1 << 0
+)
+
+// Tags of the constants in the constant pool.
+const (
+	tagInteger byte = iota + 1
+	tagFloat
+	tagString
+	tagCompiledFunction
+)
+
+// IsSerialized reports whether the data starts with the header of serialized bytecode.
+func IsSerialized(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// Encode writes the bytecode to w. The names of functions and the line tables are included
+// only if debug is true, otherwise runtime errors have no source positions in their traces.
+func Encode(w io.Writer, bytecode *Bytecode, debug bool) error {
+	e := &encoder{w: bufio.NewWriter(w), debug: debug, files: map[string]int{}}
+
+	e.writeString(magic)
+	e.writeUint16(FormatVersion)
+	e.writeUint16(code.Version)
+
+	var flags byte
+	if debug {
+		flags |= flagDebugInfo
+	}
+	e.writeByte(flags)
+
+	if debug {
+		e.writeFileTable(bytecode)
+	}
+
+	e.writeBytes(bytecode.Instructions)
+	e.writeHandlerTable(bytecode.Handlers)
+	if debug {
+		e.writeLineTable(bytecode.Lines)
+		e.writeNames(bytecode.GlobalNames)
+	}
+
+	e.writeUint32(uint32(len(bytecode.Constants)))
+	for _, c := range bytecode.Constants {
+		e.writeConstant(c)
+	}
+
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// Decode reads the bytecode written by Encode from r.
+// The decoded bytecode is verified so that the VM can run it safely even if the input is corrupted.
+func Decode(r io.Reader) (*Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{r: bytes.NewReader(data)}
+
+	if m := string(d.readBytes(len(magic))); d.err == nil && m != magic {
+		return nil, fmt.Errorf("not a Monkey bytecode file")
+	}
+
+	if v := d.readUint16(); d.err == nil && v != FormatVersion {
+		return nil, fmt.Errorf("unsupported bytecode format version %d, want=%d", v, FormatVersion)
+	}
+
+	if v := d.readUint16(); d.err == nil && v != code.Version {
+		return nil, fmt.Errorf("bytecode compiled for opcode set version %d, want=%d", v, code.Version)
+	}
+
+	flags := d.readByte()
+	d.debug = flags&flagDebugInfo != 0
+
+	if d.debug {
+		d.readFileTable()
+	}
+
+	bytecode := &Bytecode{}
+	bytecode.Instructions = d.readLenBytes()
+	bytecode.Handlers = d.readHandlerTable()
+	if d.debug {
+		bytecode.Lines = d.readLineTable()
+		bytecode.GlobalNames = d.readNames()
+	}
+
+	n := d.readUint32()
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.readConstant())
+	}
+
+	if d.err == nil {
+		d.err = verify(bytecode)
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("malformed bytecode: %s", d.err)
+	}
+	return bytecode, nil
+}
+
+// encoder keeps the first error that occurred so that the callers can check it only once at the end.
+type encoder struct {
+	w     *bufio.Writer
+	err   error
+	debug bool
+
+	// files maps the file names in the line tables to their indexes in the file table.
+	files map[string]int
+}
+
+func (e *encoder) write(data []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(data)
+}
+
+func (e *encoder) writeByte(b byte) { e.write([]byte{b}) }
+
+func (e *encoder) writeUint16(v uint16) {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	e.write(buf[:])
+}
+
+func (e *encoder) writeUint32(v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	e.write(buf[:])
+}
+
+func (e *encoder) writeUint64(v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	e.write(buf[:])
+}
+
+func (e *encoder) writeString(s string) { e.write([]byte(s)) }
+
+// writeBytes writes the length of data followed by data itself.
+func (e *encoder) writeBytes(data []byte) {
+	e.writeUint32(uint32(len(data)))
+	e.write(data)
+}
+
+func (e *encoder) writeConstant(obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.writeByte(tagInteger)
+		e.writeUint64(uint64(obj.Value))
+
+	case *object.Float:
+		e.writeByte(tagFloat)
+		e.writeUint64(math.Float64bits(obj.Value))
+
+	case *object.String:
+		e.writeByte(tagString)
+		e.writeBytes([]byte(obj.Value))
+
+	case *object.CompiledFunction:
+		e.writeByte(tagCompiledFunction)
+		e.writeUint32(uint32(obj.NumLocals))
+		e.writeUint32(uint32(obj.NumParameters))
+		e.writeOffsets(obj.DefaultEntries)
+		if obj.Variadic {
+			e.writeByte(1)
+		} else {
+			e.writeByte(0)
+		}
+		e.writeBytes(obj.Instructions)
+		e.writeHandlerTable(obj.Handlers)
+		if e.debug {
+			e.writeBytes([]byte(obj.Name))
+			e.writeLineTable(obj.Lines)
+			e.writeNames(obj.LocalNames)
+			e.writeNames(obj.FreeNames)
+		}
+
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("cannot serialize constant of type %s", obj.Type())
+		}
+	}
+}
+
+// writeFileTable collects the file names used by all the line tables,
+// which are referred by their indexes in the entries.
+func (e *encoder) writeFileTable(bytecode *Bytecode) {
+	names := []string{}
+	collect := func(lines code.LineTable) {
+		for _, l := range lines {
+			if _, ok := e.files[l.Pos.Filename]; !ok {
+				e.files[l.Pos.Filename] = len(names)
+				names = append(names, l.Pos.Filename)
+			}
+		}
+	}
+
+	collect(bytecode.Lines)
+	for _, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			collect(fn.Lines)
+		}
+	}
+
+	e.writeUint32(uint32(len(names)))
+	for _, name := range names {
+		e.writeBytes([]byte(name))
+	}
+}
+
+func (e *encoder) writeLineTable(lines code.LineTable) {
+	e.writeUint32(uint32(len(lines)))
+	for _, l := range lines {
+		e.writeUint32(uint32(l.Offset))
+		e.writeUint32(uint32(e.files[l.Pos.Filename]))
+		e.writeUint32(uint32(l.Pos.Offset))
+		e.writeUint32(uint32(l.Pos.Line))
+		e.writeUint32(uint32(l.Pos.Column))
+	}
+}
+
+func (e *encoder) writeHandlerTable(handlers code.HandlerTable) {
+	e.writeUint32(uint32(len(handlers)))
+	for _, h := range handlers {
+		e.writeUint32(uint32(h.Start))
+		e.writeUint32(uint32(h.End))
+		e.writeUint32(uint32(h.Target))
+		e.writeUint32(uint32(h.Depth))
+	}
+}
+
+func (e *encoder) writeOffsets(offsets []int) {
+	e.writeUint32(uint32(len(offsets)))
+	for _, offset := range offsets {
+		e.writeUint32(uint32(offset))
+	}
+}
+
+func (e *encoder) writeNames(names []string) {
+	e.writeUint32(uint32(len(names)))
+	for _, name := range names {
+		e.writeBytes([]byte(name))
+	}
+}
+
+// decoder keeps the first error that occurred like encoder.
+type decoder struct {
+	r     *bytes.Reader
+	err   error
+	debug bool
+
+	files []string
+}
+
+func (d *decoder) readBytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	// The lengths are read from the input, so they are checked before allocating the buffer.
+	if n > d.r.Len() {
+		// Every part of the bytecode is required, so reaching the end of input is always unexpected.
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	buf := make([]byte, n)
+	_, d.err = io.ReadFull(d.r, buf)
+	return buf
+}
+
+func (d *decoder) readByte() byte {
+	buf := d.readBytes(1)
+	if d.err != nil {
+		return 0
+	}
+	return buf[0]
+}
+
+func (d *decoder) readUint16() uint16 {
+	buf := d.readBytes(2)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(buf)
+}
+
+func (d *decoder) readUint32() uint32 {
+	buf := d.readBytes(4)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(buf)
+}
+
+func (d *decoder) readUint64() uint64 {
+	buf := d.readBytes(8)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
+
+// readLenBytes reads the data written by writeBytes.
+func (d *decoder) readLenBytes() []byte {
+	n := d.readUint32()
+	return d.readBytes(int(n))
+}
+
+func (d *decoder) readConstant() object.Object {
+	switch tag := d.readByte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: int64(d.readUint64())}
+
+	case tagFloat:
+		return &object.Float{Value: math.Float64frombits(d.readUint64())}
+
+	case tagString:
+		return &object.String{Value: string(d.readLenBytes())}
+
+	case tagCompiledFunction:
+		fn := &object.CompiledFunction{}
+		fn.NumLocals = int(d.readUint32())
+		fn.NumParameters = int(d.readUint32())
+		fn.DefaultEntries = d.readOffsets()
+		fn.Variadic = d.readByte() == 1
+		fn.Instructions = d.readLenBytes()
+		fn.Handlers = d.readHandlerTable()
+		if d.debug {
+			fn.Name = string(d.readLenBytes())
+			fn.Lines = d.readLineTable()
+			fn.LocalNames = d.readNames()
+			fn.FreeNames = d.readNames()
+		}
+		return fn
+
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown constant tag %d", tag)
+		}
+		return nil
+	}
+}
+
+func (d *decoder) readFileTable() {
+	n := d.readUint32()
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		d.files = append(d.files, string(d.readLenBytes()))
+	}
+}
+
+func (d *decoder) readLineTable() code.LineTable {
+	n := d.readUint32()
+
+	lines := code.LineTable{}
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		offset := int(d.readUint32())
+		file := int(d.readUint32())
+		pos := token.Position{
+			Offset: int(d.readUint32()),
+			Line:   int(d.readUint32()),
+			Column: int(d.readUint32()),
+		}
+
+		if file >= len(d.files) {
+			if d.err == nil {
+				d.err = fmt.Errorf("file index %d out of range", file)
+			}
+			break
+		}
+		pos.Filename = d.files[file]
+
+		lines = append(lines, code.LineEntry{Offset: offset, Pos: pos})
+	}
+	return lines
+}
+
+func (d *decoder) readHandlerTable() code.HandlerTable {
+	n := d.readUint32()
+
+	var handlers code.HandlerTable
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		handlers = append(handlers, code.Handler{
+			Start:  int(d.readUint32()),
+			End:    int(d.readUint32()),
+			Target: int(d.readUint32()),
+			Depth:  int(d.readUint32()),
+		})
+	}
+	return handlers
+}
+
+func (d *decoder) readOffsets() []int {
+	n := d.readUint32()
+
+	var offsets []int
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		offsets = append(offsets, int(d.readUint32()))
+	}
+	return offsets
+}
+
+func (d *decoder) readNames() []string {
+	n := d.readUint32()
+
+	names := []string{}
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		names = append(names, string(d.readLenBytes()))
+	}
+	return names
+}
@@ -0,0 +1,290 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/toversus/monkey/code"
+	"github.com/toversus/monkey/object"
+)
+
+func TestSerializeRoundTrip(t *testing.T) {
+	input := `
+let pi = 3.14;
+let greet = fn(name) {
+  let message = "hello " + name;
+  fn() { message + pi }
+};
+greet("monkey")();
+let safe = fn(f) { try { f() } catch (e) { 0 } finally { pi } };
+try { safe(greet) } catch (e) { 1 };
+let opt = fn(a, b = a * 2, ...rest) { a + b + len(rest) };
+opt(1, ...[2, 3]);
+`
+	program := parse(input)
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	original := compiler.Bytecode()
+
+	for _, debug := range []bool{true, false} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, original, debug); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		if !IsSerialized(buf.Bytes()) {
+			t.Fatalf("serialized bytecode has no header")
+		}
+
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		if !bytes.Equal(decoded.Instructions, original.Instructions) {
+			t.Errorf("wrong instructions.\nwant=%q\n got=%q",
+				original.Instructions, decoded.Instructions)
+		}
+
+		if len(decoded.Constants) != len(original.Constants) {
+			t.Fatalf("wrong number of constants. want=%d, got=%d",
+				len(original.Constants), len(decoded.Constants))
+		}
+
+		for i, want := range original.Constants {
+			got := decoded.Constants[i]
+			if got.Type() != want.Type() {
+				t.Errorf("constant %d has wrong type. want=%s, got=%s", i, want.Type(), got.Type())
+				continue
+			}
+
+			wantFn, ok := want.(*object.CompiledFunction)
+			if !ok {
+				if got.Inspect() != want.Inspect() {
+					t.Errorf("constant %d has wrong value. want=%s, got=%s", i, want.Inspect(), got.Inspect())
+				}
+				continue
+			}
+
+			gotFn := got.(*object.CompiledFunction)
+			if !bytes.Equal(gotFn.Instructions, wantFn.Instructions) ||
+				gotFn.NumLocals != wantFn.NumLocals ||
+				gotFn.NumParameters != wantFn.NumParameters ||
+				fmt.Sprint(gotFn.DefaultEntries) != fmt.Sprint(wantFn.DefaultEntries) ||
+				gotFn.Variadic != wantFn.Variadic {
+				t.Errorf("constant %d has wrong function. want=%+v, got=%+v", i, wantFn, gotFn)
+			}
+			testHandlers(t, wantFn.Handlers, gotFn.Handlers)
+
+			if debug {
+				if gotFn.Name != wantFn.Name {
+					t.Errorf("constant %d has wrong name. want=%q, got=%q", i, wantFn.Name, gotFn.Name)
+				}
+				testLineTable(t, wantFn.Lines, gotFn.Lines)
+				testNames(t, wantFn.LocalNames, gotFn.LocalNames)
+				testNames(t, wantFn.FreeNames, gotFn.FreeNames)
+			} else if gotFn.Name != "" || len(gotFn.Lines) != 0 {
+				t.Errorf("constant %d has debug info without debug flag", i)
+			}
+		}
+
+		testHandlers(t, original.Handlers, decoded.Handlers)
+
+		if debug {
+			testLineTable(t, original.Lines, decoded.Lines)
+			testNames(t, original.GlobalNames, decoded.GlobalNames)
+		}
+	}
+}
+
+func TestDecodeRejectsInvalidInput(t *testing.T) {
+	tests := []struct {
+		input []byte
+		want  string
+	}{
+		{[]byte("let x = 1;"), "not a Monkey bytecode file"},
+		{[]byte{'M', 'N', 'K', 'Y', 0, 99, 0, 1, 0}, fmt.Sprintf("unsupported bytecode format version 99, want=%d", FormatVersion)},
+		{[]byte{'M', 'N', 'K', 'Y', 0, FormatVersion, 0, 99, 0}, fmt.Sprintf("bytecode compiled for opcode set version 99, want=%d", code.Version)},
+		{[]byte{'M', 'N', 'K', 'Y', 0, FormatVersion, 0, code.Version, 0, 0, 0, 0, 9}, "malformed bytecode: unexpected EOF"},
+	}
+
+	for _, test := range tests {
+		_, err := Decode(bytes.NewReader(test.input))
+		if err == nil {
+			t.Fatalf("expected decode error for %q but resulted in none", test.input)
+		}
+		if err.Error() != test.want {
+			t.Errorf("wrong decode error. want=%q, got=%q", test.want, err)
+		}
+	}
+}
+
+func TestDecodeRejectsCorruptedBytecode(t *testing.T) {
+	function := func(numLocals, numParameters int, ins ...code.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{
+			Instructions:  concatInstructions(ins),
+			NumLocals:     numLocals,
+			NumParameters: numParameters,
+		}
+	}
+
+	tests := []struct {
+		bytecode *Bytecode
+		want     string
+	}{
+		{
+			&Bytecode{Instructions: code.Instructions{255}},
+			"main program: opcode 255 undefined at offset 0",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2]},
+			"main program: operands of OpConstant at offset 0 are truncated",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			}), Constants: []object.Object{&object.Integer{Value: 1}}},
+			"main program: OpConstant at offset 0: constant 1 out of range",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			}), Constants: []object.Object{&object.Integer{Value: 1}}},
+			"main program: OpClosure at offset 0: constant 0 is not a function",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			}), Constants: []object.Object{
+				function(0, 0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)),
+			}},
+			"main program: OpClosure at offset 0: function refers to 1 free variables, got 0",
+		},
+		{
+			&Bytecode{Constants: []object.Object{
+				function(1, 0, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue)),
+			}},
+			"function in constant 0: OpGetLocal at offset 0: local 1 out of range",
+		},
+		{
+			&Bytecode{Constants: []object.Object{
+				function(1, 2, code.Make(code.OpReturn)),
+			}},
+			"function in constant 0: 1 locals cannot hold 2 parameters",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpJump, 1),
+				code.Make(code.OpNull),
+			})},
+			"main program: OpJump at offset 0: jump target 1 is not an instruction",
+		},
+		{
+			&Bytecode{
+				Instructions: code.Make(code.OpNull),
+				Handlers:     code.HandlerTable{{Start: 0, End: 1, Target: 1}},
+			},
+			"main program: handler target 1 is not an instruction",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpAdd),
+			})},
+			"main program: OpAdd at offset 1 takes 2 values off the stack of depth 1",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 6),
+				code.Make(code.OpNull),
+				code.Make(code.OpNull),
+				code.Make(code.OpNull),
+			})},
+			"main program: stack depth 2 at offset 6, want=0",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			})},
+			"main program: OpReturnValue at offset 1 in main program",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, test.bytecode, false); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		_, err := Decode(&buf)
+		if err == nil {
+			t.Errorf("expected decode error %q but resulted in none", test.want)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), test.want) {
+			t.Errorf("wrong decode error. want=%q, got=%q", test.want, err)
+		}
+	}
+}
+
+func TestDecodeLimitsLengthsToInput(t *testing.T) {
+	// The length of the main instructions claims 4 GiB, which must not be allocated.
+	input := []byte{'M', 'N', 'K', 'Y', 0, FormatVersion, 0, code.Version, 0, 0xff, 0xff, 0xff, 0xff, 0}
+
+	_, err := Decode(bytes.NewReader(input))
+	if err == nil {
+		t.Fatalf("expected decode error but resulted in none")
+	}
+	if err.Error() != "malformed bytecode: unexpected EOF" {
+		t.Errorf("wrong decode error. want=%q, got=%q", "malformed bytecode: unexpected EOF", err)
+	}
+}
+
+func testLineTable(t *testing.T, want, got code.LineTable) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("wrong number of line entries. want=%d, got=%d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("wrong line entry %d. want=%+v, got=%+v", i, want[i], got[i])
+		}
+	}
+}
+
+func testNames(t *testing.T, want, got []string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("wrong number of names. want=%v, got=%v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("wrong name %d. want=%q, got=%q", i, want[i], got[i])
+		}
+	}
+}
+
+func testHandlers(t *testing.T, want, got code.HandlerTable) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("wrong number of handlers. want=%+v, got=%+v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("wrong handler %d. want=%+v, got=%+v", i, want[i], got[i])
+		}
+	}
+}
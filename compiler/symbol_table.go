@@ -8,6 +8,10 @@ const (
 	GlobalScope  SymbolScope = "GLOBAL"
 	BuiltinScope SymbolScope = "BUILTIN"
 	FreeScope    SymbolScope = "FREE"
+
+	// FunctionScope is the scope of the name of the function being compiled,
+	// which refers to the closure currently executing in its own body.
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 // Symbol all the necessary information to be used to retrieve
@@ -26,6 +30,10 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 
+	// names holds the names of the definitions by their indexes,
+	// including the ones shadowed by later definitions.
+	names []string
+
 	FreeSymbols []Symbol
 }
 
@@ -42,7 +50,24 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 }
 
 // Define adds the symbol defined in global scope to the symbol table and returns symbol.
+// Defining the name again in the same table reuses the slot of the previous definition,
+// so that let statements repeated in a loop keep updating the same variable.
 func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
+	return s.define(name)
+}
+
+// DefineParameter defines the parameter of the function, which always occupies a new slot
+// because the arguments are put in the slots in order. The parameter repeating the name
+// of an earlier one shadows it, like the evaluator binds the arguments in order.
+func (s *SymbolTable) DefineParameter(name string) Symbol {
+	return s.define(name)
+}
+
+func (s *SymbolTable) define(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	s.store[name] = symbol
 
@@ -53,10 +78,16 @@ func (s *SymbolTable) Define(name string) Symbol {
 	}
 
 	s.store[name] = symbol
+	s.names = append(s.names, name)
 	s.numDefinitions++
 	return symbol
 }
 
+// Names returns the names of the symbols defined in the table, indexed by the slots they occupy.
+func (s *SymbolTable) Names() []string {
+	return append([]string{}, s.names...)
+}
+
 // Resolve retrieves the symbol table by name and returns symbol.
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
@@ -84,6 +115,15 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// DefineFunctionName defines the name the function is bound to in the table of its body,
+// so that the function can call itself without capturing the variable being defined.
+// It doesn't occupy a slot of locals, and any later definition of the same name shadows it.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
@@ -300,3 +300,77 @@ func TestResolveUnresolvableFree(t *testing.T) {
 		}
 	}
 }
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestShadowingFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+	global.Define("a")
+
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestDefineParameter(t *testing.T) {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+	local.DefineParameter("a")
+	second := local.DefineParameter("a")
+
+	expected := Symbol{Name: "a", Scope: LocalScope, Index: 1}
+	if second != expected {
+		t.Errorf("expected parameter to be %+v, got=%+v", expected, second)
+	}
+
+	result, ok := local.Resolve("a")
+	if !ok || result != expected {
+		t.Errorf("expected a to resolve to %+v, got=%+v", expected, result)
+	}
+}
+
+func TestNames(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("a")
+	global.Define("b")
+	redefined := global.Define("a")
+
+	if redefined.Index != 0 {
+		t.Errorf("redefinition doesn't reuse the slot. got=%d", redefined.Index)
+	}
+
+	expected := []string{"a", "b"}
+
+	names := global.Names()
+	if len(names) != len(expected) {
+		t.Fatalf("wrong number of names. want=%v, got=%v", expected, names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("name at %d wrong. want=%q, got=%q", i, name, names[i])
+		}
+	}
+}
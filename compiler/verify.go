@@ -0,0 +1,316 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/toversus/monkey/code"
+	"github.com/toversus/monkey/object"
+)
+
+// instruction is the decoded instruction at the offset of the instructions.
+type instruction struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+
+	// next is the offset of the next instruction.
+	next int
+}
+
+// verify checks the decoded bytecode so that the VM never reads out of the instructions,
+// the constant pool or the frames of the functions, however the input is corrupted.
+func verify(bytecode *Bytecode) error {
+	main := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Handlers:     bytecode.Handlers,
+	}
+
+	fns := []*object.CompiledFunction{main}
+	names := []string{"main program"}
+	for i, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fns = append(fns, fn)
+			names = append(names, fmt.Sprintf("function in constant %d", i))
+		}
+	}
+
+	v := &verifier{
+		constants:    bytecode.Constants,
+		instructions: map[*object.CompiledFunction][]instruction{},
+		free:         map[*object.CompiledFunction]int{},
+	}
+
+	// The free variables a function refers to are needed to verify the closures of it
+	// created by the other functions, so all the instructions are decoded first.
+	for i, fn := range fns {
+		instructions, err := readInstructions(fn.Instructions)
+		if err != nil {
+			return fmt.Errorf("%s: %s", names[i], err)
+		}
+		v.instructions[fn] = instructions
+
+		for _, ins := range instructions {
+			switch ins.op {
+			case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+				if ins.operands[0] >= v.free[fn] {
+					v.free[fn] = ins.operands[0] + 1
+				}
+			}
+		}
+	}
+
+	for i, fn := range fns {
+		if err := v.verifyFunction(fn, fn == main); err != nil {
+			return fmt.Errorf("%s: %s", names[i], err)
+		}
+	}
+	// The main program runs as the closure without free variables.
+	if v.free[main] > 0 {
+		return fmt.Errorf("main program: free variable %d out of range", v.free[main]-1)
+	}
+	return nil
+}
+
+// readInstructions decodes the instructions, checking that every opcode is defined
+// and has all of its operands.
+func readInstructions(ins code.Instructions) ([]instruction, error) {
+	var instructions []instruction
+
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return nil, fmt.Errorf("%s at offset %d", err, offset)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if offset+1+width > len(ins) {
+			return nil, fmt.Errorf("operands of %s at offset %d are truncated", def.Name, offset)
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		instructions = append(instructions, instruction{
+			offset:   offset,
+			op:       code.Opcode(ins[offset]),
+			def:      def,
+			operands: operands,
+			next:     offset + 1 + read,
+		})
+		offset += 1 + read
+	}
+	return instructions, nil
+}
+
+type verifier struct {
+	constants    []object.Object
+	instructions map[*object.CompiledFunction][]instruction
+
+	// free is the number of the free variables each function refers to.
+	free map[*object.CompiledFunction]int
+}
+
+func (v *verifier) verifyFunction(fn *object.CompiledFunction, main bool) error {
+	params := fn.NumParameters
+	if fn.Variadic {
+		params++
+	}
+	if fn.NumLocals < params {
+		return fmt.Errorf("%d locals cannot hold %d parameters", fn.NumLocals, params)
+	}
+
+	// The jumps and the handlers may only go to the start of an instruction, or the end of the function.
+	starts := map[int]bool{len(fn.Instructions): true}
+	for _, ins := range v.instructions[fn] {
+		starts[ins.offset] = true
+	}
+
+	if len(fn.DefaultEntries) > 0 && len(fn.DefaultEntries)-1 > fn.NumParameters {
+		return fmt.Errorf("%d defaults for %d parameters", len(fn.DefaultEntries)-1, fn.NumParameters)
+	}
+	for _, entry := range fn.DefaultEntries {
+		if !starts[entry] {
+			return fmt.Errorf("default entry %d is not an instruction", entry)
+		}
+	}
+
+	for _, h := range fn.Handlers {
+		if h.Start < 0 || h.Start > h.End || h.End > len(fn.Instructions) {
+			return fmt.Errorf("handler range %d to %d out of instructions", h.Start, h.End)
+		}
+		if !starts[h.Target] || h.Target == len(fn.Instructions) {
+			return fmt.Errorf("handler target %d is not an instruction", h.Target)
+		}
+		if h.Depth < 0 {
+			return fmt.Errorf("negative handler depth %d", h.Depth)
+		}
+	}
+
+	for _, ins := range v.instructions[fn] {
+		switch ins.op {
+		case code.OpReturnValue, code.OpReturn, code.OpTailCall:
+			// The main program has no caller to return to.
+			if main {
+				return fmt.Errorf("%s at offset %d in main program", ins.def.Name, ins.offset)
+			}
+		}
+
+		if err := v.verifyOperands(fn, ins, starts); err != nil {
+			return fmt.Errorf("%s at offset %d: %s", ins.def.Name, ins.offset, err)
+		}
+	}
+	return v.verifyStack(fn)
+}
+
+func (v *verifier) verifyOperands(fn *object.CompiledFunction, ins instruction, starts map[int]bool) error {
+	switch ins.op {
+	case code.OpConstant:
+		if ins.operands[0] >= len(v.constants) {
+			return fmt.Errorf("constant %d out of range", ins.operands[0])
+		}
+
+	case code.OpClosure, code.OpImport:
+		index, free := ins.operands[0], ins.operands[1]
+		if ins.op == code.OpImport {
+			// The module runs as the closure without free variables.
+			index, free = ins.operands[1], 0
+		}
+
+		if index >= len(v.constants) {
+			return fmt.Errorf("constant %d out of range", index)
+		}
+		target, ok := v.constants[index].(*object.CompiledFunction)
+		if !ok {
+			return fmt.Errorf("constant %d is not a function", index)
+		}
+		if free < v.free[target] {
+			return fmt.Errorf("function refers to %d free variables, got %d", v.free[target], free)
+		}
+
+	case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+		if ins.operands[0] >= fn.NumLocals {
+			return fmt.Errorf("local %d out of range", ins.operands[0])
+		}
+
+	case code.OpGetBuiltin:
+		if ins.operands[0] >= len(object.Builtins) {
+			return fmt.Errorf("builtin %d out of range", ins.operands[0])
+		}
+
+	case code.OpJump, code.OpJumpNotTruthy:
+		if !starts[ins.operands[0]] {
+			return fmt.Errorf("jump target %d is not an instruction", ins.operands[0])
+		}
+	}
+	return nil
+}
+
+// verifyStack follows the jumps from the start of the function and the handlers from their targets
+// like stackDepths, checking that no instruction takes more values off the stack than there are
+// above the locals, and that the stack has the same depth whichever path reaches an instruction.
+func (v *verifier) verifyStack(fn *object.CompiledFunction) error {
+	instructions := map[int]instruction{}
+	for _, ins := range v.instructions[fn] {
+		instructions[ins.offset] = ins
+	}
+
+	depths := map[int]int{}
+	work := []int{}
+	visit := func(offset, depth int) error {
+		if d, ok := depths[offset]; ok {
+			if d != depth {
+				return fmt.Errorf("stack depth %d at offset %d, want=%d", depth, offset, d)
+			}
+			return nil
+		}
+		depths[offset] = depth
+		work = append(work, offset)
+		return nil
+	}
+
+	if err := visit(0, 0); err != nil {
+		return err
+	}
+	for _, h := range fn.Handlers {
+		// The handler finds the exception pushed at its depth.
+		if err := visit(h.Target, h.Depth+1); err != nil {
+			return err
+		}
+	}
+
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		ins, ok := instructions[offset]
+		if !ok {
+			// The end of the function.
+			continue
+		}
+		depth := depths[offset]
+		next := ins.next
+
+		if n := stackInput(ins.op, ins.operands); depth < n {
+			return fmt.Errorf("%s at offset %d takes %d values off the stack of depth %d",
+				ins.def.Name, offset, n, depth)
+		}
+
+		var err error
+		switch ins.op {
+		case code.OpJump:
+			err = visit(ins.operands[0], depth)
+
+		case code.OpJumpNotTruthy:
+			if err = visit(ins.operands[0], depth-1); err == nil {
+				err = visit(next, depth-1)
+			}
+
+		case code.OpIterNext:
+			// It pushes either the element and true, or only false, which OpJumpNotTruthy must take off.
+			jump, ok := instructions[next]
+			if !ok || jump.op != code.OpJumpNotTruthy {
+				return fmt.Errorf("OpIterNext at offset %d is not followed by OpJumpNotTruthy", offset)
+			}
+			if err = visit(jump.operands[0], depth); err == nil {
+				err = visit(jump.next, depth+1)
+			}
+
+		case code.OpReturnValue, code.OpReturn, code.OpThrow, code.OpRethrow:
+			// The execution doesn't go on to the next instruction.
+
+		default:
+			err = visit(next, depth+stackEffect(ins.op, ins.operands))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackInput returns how many values the instruction takes off the stack, or expects on it.
+func stackInput(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpPop, code.OpMinus, code.OpBang, code.OpJumpNotTruthy,
+		code.OpSetGlobal, code.OpSetLocal, code.OpSetFree, code.OpReturnValue,
+		code.OpGetIter, code.OpIterNext, code.OpThrow, code.OpCatch, code.OpRethrow,
+		code.OpMatchArray, code.OpSlice:
+		return 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+		code.OpIndex, code.OpMatchValue:
+		return 2
+	case code.OpSetIndex:
+		return 3
+	case code.OpArray, code.OpHash, code.OpConcat, code.OpDup:
+		return operands[0]
+	case code.OpClosure:
+		return operands[1]
+	case code.OpCall, code.OpTailCall, code.OpCallSpread, code.OpMatchHash:
+		return operands[0] + 1
+	default:
+		return 0
+	}
+}
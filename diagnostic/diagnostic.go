@@ -0,0 +1,59 @@
+// Package diagnostic defines the structured messages reported by the front end of the language,
+// which are consumed by the REPL, the command line and editor integrations.
+package diagnostic
+
+import (
+	"fmt"
+
+	"github.com/toversus/monkey/token"
+)
+
+// Severity tells how serious the reported problem is.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Info
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Info:
+		return "info"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic describes a problem found in the range of source code between Pos and End.
+// Code is a stable identifier of the kind of the problem, which tools can match against
+// instead of parsing the human-readable Message.
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Position
+	End      token.Position
+	Code     string
+	Message  string
+
+	// Fix is an optional edit which is likely to resolve the problem.
+	Fix *SuggestedFix
+}
+
+// SuggestedFix replaces the source code between Pos and End with NewText.
+// An insertion has the same Pos and End.
+type SuggestedFix struct {
+	Message string
+	Pos     token.Position
+	End     token.Position
+	NewText string
+}
+
+// String returns the diagnostic in the form of "file:line:column: severity[code]: message".
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Pos, d.Severity, d.Code, d.Message)
+}
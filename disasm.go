@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/toversus/monkey/compiler"
+)
+
+// disasm prints the bytecode of the script, which is either Monkey source code or serialized bytecode.
+// It returns the exit code of the process.
+func disasm(path string) int {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var (
+		bytecode *compiler.Bytecode
+		sources  map[string]string
+	)
+	if compiler.IsSerialized(src) {
+		bytecode, err = compiler.Decode(bytes.NewReader(src))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return 1
+		}
+	} else {
+		// The positions of the script are recorded with the path as it is given.
+		sources = map[string]string{path: string(src)}
+
+		var ok bool
+		if bytecode, ok = compileFile(path, string(src), sources); !ok {
+			return 1
+		}
+	}
+
+	if err := compiler.Disassemble(os.Stdout, bytecode, sources); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -1,14 +1,61 @@
 package evaluator
 
 import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/toversus/monkey/ast"
 	"github.com/toversus/monkey/object"
+	"github.com/toversus/monkey/token"
 )
 
 var builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"push":  object.GetBuiltinByName("push"),
-	"puts":  object.GetBuiltinByName("puts"),
+	"len":    object.GetBuiltinByName("len"),
+	"first":  object.GetBuiltinByName("first"),
+	"last":   object.GetBuiltinByName("last"),
+	"rest":   object.GetBuiltinByName("rest"),
+	"push":   object.GetBuiltinByName("push"),
+	"puts":   object.GetBuiltinByName("puts"),
+	"slice":  object.GetBuiltinByName("slice"),
+	"bytes":  object.GetBuiltinByName("bytes"),
+	"gensym": {Fn: gensymBuiltin},
+}
+
+// gensymCounter makes the names generated by gensym unique within the process,
+// so the macros expanded in different lines of the REPL never reuse a name.
+var gensymCounter uint64
+
+// gensym generates the name which no identifier written in the source code can match.
+// The lexer doesn't accept '@' in identifiers.
+func gensym(prefix string) string {
+	if prefix == "" {
+		prefix = "g"
+	}
+	return fmt.Sprintf("%s@%d", prefix, atomic.AddUint64(&gensymCounter, 1))
+}
+
+// gensymBuiltin is only available in the evaluator because it is meant for the body of macros.
+// It returns a fresh identifier as the quoted node, which is put into the quoted code with unquote.
+// The bindings introduced by the quoted code are renamed in the same way by the macro expansion.
+func gensymBuiltin(args ...object.Object) object.Object {
+	prefix := ""
+
+	switch len(args) {
+	case 0:
+	case 1:
+		str, ok := args[0].(*object.String)
+		if !ok {
+			return newError("argument to 'gensym' must be STRING, got %s", args[0].Type())
+		}
+		prefix = str.Value
+	default:
+		return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+	}
+
+	name := gensym(prefix)
+	ident := &ast.Identifier{
+		Token: token.Token{Type: token.IDENT, Literal: name},
+		Value: name,
+	}
+	return &object.Quote{Node: ident}
 }
@@ -2,9 +2,12 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/toversus/monkey/ast"
 	"github.com/toversus/monkey/object"
+	"github.com/toversus/monkey/token"
 )
 
 var (
@@ -28,17 +31,40 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalBlockStatement(node, env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		var val object.Object
+		if env.CallFrame() != nil {
+			val = evalTail(node.ReturnValue, env)
+		} else {
+			val = Eval(node.ReturnValue, env)
+		}
 		if isError(val) {
 			return val
 		}
-		return &object.ReturnValue{Value: val}
+		return &object.ReturnValue{Value: val, Pos: node.Pos()}
+
+	case *ast.BreakStatement:
+		return &object.Break{Pos: node.Pos()}
+
+	case *ast.ContinueStatement:
+		return &object.Continue{Pos: node.Pos()}
+
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		err := newError("uncaught exception: %s", val.Inspect())
+		err.Value = val
+		return withPosition(err, node, env)
 
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
+		if node.Pattern != nil {
+			return withPosition(destructure(node.Pattern, val, env), node, env)
+		}
 		env.Set(node.Name.Value, val)
 
 	// Expressions starts here
@@ -54,7 +80,23 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{
+			Parameters: params,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
+			Body:       body,
+			Env:        env,
+			Name:       node.Name,
+		}
+
+	case *ast.SpreadExpression:
+		return withPosition(newError("spread is only allowed in call arguments"), node, env)
+
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
+
+	case *ast.ImportExpression:
+		return withPosition(evalImportExpression(node, env), node, env)
 
 	case *ast.ArrayLiteral:
 		elements := evalExpression(node.Elements, env)
@@ -64,24 +106,23 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return &object.Array{Elements: elements}
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return withPosition(evalHashLiteral(node, env), node, env)
 
 	case *ast.CallExpression:
 		if node.Function.TokenLiteral() == "quote" {
+			if len(node.Arguments) != 1 {
+				err := newError("wrong number of arguments to quote. got=%d, want=1", len(node.Arguments))
+				return withPosition(err, node, env)
+			}
 			return quote(node.Arguments[0], env)
 		}
 
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-
-		args := evalExpression(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
+		function, args, err := evalCall(node, env)
+		if err != nil {
+			return err
 		}
 
-		return applyFunction(function, args)
+		return withPosition(applyFunction(function, args, node.Pos(), env), node, env)
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
@@ -92,19 +133,26 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return withPosition(evalIndexExpression(left, index), node, env)
 
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
+	case *ast.NullLiteral:
+		return NULL
+
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return withPosition(evalPrefixExpression(node.Operator, right, env.OverflowPolicy()), node, env)
 
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -115,13 +163,28 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return withPosition(evalInfixExpression(node.Operator, left, right, env.OverflowPolicy()), node, env)
 
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.AssignExpression:
+		return withPosition(evalAssignExpression(node, env), node, env)
+
+	case *ast.WhileExpression:
+		return evalWhileExpression(node, env)
+
+	case *ast.ForExpression:
+		return evalForExpression(node, env)
+
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env, Eval)
+
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return withPosition(evalIdentifier(node, env), node, env)
 	}
 
 	return nil
@@ -138,12 +201,121 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return loopSignalError(result, env)
+		}
+	}
+
+	return result
+}
+
+// evalCall evaluates the function and the arguments of the call expression.
+func evalCall(node *ast.CallExpression, env *object.Environment) (object.Object, []object.Object, object.Object) {
+	function := Eval(node.Function, env)
+	if isError(function) {
+		return nil, nil, function
+	}
+
+	args := evalArguments(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return nil, nil, args[0]
+	}
+
+	return function, args, nil
+}
+
+// evalArguments evaluates the arguments of the call like evalExpression,
+// except that the spread of an array passes its elements as the arguments.
+func evalArguments(exps []ast.Expression, env *object.Environment) []object.Object {
+	var result []object.Object
+
+	for _, e := range exps {
+		spread, ok := e.(*ast.SpreadExpression)
+		if !ok {
+			evaluated := Eval(e, env)
+			if isError(evaluated) {
+				return []object.Object{evaluated}
+			}
+			result = append(result, evaluated)
+			continue
 		}
+
+		evaluated := Eval(spread.Value, env)
+		if isError(evaluated) {
+			return []object.Object{evaluated}
+		}
+		array, ok := evaluated.(*object.Array)
+		if !ok {
+			err := newError("spread of non-array argument: %s", evaluated.Type())
+			return []object.Object{withPosition(err, spread, env)}
+		}
+		result = append(result, array.Elements...)
 	}
 
 	return result
 }
 
+// evalTail evaluates the node in tail position of the function body, whose value the function returns.
+// The call to another function in tail position is returned as *object.TailCall instead of being applied.
+func evalTail(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		var result object.Object
+
+		for i, statement := range node.Statements {
+			if i == len(node.Statements)-1 {
+				return evalTail(statement, env)
+			}
+
+			result = Eval(statement, env)
+			if result != nil {
+				switch result.Type() {
+				case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+					return result
+				}
+			}
+		}
+
+		return result
+
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env)
+
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if isTruthy(condition) {
+			return evalTail(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return evalTail(node.Alternative, env)
+		}
+		return NULL
+
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env, evalTail)
+
+	case *ast.CallExpression:
+		if node.Function.TokenLiteral() == "quote" {
+			return Eval(node, env)
+		}
+
+		function, args, err := evalCall(node, env)
+		if err != nil {
+			return err
+		}
+
+		if fn, ok := function.(*object.Function); ok {
+			return &object.TailCall{Function: fn, Arguments: args, CallSite: node.Pos()}
+		}
+		return withPosition(applyFunction(function, args, node.Pos(), env), node, env)
+	}
+
+	return Eval(node, env)
+}
+
 // evalBlockStatement only checks the type of each evaluation result and never unwrap the return value.
 // This means that it returns not object.RETURN_VALUE_OBJ but *object.ReturnValue
 // so it stpos the execution in a possible outer block statement and bubbles up to evalProgram.
@@ -154,8 +326,8 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 		result = Eval(statement, env)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return result
 			}
 		}
@@ -164,6 +336,389 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// evalAssignExpression evaluates the target of the index expression only once,
+// even for the compound assignment which reads the element before updating it.
+func evalAssignExpression(ae *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := ae.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if ae.Operator != "=" {
+			current = evalIdentifier(target, env)
+			if isError(current) {
+				return current
+			}
+		}
+
+		val := evalAssignedValue(ae, current, env)
+		if isError(val) {
+			return val
+		}
+
+		if !env.Assign(target.Value, val) {
+			if _, ok := builtins[target.Value]; ok {
+				return newError("cannot assign to builtin %s", target.Value)
+			}
+			return newError("cannot assign to undefined variable %s", target.Value)
+		}
+		return val
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		var current object.Object
+		if ae.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+
+		val := evalAssignedValue(ae, current, env)
+		if isError(val) {
+			return val
+		}
+
+		if err := setIndex(left, index, val); err != nil {
+			return err
+		}
+		return val
+
+	default:
+		return newError("cannot assign to %s", ae.Target.String())
+	}
+}
+
+// evalAssignedValue applies the operator of the compound assignment to the current value.
+func evalAssignedValue(ae *ast.AssignExpression, current object.Object, env *object.Environment) object.Object {
+	val := Eval(ae.Value, env)
+	if isError(val) || ae.Operator == "=" {
+		return val
+	}
+
+	operator := strings.TrimSuffix(ae.Operator, "=")
+	return evalInfixExpression(operator, current, val, env.OverflowPolicy())
+}
+
+// setIndex stores the value into the element of array or hash.
+// Unlike reading them, writing out of the range of array is an error.
+func setIndex(left, index, val object.Object) *object.Error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		array := left.(*object.Array)
+		idx := index.(*object.Integer).Value
+		if idx < 0 || idx >= int64(len(array.Elements)) {
+			return newError("index out of range: %d", idx)
+		}
+		array.Elements[idx] = val
+		return nil
+
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		hash := left.(*object.Hash)
+		hash.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+		return nil
+
+	default:
+		return newError("index assignment not supported for %s: %s", left.Type(), index.Type())
+	}
+}
+
+func evalWhileExpression(we *ast.WhileExpression, env *object.Environment) object.Object {
+	for {
+		condition := Eval(we.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		if result, done := evalLoopBody(we.Body, env); done {
+			return result
+		}
+	}
+}
+
+func evalForExpression(fe *ast.ForExpression, env *object.Environment) object.Object {
+	iterable := Eval(fe.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	iter, ok := object.NewIterator(iterable)
+	if !ok {
+		err := newError("cannot iterate over %s", iterable.Type())
+		return withPosition(err, fe.Iterable, env)
+	}
+
+	for {
+		el, ok := iter.Next()
+		if !ok {
+			return NULL
+		}
+
+		env.Set(fe.Variable.Value, el)
+
+		if result, done := evalLoopBody(fe.Body, env); done {
+			return result
+		}
+	}
+}
+
+// evalLoopBody runs one iteration and reports whether the loop is over.
+// The loop evaluates to null when it is left by break, and returns the value and errors as they are.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := Eval(body, env)
+	if result == nil {
+		return nil, false
+	}
+
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	case object.BREAK_OBJ:
+		return NULL, true
+	default:
+		return nil, false
+	}
+}
+
+// loopSignalError turns break or continue which no loop catches into an error.
+func loopSignalError(obj object.Object, env *object.Environment) object.Object {
+	var err *object.Error
+
+	switch obj := obj.(type) {
+	case *object.Break:
+		err = &object.Error{Message: "break outside loop", Pos: obj.Pos}
+	case *object.Continue:
+		err = &object.Error{Message: "continue outside loop", Pos: obj.Pos}
+	default:
+		return obj
+	}
+
+	err.Trace = env.StackTrace(err.Pos)
+	return err
+}
+
+// evalTryExpression evaluates the catch block if the block raises an error, with the thrown value
+// or the hash describing the runtime error bound to the parameter. The finally block is evaluated
+// after them even if they return or leave the loop, and its error replaces the one being raised.
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := evalTryBlock(te.Block, env)
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil {
+		if te.Parameter != nil {
+			env.Set(te.Parameter.Value, caughtValue(err))
+		}
+		result = evalTryBlock(te.Catch, env)
+	}
+
+	if te.Finally != nil {
+		switch final := evalBlockStatement(te.Finally, env).(type) {
+		case *object.Error:
+			return final
+		case *object.ReturnValue:
+			return &object.Error{Message: "return out of finally block", Pos: final.Pos, Trace: env.StackTrace(final.Pos)}
+		case *object.Break:
+			return &object.Error{Message: "break out of finally block", Pos: final.Pos, Trace: env.StackTrace(final.Pos)}
+		case *object.Continue:
+			return &object.Error{Message: "continue out of finally block", Pos: final.Pos, Trace: env.StackTrace(final.Pos)}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// evalTryBlock evaluates the block of the try expression. The tail call returned from the block is
+// applied here instead of the caller of the function, so that the errors it raises are caught.
+func evalTryBlock(block *ast.BlockStatement, env *object.Environment) object.Object {
+	result := evalBlockStatement(block, env)
+
+	if rv, ok := result.(*object.ReturnValue); ok {
+		if tc, ok := rv.Value.(*object.TailCall); ok {
+			val := applyFunction(tc.Function, tc.Arguments, tc.CallSite, env)
+			if isError(val) {
+				return val
+			}
+			return &object.ReturnValue{Value: val, Pos: rv.Pos}
+		}
+	}
+	return result
+}
+
+// caughtValue returns the value the catch block receives for the error.
+func caughtValue(err *object.Error) object.Object {
+	if err.Value != nil {
+		return err.Value
+	}
+	return object.NewErrorHash(err.Message, err.Trace)
+}
+
+// evalMatchExpression evaluates the body of the first arm whose pattern matches the subject
+// and whose guard is truthy with eval, which evaluates the body in tail position or not.
+// It results in null if no arm matches.
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment, eval func(ast.Node, *object.Environment) object.Object) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		if !matchPattern(arm.Pattern, subject, env) {
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, env)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		if result := eval(arm.Body, env); result != nil {
+			return result
+		}
+		return NULL
+	}
+
+	return NULL
+}
+
+// matchPattern reports whether the value matches the pattern. The identifiers in the pattern are bound
+// to the values in the environment as they are matched, even if the rest of the pattern doesn't match.
+func matchPattern(pattern ast.Expression, value object.Object, env *object.Environment) bool {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			env.Set(pattern.Value, value)
+		}
+		return true
+
+	case *ast.ArrayPattern:
+		array, ok := value.(*object.Array)
+		if !ok || len(array.Elements) < len(pattern.Elements) ||
+			pattern.Rest == nil && len(array.Elements) != len(pattern.Elements) {
+			return false
+		}
+
+		for i, element := range pattern.Elements {
+			if !matchPattern(element, array.Elements[i], env) {
+				return false
+			}
+		}
+		if pattern.Rest != nil {
+			return matchPattern(pattern.Rest, sliceArray(array, len(pattern.Elements)), env)
+		}
+		return true
+
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false
+		}
+
+		// All of the keys are looked up before the values are matched, like the VM does.
+		values := make([]object.Object, len(pattern.Pairs))
+		for i, pair := range pattern.Pairs {
+			key, ok := Eval(pair.Key, env).(object.Hashable)
+			if !ok {
+				return false
+			}
+			found, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				return false
+			}
+			values[i] = found.Value
+		}
+
+		for i, pair := range pattern.Pairs {
+			if !matchPattern(pair.Value, values[i], env) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return object.Equal(Eval(pattern, env), value)
+	}
+}
+
+// destructure binds the identifiers in the pattern of the let statement to the parts of the value,
+// which are indexed like the index expression does: the missing elements and keys are null,
+// and the value which can't be indexed is an error. It returns nil unless an error occurs.
+func destructure(pattern ast.Expression, value object.Object, env *object.Environment) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			env.Set(pattern.Value, value)
+		}
+
+	case *ast.ArrayPattern:
+		for i, element := range pattern.Elements {
+			el := evalIndexExpression(value, &object.Integer{Value: int64(i)})
+			if isError(el) {
+				return el
+			}
+			if err := destructure(element, el, env); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			rest := sliceArray(value, len(pattern.Elements))
+			if isError(rest) {
+				return rest
+			}
+			return destructure(pattern.Rest, rest, env)
+		}
+
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			val := evalIndexExpression(value, Eval(pair.Key, env))
+			if isError(val) {
+				return val
+			}
+			if err := destructure(pair.Value, val, env); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sliceArray returns the new array of the elements of the array from the index,
+// which is empty if the array has no more elements.
+func sliceArray(value object.Object, start int) object.Object {
+	array, ok := value.(*object.Array)
+	if !ok {
+		return newError("rest of array pattern not supported: %s", value.Type())
+	}
+
+	elements := []object.Object{}
+	if start < len(array.Elements) {
+		elements = append(elements, array.Elements[start:]...)
+	}
+	return &object.Array{Elements: elements}
+}
+
 // nativeBoolToBooleanObject converts native bool object to reference of "true" and "false" instances
 // instead of allocating new object.
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -175,12 +730,12 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 
 // evalPrefixExpression checks operator and returns NULL if it is not supported,
 // which is not best solution but the easiest one.
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func evalPrefixExpression(operator string, right object.Object, policy object.OverflowPolicy) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusPrefixOperatorExpression(right)
+		return evalMinusPrefixOperatorExpression(right, policy)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -202,11 +757,15 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 
 // evalMinusPrefixOperatorExpression checks its operand and returns NULL if it is not integer,
 // then allocate new object to wrap negated version of its value.
-func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+func evalMinusPrefixOperatorExpression(right object.Object, policy object.OverflowPolicy) object.Object {
 	switch right.Type() {
 	case object.INTEGER_OBJ:
 		value := right.(*object.Integer).Value
-		return &object.Integer{Value: -value}
+		result, err := object.IntegerArithmetic("-", 0, value, policy)
+		if err != nil {
+			return newError("%s: -(%d)", err, value)
+		}
+		return &object.Integer{Value: result}
 
 	case object.FLOAT_OBJ:
 		value := right.(*object.Float).Value
@@ -222,10 +781,11 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 func evalInfixExpression(
 	operator string,
 	left, right object.Object,
+	policy object.OverflowPolicy,
 ) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(operator, left, right, policy)
 
 	case left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ:
 		return evalFloatInfixExpression(operator, left, right)
@@ -253,23 +813,30 @@ func evalInfixExpression(
 func evalIntegerInfixExpression(
 	operator string,
 	left, right object.Object,
+	policy object.OverflowPolicy,
 ) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
 	switch operator {
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "+", "-", "*", "/", "%":
+		result, err := object.IntegerArithmetic(operator, leftVal, rightVal, policy)
+		switch err {
+		case nil:
+			return &object.Integer{Value: result}
+		case object.ErrIntegerOverflow:
+			return newError("%s: %d %s %d", err, leftVal, operator, rightVal)
+		default:
+			return newError("%s", err)
+		}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -315,10 +882,16 @@ func evalFloatInfixExpression(
 		return &object.Float{Value: leftVal * rightVal}
 	case "/":
 		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -329,6 +902,114 @@ func evalFloatInfixExpression(
 	}
 }
 
+// evalInterpolatedString concatenates the string representations of the parts.
+func evalInterpolatedString(is *ast.InterpolatedString, env *object.Environment) object.Object {
+	var out strings.Builder
+
+	for _, part := range is.Parts {
+		value := Eval(part, env)
+		if isError(value) {
+			return value
+		}
+		out.WriteString(value.Inspect())
+	}
+
+	return &object.String{Value: out.String()}
+}
+
+// evalImportExpression evaluates the module in its own environment at the first import,
+// and results in the hash of its exports, which is shared by the later imports of the same module.
+func evalImportExpression(ie *ast.ImportExpression, env *object.Environment) object.Object {
+	imports := env.Imports()
+	if imports == nil {
+		return newError("cannot import %q: imports are not enabled", ie.Path.Value)
+	}
+
+	path, err := imports.Importer.Resolve(ie.Pos().Filename, ie.Path.Value)
+	if err != nil {
+		return newError("%s", err)
+	}
+	if exports, ok := imports.Modules[path]; ok {
+		return exports
+	}
+
+	for i, loading := range imports.Loading {
+		if loading == path {
+			cycle := append(imports.Loading[i:len(imports.Loading):len(imports.Loading)], path)
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	program, err := imports.Importer.Load(path)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	imports.Loading = append(imports.Loading, path)
+	moduleEnv := env.NewModuleEnvironment(&object.CallFrame{
+		Function: "<module>",
+		CallSite: ie.Pos(),
+		Caller:   env.CallFrame(),
+	})
+	result := evalModule(program, moduleEnv)
+	imports.Loading = imports.Loading[:len(imports.Loading)-1]
+
+	if isError(result) {
+		return result
+	}
+
+	exports := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	for _, name := range program.Exports() {
+		value, ok := moduleEnv.Get(name)
+		if !ok {
+			continue
+		}
+		key := &object.String{Value: name}
+		exports.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	imports.Modules[path] = exports
+
+	return exports
+}
+
+// evalModule evaluates the statements of the module. Unlike the main program,
+// the module can't return at the top level because it results in its exports.
+func evalModule(program *ast.Program, env *object.Environment) object.Object {
+	for _, statement := range program.Statements {
+		switch result := Eval(statement, env).(type) {
+		case *object.ReturnValue:
+			return withPosition(newError("return outside function"), statement, env)
+		case *object.Error:
+			return result
+		case *object.Break, *object.Continue:
+			return loopSignalError(result, env)
+		}
+	}
+	return nil
+}
+
+// evalLogicalExpression evaluates the right side of && and || only when the left side
+// doesn't decide the result, which is always TRUE or FALSE.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 // evalIfExpression evaluates the consequence part of the conditional
 // when the condition is truthy. Truthy means that it doesn't necessarily need to be true.
 // It returns NULL when a conditional doesn't evauate to a value.
@@ -365,9 +1046,25 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// withPosition records the position of the node and the call chain of the environment
+// on the error which doesn't know where it comes from yet.
+// Errors bubbling up from the inner nodes keep their original positions and traces.
+func withPosition(obj object.Object, node ast.Node, env *object.Environment) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+		err.Trace = env.StackTrace(err.Pos)
+	}
+	return obj
+}
+
+// isError reports whether the evaluation of the enclosing expression stops with obj as its result.
+// Besides errors, it is the case of break and continue used in the operands, which leave the loop.
 func isError(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		switch obj.Type() {
+		case object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+			return true
+		}
 	}
 
 	return false
@@ -409,12 +1106,36 @@ func evalExpression(exps []ast.Expression, env *object.Environment) []object.Obj
 // applyFunction converts the fn parameter to a *object.Function or *object.Builtin reference
 // in order to get access to the function's environment and body.
 // For *object.Builtin, built-in functions never return value when calling them.
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// callSite and env describe where the function is called from, which are recorded for stack traces.
+func applyFunction(
+	fn object.Object,
+	args []object.Object,
+	callSite token.Position,
+	env *object.Environment,
+) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		// The calls in tail position are applied in this loop as the trampoline,
+		// replacing the call frame of the function which made the call.
+		// The call site stays the one in the caller, which the stack trace goes back to.
+		for {
+			call := &object.CallFrame{
+				Function: functionName(fn),
+				CallSite: callSite,
+				Caller:   env.CallFrame(),
+			}
+			extendedEnv, err := extendFunctionEnv(fn, args, call)
+			if err != nil {
+				return err
+			}
+			evaluated := unwrapReturnValue(evalTail(fn.Body, extendedEnv))
+
+			tailCall, ok := evaluated.(*object.TailCall)
+			if !ok {
+				return loopSignalError(evaluated, extendedEnv)
+			}
+			fn, args = tailCall.Function, tailCall.Arguments
+		}
 
 	case *object.Builtin:
 		if result := fn.Fn(args...); result != nil {
@@ -429,15 +1150,55 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 }
 
 // extendFunctionEnv is used for binding the arguments of the function call to the function's parameter names
-// in the enclosed environment.
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEncloseEnvironment(fn.Env)
+// in the enclosed environment, which also remembers the call for stack traces.
+// The default values of the missing arguments are evaluated in the environment in order,
+// so they can refer to the parameters before them, and the rest parameter is bound to the remaining arguments.
+// It returns the error if the number of arguments doesn't fit the parameters.
+func extendFunctionEnv(
+	fn *object.Function,
+	args []object.Object,
+	call *object.CallFrame,
+) (*object.Environment, object.Object) {
+	required := len(fn.Parameters)
+	for required > 0 && required <= len(fn.Defaults) && fn.Defaults[required-1] != nil {
+		required--
+	}
+	if len(args) < required || len(args) > len(fn.Parameters) && fn.Rest == nil {
+		return nil, newError("%s", object.ArityMessage(required, len(fn.Parameters), fn.Rest != nil, len(args)))
+	}
+
+	env := object.NewFunctionEnvironment(fn.Env, call)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if paramIdx < len(args) {
+			env.Set(param.Value, args[paramIdx])
+			continue
+		}
+
+		val := Eval(fn.Defaults[paramIdx], env)
+		if isError(val) {
+			return nil, loopSignalError(val, env)
+		}
+		env.Set(param.Value, val)
+	}
+
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		env.Set(fn.Rest.Value, &object.Array{Elements: rest})
 	}
 
-	return env
+	return env, nil
+}
+
+// functionName returns the name of the function for stack traces.
+func functionName(fn *object.Function) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
 }
 
 // unwrapReturnValue evaluates the function's body if it is an *object.ReturnValue
@@ -469,6 +1230,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -488,6 +1251,18 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+// evalStringIndexExpression returns the character at the index counted in runes, not in bytes.
+// Like arrays, it returns NULL if the index is out of range.
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	runes := []rune(str.(*object.String).Value)
+	idx := index.(*object.Integer).Value
+
+	if idx < 0 || idx >= int64(len(runes)) {
+		return NULL
+	}
+	return &object.String{Value: string(runes[idx])}
+}
+
 // evalHashLiteral iterates over the node and evaluates keyNode in first.
 // It checks if the call to Eval and type assertion about the evaluation result,
 // then it evaluates valuNode and adds the newly produced key-value pair to map insetance.
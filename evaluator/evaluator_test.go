@@ -1,8 +1,10 @@
 package evaluator
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/toversus/monkey/ast"
 	"github.com/toversus/monkey/lexer"
 	"github.com/toversus/monkey/object"
 	"github.com/toversus/monkey/parser"
@@ -28,6 +30,9 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"1 + 10 % 4 * 2", 5},
 	}
 
 	for _, test := range tests {
@@ -73,6 +78,13 @@ func TestEvalFloatExpression(t *testing.T) {
 		{"1.0 / 0.1", 10.0},
 		{"2.0 * 2.0 + 2.0", 6.0},
 		{"(5 + 10.0 * 2.5 + 15.0 / 3) * 2.1 + -10.1", 63.4},
+		{"7.5 % 2", 1.5},
+		{"1 + 2.5", 3.5},
+		{"2.5 + 1", 3.5},
+		{"3 - 0.5", 2.5},
+		{"2.5 * 2", 5.0},
+		{"3 / 2.0", 1.5},
+		{"(5 + 10.0 * 2.5 + 15.0 / 3) * 2 + -10", 60.0},
 	}
 
 	for _, test := range tests {
@@ -121,6 +133,19 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"2 >= 1.5", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"!(1 > 2) && 3 >= 3 || false", true},
 		{`"one" == "one"`, true},
 		{`"one" != "one"`, false},
 		{`"true" == "true"`, true},
@@ -147,6 +172,26 @@ func testBooleanObject(t *testing.T, obj object.Object, want bool) bool {
 	return true
 }
 
+func TestLogicalShortCircuit(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"let x = 0; let f = fn() { x = 1; true }; false && f(); x", 0},
+		{"let x = 0; let f = fn() { x = 1; true }; true && f(); x", 1},
+		{"let x = 0; let f = fn() { x = 1; true }; true || f(); x", 0},
+		{"let x = 0; let f = fn() { x = 1; true }; false || f(); x", 1},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		testIntegerObject(t, evaluated, test.want)
+	}
+
+	testBooleanObject(t, testEval("false && 1 + true"), false)
+	testBooleanObject(t, testEval("true || undefined"), true)
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input string
@@ -247,6 +292,415 @@ f(10);`,
 	}
 }
 
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i", 10},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let n = 0; for (c in \"abc\") { let n = n + 1; }; n", 3},
+		{"let sum = 0; for (k in {1: true, 2: true, 3: false}) { let sum = sum + k; }; sum", 6},
+		{"let i = 0; while (true) { if (i == 5) { break; } let i = i + 1; }; i", 5},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let sum = sum + x; }; sum", 8},
+		{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { if (y == 2) { break; } let n = n + 1; } }; n", 2},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"let f = fn(n) { let i = 0; while (i < n) { let i = i + 1; } i }; f(100000)", 100000},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + if (x == 2) { continue; } else { x } }; s", 4},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + if (x == 2) { break; } else { x } }; s", 1},
+		{"let f = fn(a, b) { a + b }; let s = 0; for (x in [1, 2, 3]) { s = s + f(x, if (x == 2) { break; } else { 10 }) }; s", 11},
+		{"let r = 0; while (r < 10) { r = r + len([1, 2, if (r > 3) { break; } else { 3 }]) }; r", 6},
+		{`let n = 0; for (x in [1, 2, 3]) { let h = {"a": x, "b": if (x == 2) { continue; } else { x }}; n = n + h["b"] }; n`, 4},
+		{"let c = 0; for (x in [1, 2]) { [x, for (y in [1, 2, 3]) { c = c + [y, if (y == 2) { break; } else { 1 }][1] }] }; c", 2},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		testIntegerObject(t, evaluated, test.want)
+	}
+
+	testNullObject(t, testEval("while (false) { 1 }"))
+	testNullObject(t, testEval("for (x in []) { x }"))
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(1000000, 0)", 500000500000},
+		{"let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0)", 5000050000},
+		{`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+		let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		if (even(100001)) { 1 } else { 0 }`, 0},
+		{"let count = fn(n) { while (true) { if (n == 0) { return 7; } return count(n - 1); } }; count(100000)", 7},
+		{"let f = fn(xs) { len(xs) }; f([1, 2, 3])", 3},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)", 100},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		testIntegerObject(t, evaluated, test.want)
+	}
+}
+
+func TestTailCallStackTrace(t *testing.T) {
+	input := `let fail = fn() { 1 + true };
+let middle = fn() { fail() };
+let outer = fn() { let x = middle(); x };
+outer();`
+
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned.")
+	}
+
+	// The frame of middle is replaced by the call to fail in tail position.
+	expected := []string{"<main> at 4:1", "outer at 3:28", "fail at 1:19"}
+
+	if len(errObj.Trace) != len(expected) {
+		t.Fatalf("wrong number of frames. wanted=%d, got=%d (%+v)",
+			len(expected), len(errObj.Trace), errObj.Trace)
+	}
+
+	for i, want := range expected {
+		got := errObj.Trace[i].Function + " at " + errObj.Trace[i].Pos.String()
+		if got != want {
+			t.Errorf("wrong frame at %d. wanted=%s, got=%s", i, want, got)
+		}
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { throw 1; 2 } catch (e) { e + 1 }", 2},
+		{"try { throw \"oops\"; } catch { 3 }", 3},
+		{"try { } catch (e) { 1 }", nil},
+		{`try { 5 + true } catch (e) { e["message"] }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`let f = fn() { [1][5] + true }; try { f() } catch (e) { e["trace"][1] }`, "f at 1:16"},
+		{`try { len(1) } catch (e) { "caught" }`, "caught"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to 'len' not supported, got INTEGER"},
+		{`let f = fn(x) { first(x) }; try { f(1) } catch (e) { e["trace"][1] }`, "f at 1:17"},
+		{`let f = fn() { try { push(1, 2) } catch (e) { 3 } }; f()`, 3},
+		{"let f = fn(n) { if (n == 0) { throw \"bottom\"; } f(n - 1) + 1 }; try { f(10) } catch (e) { e }", "bottom"},
+		{"try { try { throw 1; } catch (e) { throw e + 1; } } catch (e) { e }", 2},
+		{"1 + try { 2 + [3, fn() { throw 5; }()][0] } catch (e) { e }", 6},
+		{"let s = 0; for (x in [1, 2, 3]) { s += try { if (x == 2) { throw 10; } x } catch (e) { e } }; s", 14},
+		// The log records the order of the blocks as digits.
+		{"let log = 0; try { log = log * 10 + 1; } finally { log = log * 10 + 2; }; log", 12},
+		{"let log = 0; try { try { throw 1; } finally { log = log * 10 + 2; } } catch (e) { log = log * 10 + e; }; log", 21},
+		{"let log = 0; try { try { throw 1; } catch (e) { throw 3; } finally { log = log * 10 + 2; } } catch (e) { log = log * 10 + e; }; log", 23},
+		{"try { throw 1; } catch (e) { 2 } finally { 3 }", 2},
+		{"try { try { throw 1; } finally { throw 2; } } catch (e) { e }", 2},
+		{"let log = 0; let f = fn() { try { return 1; } finally { log = log * 10 + 2; } }; let r = f(); log * 10 + r", 21},
+		{"let log = 0; for (x in [1, 2, 3]) { try { if (x == 2) { break; } } finally { log = log * 10 + x; } }; log", 12},
+		{"let log = 0; for (x in [1, 2, 3]) { try { if (x == 2) { continue; } } finally { log = log * 10 + x; } }; log", 123},
+		{"let f = fn(n) { try { if (n == 0) { return 0; } return f(n - 1); } finally { } }; f(100)", 0},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		switch want := test.want.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(want))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != want {
+				t.Errorf("wrong value for %q. want=%q, got=%T (%+v)", test.input, want, evaluated, evaluated)
+			}
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestThrowErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"throw 1;", "1:1: uncaught exception: 1"},
+		{"let f = fn() { throw \"bad\"; }; try { f() } finally { }", "1:16: uncaught exception: bad"},
+		{"let f = fn() { try { 1 } finally { return 2; } }; f()", "1:36: return out of finally block"},
+		{"while (true) { try { 1 } finally { break; } }", "1:36: break out of finally block"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		got := errObj.Pos.String() + ": " + errObj.Message
+		if got != test.want {
+			t.Errorf("wrong error. want=%q, got=%q", test.want, got)
+		}
+	}
+}
+
+func TestLetDestructuring(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; rest", []int{3, 4}},
+		{"let [a, ...rest] = [1]; len(rest)", 0},
+		{"let [a, b] = [1]; b", nil},
+		{"let [_, b] = [1, 2]; b", 2},
+		{`let {name, age: years} = {"name": "monkey", "age": 3}; years`, 3},
+		{`let {name, age: years} = {"name": "monkey", "age": 3}; name`, "monkey"},
+		{`let {missing} = {}; missing`, nil},
+		{`let {"k": [x, {y}], 1: z} = {"k": [1, {"y": 2}], 1: 3}; x + y + z`, 6},
+		{"let pair = fn(x) { [x, x * 2] }; let [a, b] = pair(3); a + b", 9},
+		{"let f = fn(p) { let [a, b] = p; let {c} = b; a + c }; f([1, {\"c\": 2}])", 3},
+		{"let f = fn(x) { let [a] = x; fn() { a } }; f([5])()", 5},
+		{"let [a, b] = [1, 2]; let [a, b] = [b, a]; a", 2},
+		{"match ([1, 2, 3]) { [a, ...rest] => rest[1], _ => 0 }", 3},
+		{"match ([1]) { [a, b, ...rest] => 1, [a, ...rest] => len(rest) }", 0},
+		{`match ({"name": "m"}) { {name} => name }`, "m"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		switch want := test.want.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(want))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != want {
+				t.Errorf("wrong value for %q. want=%q, got=%T (%+v)", test.input, want, evaluated, evaluated)
+			}
+		case []int:
+			array, ok := evaluated.(*object.Array)
+			if !ok || len(array.Elements) != len(want) {
+				t.Errorf("wrong value for %q. want=%v, got=%T (%+v)", test.input, want, evaluated, evaluated)
+				continue
+			}
+			for i, el := range want {
+				testIntegerObject(t, array.Elements[i], int64(el))
+			}
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+
+	errorTests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a] = 1;", "index operator not supported for INTEGER: INTEGER"},
+		{`let {a} = [1];`, "index operator not supported for ARRAY: STRING"},
+		{`let [a, ...b] = "ab";`, "rest of array pattern not supported: STRING"},
+	}
+
+	for _, test := range errorTests {
+		errObj, ok := testEval(test.input).(*object.Error)
+		if !ok || errObj.Message != test.want {
+			t.Errorf("wrong error for %q. want=%q, got=%v", test.input, test.want, errObj)
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"match (1) { 1 => 10, _ => 20 }", 10},
+		{"match (2) { 1 => 10, _ => 20 }", 20},
+		{"match (3) { 1 => 10 }", nil},
+		{"match (-1) { -1 => 1, _ => 2 }", 1},
+		{"match (1.5) { 1.5 => 1, _ => 2 }", 1},
+		{"match (1) { 1.0 => 1, _ => 2 }", 2},
+		{`match ("ab") { "a" => 1, "ab" => 2, _ => 3 }`, 2},
+		{"match (true) { false => 1, true => 2 }", 2},
+		{"match (5) { x => x * 2 }", 10},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b, _ => 0 }", 3},
+		{"match ([1, 2, 3]) { [a, b] => a + b, _ => 0 }", 0},
+		{"match ([1, [2, 3]]) { [1, [a, 3]] => a, _ => 0 }", 2},
+		{"match ([1, [2, 4]]) { [1, [a, 3]] => a, _ => 0 }", 0},
+		{"match ([[1, 2], [3, 4]]) { [[_, b], [c, _]] => b + c }", 5},
+		{`match ({"type": "click", "x": 3}) { {"type": "key"} => 1, {"type": "click", "x": x} => x }`, 3},
+		{`match ({"type": "click"}) { {"type": "click", "x": x} => x, {"type": t} => t }`, "click"},
+		{`match ({1: [2, 3]}) { {1: [a, b]} => a * b }`, 6},
+		{`match ([1, 2]) { {"a": a} => a, _ => 0 }`, 0},
+		{`match ("ab") { [a, b] => a, _ => 0 }`, 0},
+		{"match (5) { x if x > 10 => 1, x if x > 1 => 2, _ => 3 }", 2},
+		{"match ([1, 2]) { [a, b] if a > b => 1, [a, b] => b }", 2},
+		{"match (1) { 1 => { let y = 2; y + 1 } _ => 0 }", 3},
+		{"match (1) { 1 => { } }", nil},
+		{"match (match (1) { 1 => 2 }) { 2 => match (3) { 3 => 4 }, _ => 0 }", 4},
+		{"let r = []; for (x in [1, [2, 3], 4]) { r = push(r, match (x) { [a, b] => a + b, _ => x }) }; r[1]", 5},
+		{"let f = fn(x) { match (x) { [a, b] => a + f(b), [a] => a, _ => 0 } }; f([1, [2, [3]]])", 6},
+		{"let f = fn(n, acc) { match (n) { 0 => acc, _ => f(n - 1, acc + n) } }; f(10000, 0)", 50005000},
+		{"let f = fn(x) { let g = match (x) { [a] => fn() { a } }; g() }; f([7])", 7},
+		{"1 + match (2) { 2 => 3 }", 4},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		switch want := test.want.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(want))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != want {
+				t.Errorf("wrong value for %q. want=%q, got=%T (%+v)", test.input, want, evaluated, evaluated)
+			}
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"for (x in 1) { x }", "1:11: cannot iterate over INTEGER"},
+		{"break;", "1:1: break outside loop"},
+		{"let f = fn() { continue; }; while (true) { f(); }", "1:16: continue outside loop"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		got := errObj.Pos.String() + ": " + errObj.Message
+		if got != test.want {
+			t.Errorf("wrong error. want=%q, got=%q", test.want, got)
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let x = 1; let y = 2; x = y = 3; x + y", 6},
+		{"let arr = [1, 2, 3]; arr[1] = 5; arr[1]", 5},
+		{"let arr = [1, 2, 3]; arr[2] += 10; arr[2]", 13},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`let h = {"a": 1}; h["a"] *= 7`, 7},
+		{"let x = 1; let f = fn() { x = 5; }; f(); x", 5},
+		{"let f = fn() { let n = 0; n += 2; n }; f()", 2},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let f = fn() { let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n }; f()", 2},
+		{"let f = fn() { let n = 1; let g = fn() { fn() { n *= 10 } }; g()(); g()(); n }; f()", 100},
+		{"let f = fn() { let a = [0]; let set = fn(v) { a[0] = v }; set(7); a[0] }; f()", 7},
+		{"let i = 0; let sum = 0; while (i < 5) { i += 1; sum += i; }; sum", 15},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		testIntegerObject(t, evaluated, test.want)
+	}
+}
+
+func TestRebindingRecursiveFunctions(t *testing.T) {
+	// The function refers to the variable it is bound to, which may be bound to another value.
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } }; let g = f; f = fn(n) { 2 }; g(1)", 2},
+		{"let w = fn() { let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } }; let g = f; f = fn(n) { 2 }; g(1) }; w()", 2},
+		{"let w = fn() { let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } }; let g = f; let f = fn(n) { 2 }; g(1) }; w()", 2},
+		{"let f = fn() { f = 5; f }; f()", 5},
+		{"let w = fn() { let f = fn() { f = 5; f }; f() }; w()", 5},
+		{"let w = fn() { let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } }; let g = f; g(3) }; w()", 1},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		testIntegerObject(t, evaluated, test.want)
+	}
+}
+
+func TestAssignErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"x = 1", "1:1: cannot assign to undefined variable x"},
+		{"len = 1", "1:1: cannot assign to builtin len"},
+		{"let a = [1]; a[1] = 2", "1:14: index out of range: 1"},
+		{"let s = \"ab\"; s[0] = \"c\"", "1:15: index assignment not supported for STRING: INTEGER"},
+		{"let x = true; x += 1", "1:15: type mismatch: BOOLEAN + INTEGER"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		got := errObj.Pos.String() + ": " + errObj.Message
+		if got != test.want {
+			t.Errorf("wrong error. want=%q, got=%q", test.want, got)
+		}
+	}
+}
+
+func TestIntegerArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		policy object.OverflowPolicy
+		want   interface{}
+	}{
+		{"1 / 0", object.OverflowError, "1:1: division by zero"},
+		{"let x = 0; 5 % x", object.OverflowWrap, "1:12: division by zero"},
+		{"9223372036854775807 + 1", object.OverflowError, "1:1: integer overflow: 9223372036854775807 + 1"},
+		{"let x = 4611686018427387904; x *= 2", object.OverflowError, "1:30: integer overflow: 4611686018427387904 * 2"},
+		{"let min = -9223372036854775807 - 1; -min", object.OverflowError, "1:37: integer overflow: -(-9223372036854775808)"},
+		{"let f = fn(x) { x / 0 }; f(1)", object.OverflowError, "1:17: division by zero"},
+		{"9223372036854775807 + 1", object.OverflowWrap, -9223372036854775808},
+		{"let min = -9223372036854775807 - 1; min / -1", object.OverflowWrap, -9223372036854775808},
+	}
+
+	for _, test := range tests {
+		program := parser.New(lexer.New(test.input)).ParseProgram()
+		env := object.NewEnvironment()
+		env.SetOverflowPolicy(test.policy)
+		evaluated := Eval(program, env)
+
+		switch want := test.want.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(want))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q. got=%T(%+v)", test.input, evaluated, evaluated)
+				continue
+			}
+			if got := errObj.Pos.String() + ": " + errObj.Message; got != want {
+				t.Errorf("wrong error. want=%q, got=%q", want, got)
+			}
+		}
+	}
+}
+
 // TestErrorHandling asserts that errors are created for unsupported operations
 // and that errors prevent any further evaluation.
 func TestErrorHandling(t *testing.T) {
@@ -321,6 +775,209 @@ if (10 > 1) {
 	}
 }
 
+// TestErrorPositions asserts that errors point to the node where they are raised
+// even when they bubble up through the function calls.
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantPos string
+	}{
+		{"5 + true;", "1:1"},
+		{"let x = 1;\nfoobar", "2:1"},
+		{"let f = fn(x) {\n  x - true\n};\nf(1)", "2:3"},
+		{`len(1)`, "1:1"},
+		{"[1, 2, 3][1.0]", "1:1"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Pos.String() != test.wantPos {
+			t.Errorf("wrong error position for %q. wanted=%s, got=%s",
+				test.input, test.wantPos, errObj.Pos)
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+let wrapper = fn() {
+  let sum = add(1, true);
+};
+wrapper();`
+
+	evaluated := testEval(input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%v)", evaluated, evaluated)
+	}
+
+	expected := []struct {
+		function string
+		pos      string
+	}{
+		{"<main>", "7:1"},
+		{"wrapper", "5:13"},
+		{"add", "2:3"},
+	}
+
+	if len(errObj.Trace) != len(expected) {
+		t.Fatalf("wrong number of frames. wanted=%d, got=%d (%+v)",
+			len(expected), len(errObj.Trace), errObj.Trace)
+	}
+
+	for i, frame := range expected {
+		actual := errObj.Trace[i]
+		if actual.Function != frame.function || actual.Pos.String() != frame.pos {
+			t.Errorf("wrong frame at %d. wanted=%s at %s, got=%s at %s",
+				i, frame.function, frame.pos, actual.Function, actual.Pos)
+		}
+	}
+
+	traceback := `Traceback (most recent call last):
+  File "<input>", line 7, column 1, in <main>
+  File "<input>", line 5, column 13, in wrapper
+  File "<input>", line 2, column 3, in add
+Error: type mismatch: INTEGER + BOOLEAN`
+
+	if errObj.Traceback() != traceback {
+		t.Errorf("wrong traceback.\nwanted=%s\ngot=%s", traceback, errObj.Traceback())
+	}
+}
+
+// mapImporter imports the modules from the source code in the map by their names.
+type mapImporter map[string]string
+
+func (m mapImporter) Resolve(importer, name string) (string, error) {
+	if _, ok := m[name]; !ok {
+		return "", fmt.Errorf("module %q not found", name)
+	}
+	return name, nil
+}
+
+func (m mapImporter) Load(path string) (*ast.Program, error) {
+	p := parser.New(lexer.NewWithFilename(path, m[path]))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", p.Errors()[0])
+	}
+	return program, nil
+}
+
+var testModules = mapImporter{
+	"counter": `let count = 0;
+let inc = fn() { count = count + 1; count };
+let _secret = 42;`,
+	"user": `let counter = import("counter"); let inc = counter["inc"];`,
+	"failing": `let fail = fn(x) {
+  x + true
+};
+let failed = fail(1);`,
+	"cycle_a": `import("cycle_b")`,
+	"cycle_b": `let x = 1; import("cycle_a")`,
+	"leaks":   "let y = x;",
+	"return":  "let f = fn() { return 1; };\nreturn f();",
+	"break":   "break;",
+}
+
+func testEvalWithImports(input string) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+	env.SetImporter(testModules)
+
+	return Eval(program, env)
+}
+
+func TestImports(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{`import("counter")["count"]`, 0},
+		{`let a = import("counter"); let b = import("counter"); a["inc"](); b["inc"]()`, 2},
+		{`let u = import("user"); u["inc"](); import("counter")["inc"]()`, 2},
+		{`import("counter")["_secret"]`, nil},
+		{`let count = 10; import("counter")["inc"](); count`, 10},
+	}
+
+	for _, test := range tests {
+		evaluated := testEvalWithImports(test.input)
+		if want, ok := test.want.(int); ok {
+			testIntegerObject(t, evaluated, int64(want))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantMsg string
+		wantPos string
+	}{
+		{`import("cycle_a")`, "import cycle: cycle_a -> cycle_b -> cycle_a", "cycle_b:1:12"},
+		{`let x = 1; import("leaks")`, "identifier not found: x", "leaks:1:9"},
+		{`import("return")`, "return outside function", "return:2:1"},
+		{`import("break")`, "break outside loop", "break:1:1"},
+		{`import("missing")`, `module "missing" not found`, "1:1"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEvalWithImports(test.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != test.wantMsg {
+			t.Errorf("wrong error message. wanted=%q, got=%q", test.wantMsg, errObj.Message)
+		}
+		if errObj.Pos.String() != test.wantPos {
+			t.Errorf("wrong error position for %q. wanted=%s, got=%s",
+				test.input, test.wantPos, errObj.Pos)
+		}
+	}
+
+	evaluated := testEval(`import("counter")`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != `cannot import "counter": imports are not enabled` {
+		t.Errorf("wrong error without importer. got=%T(%v)", evaluated, evaluated)
+	}
+}
+
+func TestImportStackTrace(t *testing.T) {
+	evaluated := testEvalWithImports(`import("failing")`)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%v)", evaluated, evaluated)
+	}
+
+	traceback := `Traceback (most recent call last):
+  File "<input>", line 1, column 1, in <main>
+  File "failing", line 4, column 14, in <module>
+  File "failing", line 2, column 3, in fail
+Error: type mismatch: INTEGER + BOOLEAN`
+
+	if errObj.Traceback() != traceback {
+		t.Errorf("wrong traceback.\nwanted=%s\ngot=%s", traceback, errObj.Traceback())
+	}
+}
+
 // TestLetStatements assert the value-producing expression in a let statement
 // and an identifier that's bound to a name.
 func TestLetStatements(t *testing.T) {
@@ -375,6 +1032,8 @@ func TestFunctionApplication(t *testing.T) {
 		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
 		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
 		{"fn(x) { x; }(5)", 5},
+		{"let f = fn(a, a) { a }; f(1, 2);", 2},
+		{"let f = fn(a, a = 5) { a }; f(1) * 10 + f(1, 2);", 52},
 	}
 
 	for _, test := range tests {
@@ -382,6 +1041,69 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", 3},
+		{"let f = fn(x = 1, y = x * 2) { x + y }; f()", 3},
+		{"let f = fn(x = 1, y = x * 2) { x + y }; f(5)", 15},
+		{"let n = 3; let f = fn(x, y = n) { x * y }; f(2)", 6},
+		{"let f = fn(x, y = fn() { x }) { y() }; f(4)", 4},
+		{"let f = fn(first, ...rest) { rest }; f(1, 2, 3)", []int{2, 3}},
+		{"let f = fn(first, ...rest) { len(rest) }; f(1)", 0},
+		{"let f = fn(a, b = 2, ...c) { a + b + len(c) }; f(1)", 3},
+		{"let f = fn(a, b = 2, ...c) { a + b + len(c) }; f(1, 5, 7, 7)", 8},
+		{"let sum = fn(...xs) { let s = 0; for (x in xs) { s += x }; s }; sum(1, 2, 3, 4)", 10},
+		{"let f = fn(x, y) { x - y }; f(...[5, 3])", 2},
+		{"let f = fn(x, y, z) { x * 100 + y * 10 + z }; f(1, ...[2], ...[3])", 123},
+		{"let f = fn(...xs) { xs }; f(...[], 1, ...[2, 3])", []int{1, 2, 3}},
+		{"let f = fn(x, y = 1) { x + y }; f(...[1])", 2},
+		{"len(...[[1, 2]])", 2},
+		{"let count = fn(n, acc = 0) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(10000)", 10000},
+		{"let f = fn(n, ...rest) { if (n == 0) { len(rest) } else { f(n - 1, ...rest, n) } }; f(3)", 3},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+		switch want := test.want.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(want))
+		case []int:
+			array, ok := evaluated.(*object.Array)
+			if !ok || len(array.Elements) != len(want) {
+				t.Errorf("wrong value for %q. want=%v, got=%T (%+v)", test.input, want, evaluated, evaluated)
+				continue
+			}
+			for i, el := range want {
+				testIntegerObject(t, array.Elements[i], int64(el))
+			}
+		}
+	}
+
+	errorTests := []struct {
+		input string
+		want  string
+	}{
+		{"fn(a) { a }()", "wrong number of arguments: want=1, got=0"},
+		{"fn(a, b) { a }(1, 2, 3)", "wrong number of arguments: want=2, got=3"},
+		{"fn(a, b = 1) { a }()", "wrong number of arguments: want=1 to 2, got=0"},
+		{"fn(a, b = 1) { a }(1, 2, 3)", "wrong number of arguments: want=1 to 2, got=3"},
+		{"fn(a, ...b) { a }()", "wrong number of arguments: want=at least 1, got=0"},
+		{"fn(a, b = c) { a }(1)", "identifier not found: c"},
+		{"fn(a) { a }(...1)", "spread of non-array argument: INTEGER"},
+	}
+
+	for _, test := range errorTests {
+		errObj, ok := testEval(test.input).(*object.Error)
+		if !ok || errObj.Message != test.want {
+			t.Errorf("wrong error for %q. want=%q, got=%v", test.input, test.want, errObj)
+		}
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
 let newAdder = fn(x) {
@@ -409,7 +1131,7 @@ func TestStringLiteral(t *testing.T) {
 }
 
 func TestStringConcatenation(t *testing.T) {
-	input := `"Hello" + " " + "World!`
+	input := `"Hello" + " " + "World!"`
 
 	evaluated := testEval(input)
 	str, ok := evaluated.(*object.String)
@@ -422,6 +1144,73 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`let name = "monkey"; let items = [1, 2]; "hello ${name}, you have ${len(items)} items"`, "hello monkey, you have 2 items"},
+		{`"${1.5} ${true} ${[1, "a"]} ${if (false) { 1 }}"`, "1.5 true [1, a] null"},
+		{`let h = {"a": "b"}; "${h} ${{1: [2]}}"`, "{a: b} {1: [2]}"},
+		{`let f = fn(x) { "<${x}>" }; "${f("${f(1)}")}"`, "<<1>>"},
+		{`let greet = macro(name) { quote("hi ${unquote(name)}") }; greet("you")`, "hi you"},
+	}
+
+	for _, test := range tests {
+		program := parser.New(lexer.New(test.input)).ParseProgram()
+		env := object.NewEnvironment()
+		expanded, _ := ExpandProgram(program, object.NewEnvironment())
+
+		str, ok := Eval(expanded, env).(*object.String)
+		if !ok {
+			t.Errorf("object is not String for %q.", test.input)
+			continue
+		}
+		if str.Value != test.want {
+			t.Errorf("String has wrong value. want=%q, got=%q", test.want, str.Value)
+		}
+	}
+
+	errObj, ok := testEval(`"a ${1 + true}"`).(*object.Error)
+	if !ok || errObj.Message != "type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong error. got=%+v", errObj)
+	}
+}
+
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{`"こんにちは"[0]`, "こ"},
+		{`"héllo"[1]`, "é"},
+		{`let s = "日本語"; s[len(s) - 1]`, "語"},
+		{`"abc"[3]`, nil},
+		{`"abc"[-1]`, nil},
+		{`slice("こんにちは", 2)`, "にちは"},
+		{`slice("こんにちは", 1, 3)`, "んに"},
+		{`slice("abc", 2, 1)`, ""},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		want, ok := test.want.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String for %q. got=%T (%+v)", test.input, evaluated, evaluated)
+			continue
+		}
+		if str.Value != want {
+			t.Errorf("String has wrong value. want=%q, got=%q", want, str.Value)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input string
@@ -434,6 +1223,17 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
+		{`len("こんにちは")`, 5},
+		{`len(bytes("こんにちは"))`, 15},
+		{`bytes("é")[1]`, 0xa9},
+		{`bytes(1)`, "argument to 'bytes' must be STRING, got INTEGER"},
+		{`len(slice([1, 2, 3], 1))`, 2},
+		{`slice([1, 2, 3], 1, 2)[0]`, 2},
+		{`len(slice([1, 2, 3], 2, 1))`, 0},
+		{`len(slice("こんにちは", -1, 99))`, 5},
+		{`slice(1, 0)`, "argument to 'slice' must be ARRAY or STRING, got INTEGER"},
+		{`slice("abc", "a")`, "bounds of 'slice' must be INTEGER, got STRING"},
+		{`slice("abc")`, "wrong number of arguments. got=1, want=2 or 3"},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, nil},
 		{`first(1)`, "argument to 'first' must be ARRAY, got INTEGER"},
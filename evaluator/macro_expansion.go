@@ -1,10 +1,36 @@
 package evaluator
 
 import (
+	"fmt"
+
 	"github.com/toversus/monkey/ast"
+	"github.com/toversus/monkey/diagnostic"
 	"github.com/toversus/monkey/object"
 )
 
+// Codes of the diagnostics reported by the macro expansion.
+const (
+	CodeMacroArguments = "E0101"
+	CodeMacroExpansion = "E0102"
+)
+
+// ExpandProgram runs the macro expansion phase, which comes before the evaluation or the compilation.
+// It moves the top-level macro definitions of the program into env and replaces the macro calls
+// with the nodes they return. The same env can be passed for a series of programs, e.g. the lines
+// of the REPL, so that the macros defined in the earlier ones remain available.
+// The macro calls which fail to expand are left as they are and reported as diagnostics.
+func ExpandProgram(program *ast.Program, env *object.Environment) (*ast.Program, []diagnostic.Diagnostic) {
+	DefineMacros(program, env)
+	expanded, diagnostics := ExpandMacros(program, env)
+	return expanded.(*ast.Program), diagnostics
+}
+
+// ExpandModule expands the macros in the program of the module, which is used as module.Loader.Expand.
+// The macros defined in the module are only available in the module itself.
+func ExpandModule(program *ast.Program) (*ast.Program, []diagnostic.Diagnostic) {
+	return ExpandProgram(program, object.NewEnvironment())
+}
+
 func DefineMacros(program *ast.Program, env *object.Environment) {
 	definitions := []int{}
 
@@ -26,7 +52,7 @@ func DefineMacros(program *ast.Program, env *object.Environment) {
 
 func isMacroDefinition(node ast.Statement) bool {
 	letStatement, ok := node.(*ast.LetStatement)
-	if !ok {
+	if !ok || letStatement.Name == nil {
 		return false
 	}
 
@@ -51,8 +77,10 @@ func addMacro(stmt ast.Statement, env *object.Environment) {
 	env.Set(letStatement.Name.Value, macro)
 }
 
-func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(program, func(node ast.Node) ast.Node {
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, []diagnostic.Diagnostic) {
+	var diagnostics []diagnostic.Diagnostic
+
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
 		callExpression, ok := node.(*ast.CallExpression)
 		if !ok {
 			return node
@@ -63,18 +91,283 @@ func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
 			return node
 		}
 
+		name := callExpression.Function.String()
+		report := func(code, format string, a ...interface{}) ast.Node {
+			diagnostics = append(diagnostics, diagnostic.Diagnostic{
+				Severity: diagnostic.Error,
+				Pos:      callExpression.Pos(),
+				End:      callExpression.End(),
+				Code:     code,
+				Message:  fmt.Sprintf("macro %s: ", name) + fmt.Sprintf(format, a...),
+			})
+			return node
+		}
+
+		if len(callExpression.Arguments) != len(macro.Parameters) {
+			return report(CodeMacroArguments, "wrong number of arguments. got=%d, want=%d",
+				len(callExpression.Arguments), len(macro.Parameters))
+		}
+
 		args := quoteArgs(callExpression)
 		evalEnv := extendMacroEnv(macro, args)
 
-		evaluated := Eval(macro.Body, evalEnv)
+		evaluated := unwrapReturnValue(Eval(macro.Body, evalEnv))
 
-		quote, ok := evaluated.(*object.Quote)
-		if !ok {
-			panic("we only support returning AST-node from macro")
+		switch evaluated := evaluated.(type) {
+		case *object.Quote:
+			return renameBindings(evaluated.Node, callExpression.Arguments)
+		case *object.Error:
+			if evaluated.Pos.IsValid() {
+				return report(CodeMacroExpansion, "%s: %s", evaluated.Pos, evaluated.Message)
+			}
+			return report(CodeMacroExpansion, "%s", evaluated.Message)
+		case nil:
+			return report(CodeMacroExpansion, "must return quoted node, got nothing")
+		default:
+			return report(CodeMacroExpansion, "must return quoted node, got %s", evaluated.Type())
 		}
-
-		return quote.Node
 	})
+
+	return expanded, diagnostics
+}
+
+// renameBindings makes the expansion hygienic. The names bound by the let statements, the function
+// parameters and the other bindings written in the body of the macro are replaced with the ones
+// generated by gensym together with the identifiers referring to them within the scope of the binding,
+// so they can neither capture nor shadow the bindings of the caller. The nodes passed as the arguments
+// of the macro call are left untouched.
+func renameBindings(node ast.Node, args []ast.Expression) ast.Node {
+	fromArgs := map[ast.Node]bool{}
+	for _, arg := range args {
+		ast.Modify(arg, func(n ast.Node) ast.Node {
+			fromArgs[n] = true
+			return n
+		})
+	}
+
+	r := &renamer{fromArgs: fromArgs}
+	r.enter(true)
+	r.walk(node)
+
+	return node
+}
+
+// renameScope maps the names bound in a block, or in the construct binding them like the function,
+// to the generated ones.
+type renameScope struct {
+	names map[string]string
+	outer *renameScope
+
+	// function reports whether the scope is the one of the function parameters,
+	// which the variables of the function belong to at runtime.
+	function bool
+}
+
+func (s *renameScope) lookup(name string) (string, bool) {
+	for ; s != nil; s = s.outer {
+		if renamed, ok := s.names[name]; ok {
+			return renamed, true
+		}
+	}
+	return "", false
+}
+
+// lookupFunction looks for the name up to the scope of the function parameters.
+func (s *renameScope) lookupFunction(name string) (string, bool) {
+	for ; s != nil; s = s.outer {
+		if renamed, ok := s.names[name]; ok {
+			return renamed, true
+		}
+		if s.function {
+			break
+		}
+	}
+	return "", false
+}
+
+// renamer walks the quoted node in the order of the evaluation, so that a binding covers the rest
+// of its scope from where it is made, and the identifiers outside of it still refer to the bindings
+// of the caller.
+type renamer struct {
+	fromArgs map[ast.Node]bool
+	scope    *renameScope
+}
+
+func (r *renamer) enter(function bool) {
+	r.scope = &renameScope{names: map[string]string{}, outer: r.scope, function: function}
+}
+
+func (r *renamer) leave() {
+	r.scope = r.scope.outer
+}
+
+// bind renames the identifier of the binding. The name already bound in the same function keeps
+// the generated name, because the variables are scoped by the function and it is the same variable.
+func (r *renamer) bind(ident *ast.Identifier) {
+	if ident == nil {
+		return
+	}
+	name, ok := r.scope.lookupFunction(ident.Value)
+	if !ok {
+		name = gensym(ident.Value)
+	}
+	r.scope.names[ident.Value] = name
+	ident.Value = name
+	ident.Token.Literal = name
+}
+
+func (r *renamer) rename(ident *ast.Identifier) {
+	if name, ok := r.scope.lookup(ident.Value); ok {
+		ident.Value = name
+		ident.Token.Literal = name
+	}
+}
+
+func (r *renamer) walk(node ast.Node) {
+	if r.fromArgs[node] {
+		return
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, statement := range node.Statements {
+			r.walk(statement)
+		}
+
+	case *ast.BlockStatement:
+		r.enter(false)
+		for _, statement := range node.Statements {
+			r.walk(statement)
+		}
+		r.leave()
+
+	case *ast.ExpressionStatement:
+		r.walk(node.Expression)
+
+	case *ast.LetStatement:
+		// The function bound by the let statement refers to itself by the name.
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok && node.Name != nil {
+			r.bind(node.Name)
+			r.walk(node.Value)
+			return
+		}
+		r.walk(node.Value)
+		for _, name := range node.Names() {
+			r.bind(name)
+		}
+
+	case *ast.ReturnStatement:
+		if node.ReturnValue != nil {
+			r.walk(node.ReturnValue)
+		}
+
+	case *ast.ThrowStatement:
+		r.walk(node.Value)
+
+	case *ast.Identifier:
+		r.rename(node)
+
+	case *ast.PrefixExpression:
+		r.walk(node.Right)
+
+	case *ast.InfixExpression:
+		r.walk(node.Left)
+		r.walk(node.Right)
+
+	case *ast.AssignExpression:
+		r.walk(node.Target)
+		r.walk(node.Value)
+
+	case *ast.CallExpression:
+		r.walk(node.Function)
+		for _, arg := range node.Arguments {
+			r.walk(arg)
+		}
+
+	case *ast.IndexExpression:
+		r.walk(node.Left)
+		r.walk(node.Index)
+
+	case *ast.IfExpression:
+		r.walk(node.Condition)
+		r.walk(node.Consequence)
+		if node.Alternative != nil {
+			r.walk(node.Alternative)
+		}
+
+	case *ast.WhileExpression:
+		r.walk(node.Condition)
+		r.walk(node.Body)
+
+	case *ast.ForExpression:
+		r.walk(node.Iterable)
+		r.enter(false)
+		r.bind(node.Variable)
+		r.walk(node.Body)
+		r.leave()
+
+	case *ast.TryExpression:
+		r.walk(node.Block)
+		if node.Catch != nil {
+			r.enter(false)
+			r.bind(node.Parameter)
+			r.walk(node.Catch)
+			r.leave()
+		}
+		if node.Finally != nil {
+			r.walk(node.Finally)
+		}
+
+	case *ast.MatchExpression:
+		r.walk(node.Subject)
+		for _, arm := range node.Arms {
+			r.enter(false)
+			for _, name := range ast.PatternNames(arm.Pattern) {
+				r.bind(name)
+			}
+			if arm.Guard != nil {
+				r.walk(arm.Guard)
+			}
+			r.walk(arm.Body)
+			r.leave()
+		}
+
+	case *ast.FunctionLiteral:
+		if name, ok := r.scope.lookup(node.Name); ok {
+			node.Name = name
+		}
+
+		// The default value of a parameter is evaluated after the parameters before it are bound.
+		r.enter(true)
+		for i, param := range node.Parameters {
+			if i < len(node.Defaults) && node.Defaults[i] != nil {
+				r.walk(node.Defaults[i])
+			}
+			r.bind(param)
+		}
+		r.bind(node.Rest)
+		r.walk(node.Body)
+		r.leave()
+
+	case *ast.SpreadExpression:
+		r.walk(node.Value)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			r.walk(el)
+		}
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			r.walk(part)
+		}
+
+	case *ast.HashLiteral:
+		for key, value := range node.Pairs {
+			r.walk(key)
+			r.walk(value)
+		}
+	}
 }
 
 func isMacroCall(
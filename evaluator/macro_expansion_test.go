@@ -1,6 +1,7 @@
 package evaluator
 
 import (
+	"regexp"
 	"testing"
 
 	"github.com/toversus/monkey/ast"
@@ -108,7 +109,10 @@ func TestExpandMacros(t *testing.T) {
 
 		env := object.NewEnvironment()
 		DefineMacros(program, env)
-		expanded := ExpandMacros(program, env)
+		expanded, diagnostics := ExpandMacros(program, env)
+		if len(diagnostics) != 0 {
+			t.Fatalf("unexpected diagnostics: %v", diagnostics)
+		}
 
 		if expanded.String() != expected.String() {
 			t.Errorf("not equal. want=%q, got=%q",
@@ -122,3 +126,198 @@ func testParseProgram(input string) *ast.Program {
 	p := parser.New(l)
 	return p.ParseProgram()
 }
+
+func TestExpandProgramKeepsMacros(t *testing.T) {
+	env := object.NewEnvironment()
+
+	first, _ := ExpandProgram(testParseProgram(`let double = macro(x) { quote(unquote(x) * 2) };`), env)
+	if len(first.Statements) != 0 {
+		t.Fatalf("macro definition wasn't removed. got=%q", first.String())
+	}
+
+	second, _ := ExpandProgram(testParseProgram(`double(1 + 2)`), env)
+	want := testParseProgram(`(1 + 2) * 2`)
+	if second.String() != want.String() {
+		t.Errorf("not equal. want=%q, got=%q", want.String(), second.String())
+	}
+}
+
+func TestMacroHygiene(t *testing.T) {
+	input := `
+	let twice = macro(x) {
+		quote(fn(tmp) { tmp + tmp }(unquote(x)));
+	};
+
+	let tmp = 5;
+	twice(tmp);
+	twice(tmp * 2);
+	`
+
+	program, diagnostics := ExpandProgram(testParseProgram(input), object.NewEnvironment())
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	names := []string{}
+	for i, arg := range []string{"tmp", "(tmp * 2)"} {
+		got := program.Statements[i+1].String()
+		name := regexp.MustCompile(`^fn\((tmp@\d+)\)`).FindStringSubmatch(got)
+		if name == nil {
+			t.Fatalf("binding of macro is not renamed. got=%q", got)
+		}
+
+		want := "fn(" + name[1] + ")(" + name[1] + " + " + name[1] + ")(" + arg + ")"
+		if got != want {
+			t.Errorf("not equal. want=%q, got=%q", want, got)
+		}
+		names = append(names, name[1])
+	}
+	if names[0] == names[1] {
+		t.Errorf("expansions share the same name %q", names[0])
+	}
+
+	evaluated := Eval(program, object.NewEnvironment())
+	testIntegerObject(t, evaluated, 20)
+}
+
+func TestMacroHygieneOfScopes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		// The binding of the macro only renames the identifiers within its scope.
+		{"let y = 100; let m = macro() { quote(fn(y) { y }(1) + y) }; m()", 101},
+		{"let y = 100; let m = macro() { quote(fn() { let r = y; let y = 1; r + y }()) }; m()", 101},
+		{"let y = 100; let m = macro() { quote(fn() { let f = fn() { y }; let y = 1; f() + y }()) }; m()", 101},
+		{"let y = 100; let m = macro() { quote(fn(y = y) { y }()) }; m()", 100},
+		{"let y = 100; let m = macro() { quote(fn() { let r = 0; for (y in [y + 1]) { r = y }; r + y }()) }; m()", 201},
+		{"let y = 100; let m = macro() { quote(fn() { let r = 0; if (true) { let y = 1; r = y }; r + y }()) }; m()", 101},
+		{`let y = 100; let m = macro() { quote(try { throw 1; } catch (y) { y } + y) }; m()`, 101},
+		{"let y = 100; let m = macro() { quote(match (1) { y => y } + y) }; m()", 101},
+		// The nested function refers to the binding of the function enclosing it.
+		{"let y = 100; let m = macro() { quote(fn(y) { fn() { y }() }(1)) }; m()", 1},
+		{"let m = macro() { quote(fn() { let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(3) }()) }; m()", 0},
+		{"let y = 100; let m = macro() { quote(fn() { let y = 1; y = y + 1; y }() + y) }; m()", 102},
+		// The variable bound again in a nested block of the same function is the same variable.
+		{"let m = macro() { quote(fn() { let y = 1; if (true) { let y = 2; }; y }()) }; m()", 2},
+	}
+
+	for _, test := range tests {
+		program, diagnostics := ExpandProgram(testParseProgram(test.input), object.NewEnvironment())
+		if len(diagnostics) != 0 {
+			t.Fatalf("unexpected diagnostics: %v", diagnostics)
+		}
+
+		evaluated := Eval(program, object.NewEnvironment())
+		testIntegerObject(t, evaluated, test.want)
+	}
+}
+
+func TestMacroHygieneOfTryParameter(t *testing.T) {
+	input := `
+	let rescue = macro(x) {
+		quote(try { throw 1; } catch (e) { unquote(x) });
+	};
+
+	let e = "caller";
+	rescue(e);
+	`
+
+	program, diagnostics := ExpandProgram(testParseProgram(input), object.NewEnvironment())
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	evaluated := Eval(program, object.NewEnvironment())
+	str, ok := evaluated.(*object.String)
+	if !ok || str.Value != "caller" {
+		t.Errorf("parameter of catch captures the argument. got=%T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestMacroHygieneOfMatchPatterns(t *testing.T) {
+	tests := []string{
+		`let m = macro(x) { quote(match (1) { e => unquote(x) }) }; let e = "caller"; m(e)`,
+		`let m = macro(x) { quote(match ([1, 2]) { [a, ...e] => unquote(x) }) }; let e = "caller"; m(e)`,
+		`let m = macro(x) { quote(match ({"k": 1}) { {"k": e} => unquote(x) }) }; let e = "caller"; m(e)`,
+		`let m = macro(x) { quote(match ({"e": 1}) { {e} => unquote(x) }) }; let e = "caller"; m(e)`,
+		`let m = macro(x) { quote(match ([[1]]) { [[e]] => unquote(x), _ => 0 }) }; let e = "caller"; m(e)`,
+	}
+
+	for _, input := range tests {
+		program, diagnostics := ExpandProgram(testParseProgram(input), object.NewEnvironment())
+		if len(diagnostics) != 0 {
+			t.Fatalf("unexpected diagnostics: %v", diagnostics)
+		}
+
+		evaluated := Eval(program, object.NewEnvironment())
+		str, ok := evaluated.(*object.String)
+		if !ok || str.Value != "caller" {
+			t.Errorf("pattern captures the argument for %q. got=%T (%+v)", input, evaluated, evaluated)
+		}
+	}
+}
+
+func TestGensym(t *testing.T) {
+	input := `
+	let ident = macro() {
+		let name = gensym("x");
+		quote([unquote(name), unquote(gensym())]);
+	};
+
+	ident();
+	`
+
+	program, diagnostics := ExpandProgram(testParseProgram(input), object.NewEnvironment())
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	got := program.String()
+	if !regexp.MustCompile(`^\[x@\d+, g@\d+\]$`).MatchString(got) {
+		t.Errorf("wrong generated names. got=%q", got)
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantCode string
+		wantMsg  string
+	}{
+		{
+			"let m = macro(a, b) { quote(unquote(a) + unquote(b)) };\nm(1)",
+			CodeMacroArguments,
+			"2:1: error[E0101]: macro m: wrong number of arguments. got=1, want=2",
+		},
+		{
+			"let m = macro() { 1 };\nm()",
+			CodeMacroExpansion,
+			"2:1: error[E0102]: macro m: must return quoted node, got INTEGER",
+		},
+		{
+			"let m = macro() { quote(unquote(fn(x) { x })) };\nm()",
+			CodeMacroExpansion,
+			"2:1: error[E0102]: macro m: 1:25: unquote: cannot convert FUNCTION to AST node",
+		},
+		{
+			"let m = macro() { quote(unquote(1 + true)) };\nm()",
+			CodeMacroExpansion,
+			"2:1: error[E0102]: macro m: 1:33: type mismatch: INTEGER + BOOLEAN",
+		},
+	}
+
+	for _, test := range tests {
+		_, diagnostics := ExpandProgram(testParseProgram(test.input), object.NewEnvironment())
+		if len(diagnostics) != 1 {
+			t.Fatalf("wrong number of diagnostics. want=1, got=%d", len(diagnostics))
+		}
+
+		if diagnostics[0].Code != test.wantCode {
+			t.Errorf("wrong code. want=%q, got=%q", test.wantCode, diagnostics[0].Code)
+		}
+		if diagnostics[0].String() != test.wantMsg {
+			t.Errorf("wrong diagnostic. want=%q, got=%q", test.wantMsg, diagnostics[0].String())
+		}
+	}
+}
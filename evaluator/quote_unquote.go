@@ -8,14 +8,23 @@ import (
 	"github.com/toversus/monkey/token"
 )
 
+// quote works on the copy of the node because the same quote call is evaluated every time
+// the macro containing it is expanded.
 func quote(node ast.Node, env *object.Environment) object.Object {
-	node = evalUnquoteCalls(node, env)
+	node, err := evalUnquoteCalls(ast.Copy(node), env)
+	if err != nil {
+		return err
+	}
 	return &object.Quote{Node: node}
 }
 
-func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(quoted, func(node ast.Node) ast.Node {
-		if !isUnquoteCall(node) {
+// evalUnquoteCalls replaces the unquote calls in the quoted node with the results of their arguments.
+// It stops at the first error, which is returned instead of leaving the broken nodes in the tree.
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var err *object.Error
+
+	node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if err != nil || !isUnquoteCall(node) {
 			return node
 		}
 
@@ -25,12 +34,27 @@ func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
 		}
 
 		if len(call.Arguments) != 1 {
+			err = newError("wrong number of arguments to unquote. got=%d, want=1", len(call.Arguments))
+			withPosition(err, call, env)
 			return node
 		}
 
 		unquoted := Eval(call.Arguments[0], env)
-		return convertObjectToASTNode(unquoted)
+		if isError(unquoted) {
+			err = loopSignalError(unquoted, env).(*object.Error)
+			return node
+		}
+
+		converted, ok := convertObjectToASTNode(unquoted, call.Pos())
+		if !ok {
+			err = newError("unquote: cannot convert %s to AST node", unquoted.Type())
+			withPosition(err, call, env)
+			return node
+		}
+		return converted
 	})
+
+	return node, err
 }
 
 func isUnquoteCall(node ast.Node) bool {
@@ -42,28 +66,85 @@ func isUnquoteCall(node ast.Node) bool {
 	return callExpression.Function.TokenLiteral() == "unquote"
 }
 
-func convertObjectToASTNode(obj object.Object) ast.Node {
+// convertObjectToASTNode turns the value back into the node evaluated to the same value.
+// The tokens of the new nodes carry pos, which is the position of the unquote call.
+func convertObjectToASTNode(obj object.Object, pos token.Position) (ast.Node, bool) {
 	switch obj := obj.(type) {
 	case *object.Integer:
 		t := token.Token{
 			Type:    token.INT,
 			Literal: fmt.Sprintf("%d", obj.Value),
+			Pos:     pos,
 		}
-		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.Float:
+		t := token.Token{
+			Type:    token.FLOAT,
+			Literal: obj.Inspect(),
+			Pos:     pos,
+		}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}, true
 
 	case *object.Boolean:
 		var t token.Token
 		if obj.Value {
-			t = token.Token{Type: token.TRUE, Literal: "true"}
+			t = token.Token{Type: token.TRUE, Literal: "true", Pos: pos}
 		} else {
-			t = token.Token{Type: token.FALSE, Literal: "false"}
+			t = token.Token{Type: token.FALSE, Literal: "false", Pos: pos}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}, true
+
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value, Pos: pos}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.Null:
+		return &ast.NullLiteral{Token: token.Token{Pos: pos}}, true
+
+	case *object.Array:
+		elements := make([]ast.Expression, len(obj.Elements))
+		for i, el := range obj.Elements {
+			node, ok := convertObjectToASTNode(el, pos)
+			if !ok {
+				return nil, false
+			}
+			elements[i], ok = node.(ast.Expression)
+			if !ok {
+				return nil, false
+			}
+		}
+		t := token.Token{Type: token.LBRACKET, Literal: "[", Pos: pos}
+		return &ast.ArrayLiteral{Token: t, Elements: elements}, true
+
+	case *object.Hash:
+		pairs := make(map[ast.Expression]ast.Expression, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := convertObjectToASTNode(pair.Key, pos)
+			if !ok {
+				return nil, false
+			}
+			value, ok := convertObjectToASTNode(pair.Value, pos)
+			if !ok {
+				return nil, false
+			}
+			keyExp, ok := key.(ast.Expression)
+			if !ok {
+				return nil, false
+			}
+			valueExp, ok := value.(ast.Expression)
+			if !ok {
+				return nil, false
+			}
+			pairs[keyExp] = valueExp
 		}
-		return &ast.Boolean{Token: t, Value: obj.Value}
+		t := token.Token{Type: token.LBRACE, Literal: "{", Pos: pos}
+		return &ast.HashLiteral{Token: t, Pairs: pairs}, true
 
 	case *object.Quote:
-		return obj.Node
+		return obj.Node, true
 
 	default:
-		return nil
+		return nil, false
 	}
 }
@@ -15,6 +15,10 @@ func TestQuoteUnquote(t *testing.T) {
 			`quote(unquote(4))`,
 			`4`,
 		},
+		{
+			`let name = "monkey"; quote("hello ${unquote(name)}, ${x}")`,
+			`hello monkey, ${x}`,
+		},
 		{
 			`quote(unquote(4 + 4))`,
 			`8`,
@@ -45,6 +49,30 @@ func TestQuoteUnquote(t *testing.T) {
 			`quote(unquote(true == false))`,
 			`false`,
 		},
+		{
+			`quote(unquote("foo" + "bar"))`,
+			`foobar`,
+		},
+		{
+			`quote(unquote(1.5 * 2.0))`,
+			`3`,
+		},
+		{
+			`quote(unquote([1, true, "two"]))`,
+			`[1, true, two]`,
+		},
+		{
+			`quote(unquote({"one": [1]}))`,
+			`{one:[1]}`,
+		},
+		{
+			`quote(unquote(if (false) { 1 }))`,
+			`null`,
+		},
+		{
+			`quote(len(unquote(4 + 4)))`,
+			`len(8)`,
+		},
 		{
 			`quote(unquote(quote(4 + 4)))`,
 			`(4 + 4)`,
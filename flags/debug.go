@@ -3,8 +3,5 @@ package flags
 import "flag"
 
 // Debug is a flag to turn debug mode.
+// It is parsed by the main packages along with their own flags.
 var Debug = flag.Bool("debug", false, "Print debug information?")
-
-func init() {
-	flag.Parse()
-}
@@ -1,12 +1,27 @@
 package lexer
 
-import "github.com/toversus/monkey/token"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/toversus/monkey/token"
+)
 
 // Lexer is used to take source code as input and output the tokens that represent the source code.
-// TODO: Fully support Unicode (and emojis).
+// The source code is decoded as UTF-8.
 type Lexer struct {
 	input string
 
+	// filename is the name of the source recorded in the position of every token.
+	filename string
+
+	// line and column are the position of the current char, both starting from 1.
+	line   int
+	column int
+
 	// position is current position in input (points to current char).
 	position int
 
@@ -14,76 +29,157 @@ type Lexer struct {
 	// It is useful to "peek" what comes up next after current char.
 	readPosition int
 
+	// interpolations holds the number of the unclosed braces in each of the interpolated expressions
+	// being lexed, from the outermost to the innermost. The "}" closes the innermost one
+	// when no brace is left unclosed in it.
+	interpolations []int
+
 	// ch is current char under examination, corresponding to the char in the position.
-	// Change of the type to rune will be required if handling the full Unicode and UTF-8 chars.
-	ch byte
+	ch rune
+
+	// keepComments makes the comments attached to the following token instead of being discarded.
+	keepComments bool
 }
 
 // readChar throws the next char and advances the position in the input string.
-// It only supports ASCII characters and doesn't aim to support full Unicode range
-// due to remaining the simplicity.
+// The position is counted in bytes, and the column is counted in chars.
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
+	size := 1
 	if l.readPosition >= len(l.input) {
 		// Set the ASCII code for the "NUL" char.
 		// This means either "could not reead any chars yet" or "end of file".
 		l.ch = 0
 	} else {
-		// Set the next char, but only valid in ASCII world.
-		l.ch = l.input[l.readPosition]
+		// The invalid UTF-8 byte is read as utf8.RuneError of size 1.
+		l.ch, size = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	// Advances the position in the input string.
 	l.position = l.readPosition
-	l.readPosition++
+	l.readPosition += size
 }
 
 // New initialized the Lexer.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// NewWithFilename initializes the Lexer which records the given file name in the token positions.
+func NewWithFilename(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
+// KeepComments makes the lexer attach the comments to the token following them,
+// so tools like formatters can keep them. The parser ignores them either way.
+func (l *Lexer) KeepComments() *Lexer {
+	l.keepComments = true
+	return l
+}
+
+// currentPos returns the position of the current char.
+func (l *Lexer) currentPos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
 // NextToken returns a token parsed after examination of the current char
 // and advances the pointers to the next char in input.
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	var comments []token.Comment
+	for {
+		l.skipWhitespace()
+		if l.ch != '/' || l.peekChar() != '/' && l.peekChar() != '*' {
+			break
+		}
+
+		pos := l.currentPos()
+		text, ok := l.readComment()
+		if !ok {
+			return token.Token{Type: token.ERROR, Literal: "unterminated comment", Pos: pos, End: l.currentPos()}
+		}
+		if l.keepComments {
+			comments = append(comments, token.Comment{Text: text, Pos: pos, End: l.currentPos()})
+		}
+	}
+
+	pos := l.currentPos()
+	tok := l.nextToken()
+	tok.Pos = pos
+	tok.End = l.currentPos()
+	tok.Comments = comments
+
+	return tok
+}
+
+// readComment reads the line comment up to the end of the line, or the block comment up to "*/".
+// Block comments don't nest. It returns false if the block comment isn't closed until the end of input.
+func (l *Lexer) readComment() (string, bool) {
+	position := l.position
+	l.readChar()
+
+	if l.ch == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return strings.TrimSuffix(l.input[position:l.position], "\r"), true
+	}
+
+	l.readChar()
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		if l.ch == 0 {
+			return "", false
+		}
+		l.readChar()
+	}
+	l.readChar()
+	l.readChar()
+	return l.input[position:l.position], true
+}
 
-	l.skipWhitespace()
+// nextToken examines the current char and returns the token without its position.
+func (l *Lexer) nextToken() token.Token {
+	var tok token.Token
 
 	// TODO: Consider to replace the branching method from switch to map.
 	switch l.ch {
 	case '=':
-		tok = newToken(token.ASSIGN, l.ch)
-		// Token will be updated when detecting "==" tokens.
-		// The following process will be abstracted when supporting more two-char tokens.
-		if l.peekChar() == '=' {
-			// memorize current char before readChar calls overwrites current char.
-			ch := l.ch
+		if l.peekChar() == '>' {
 			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.EQ, Literal: literal}
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
+			break
 		}
+		tok = l.withEqual(token.ASSIGN, token.EQ)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.withEqual(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.withEqual(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
-		tok = newToken(token.BANG, l.ch)
-		// Token will be updated when detecting "!=" tokens.
-		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOTEQ, Literal: literal}
-		}
+		tok = l.withEqual(token.BANG, token.NOTEQ)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.withEqual(token.ASTERISK, token.ASTERISK_ASSIGN)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.withEqual(token.SLASH, token.SLASH_ASSIGN)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.withEqual(token.LT, token.LT_EQ)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.withEqual(token.GT, token.GT_EQ)
+	case '&':
+		tok = l.doubled(token.AND)
+	case '|':
+		tok = l.doubled(token.OR)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ':':
@@ -96,7 +192,20 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RPAREN, l.ch)
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1]++
+		}
 	case '}':
+		n := len(l.interpolations)
+		if n > 0 && l.interpolations[n-1] == 0 {
+			// The brace closes the interpolated expression and the string goes on.
+			l.interpolations = l.interpolations[:n-1]
+			tok = l.readString(token.STRING_MIDDLE, token.STRING_TAIL)
+			break
+		}
+		if n > 0 {
+			l.interpolations[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
@@ -105,9 +214,23 @@ func (l *Lexer) NextToken() token.Token {
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
+	case '.':
+		if strings.HasPrefix(l.input[l.position:], "...") {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+			break
+		}
+		// The float beginning with the dot can't directly follow a word, e.g. "a.5" or "a1.5".
+		prev, _ := utf8.DecodeLastRuneInString(l.input[:l.position])
+		if isDigit(l.peekChar()) && !isLetter(prev) && !isDigit(prev) {
+			return l.readNumericToken()
+		}
+		tok = newToken(token.ILLEGAL, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString(token.STRING_HEAD, token.STRING)
+	case '`':
+		tok = l.readRawString()
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
@@ -124,6 +247,31 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// withEqual returns the two-char token ending with '=' such as "==" or "+=" if '=' follows the current char,
+// and the one-char token otherwise.
+func (l *Lexer) withEqual(one, two token.TokenType) token.Token {
+	if l.peekChar() != '=' {
+		return newToken(one, l.ch)
+	}
+
+	// memorize current char before readChar calls overwrites current char.
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: two, Literal: string(ch) + string(l.ch)}
+}
+
+// doubled returns the two-char token such as "&&" if the current char is repeated,
+// and the illegal token otherwise because the char has no meaning by itself.
+func (l *Lexer) doubled(two token.TokenType) token.Token {
+	if l.peekChar() != l.ch {
+		return newToken(token.ILLEGAL, l.ch)
+	}
+
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: two, Literal: string(ch) + string(l.ch)}
+}
+
 // readIdentifier reads in an identifier and advances lexer's position
 // until it encounters a non-letter char.
 func (l *Lexer) readIdentifier() string {
@@ -137,13 +285,14 @@ func (l *Lexer) readIdentifier() string {
 // isLetter is helper function to check whether the given argument is a letter or not.
 // Changes to this function will heavily impact on the language itself.
 // Currently, snake case representation is supported for the identifier and keyword,
-// but both '!' and '?' are not recognized as identifier.
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// but both '!' and '?' are not recognized as identifier. The letters of any language are accepted.
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
 // nextToken initializes the token passing through.
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
@@ -155,64 +304,252 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-// readNumber reads in an identifier and advances lexer's positionuntil it encounters a non-numeric char.
-// readNumber is almost same implementation as readIdentifier except for its usage of isDigit instead of isLetter.
-// But, for simplicity and ease of understanding, these two function are not generalize
-// by passing in the char-idenrifying function as arguments.
-func (l *Lexer) readNumber() string {
-	position := l.position
-	for isDigit(l.ch) {
+// readNumber reads in the digits accepted by isValid and advances lexer's position
+// until it encounters a char which is neither such a digit nor '_'.
+// It returns the number of the digits read, and false if '_' doesn't separate two digits.
+func (l *Lexer) readNumber(isValid func(rune) bool) (int, bool) {
+	count := 0
+	ok := true
+	for isValid(l.ch) || l.ch == '_' {
+		if l.ch == '_' && (count == 0 || !isValid(l.peekChar())) {
+			ok = false
+		}
+		if l.ch != '_' {
+			count++
+		}
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	return count, ok
+}
+
+// numberBases maps the prefix char following '0' to the name of the notation and its digits.
+var numberBases = map[rune]struct {
+	name    string
+	isValid func(rune) bool
+}{
+	'x': {"hexadecimal", isHexDigit},
+	'X': {"hexadecimal", isHexDigit},
+	'o': {"octal", isOctalDigit},
+	'O': {"octal", isOctalDigit},
+	'b': {"binary", isBinaryDigit},
+	'B': {"binary", isBinaryDigit},
 }
 
+// readNumericToken reads the integer in decimal, hexadecimal "0x1F", octal "0o17" or binary "0b1010",
+// or the decimal float such as "1.5", ".5", "1." and "1.5e-3". '_' can separate the digits like "1_000_000".
+// A leading zero doesn't make the integer octal. The malformed literal is returned as the error token
+// after reading up to the end of the word, so that lexing resumes after it.
 func (l *Lexer) readNumericToken() token.Token {
-	intPart := l.readNumber()
+	position := l.position
+	tok := token.Token{Type: token.INT}
+	var errMsg string
 
-	if l.ch != '.' {
-		return token.Token{
-			Type:    token.INT,
-			Literal: intPart,
+	fail := func(msg string) {
+		if errMsg == "" {
+			errMsg = msg
 		}
 	}
 
-	l.readChar()
-	fracPart := l.readNumber()
-	return token.Token{
-		Type:    token.FLOAT,
-		Literal: intPart + "." + fracPart,
+	if base, ok := numberBases[l.peekChar()]; ok && l.ch == '0' {
+		l.readChar()
+		l.readChar()
+		count, ok := l.readNumber(base.isValid)
+		if count == 0 {
+			fail(fmt.Sprintf("%s literal has no digits", base.name))
+		} else if !ok {
+			fail("'_' must separate successive digits")
+		}
+		if isDigit(l.ch) || isLetter(l.ch) {
+			fail(fmt.Sprintf("invalid digit %q in %s literal", l.ch, base.name))
+		}
+	} else {
+		if _, ok := l.readNumber(isDigit); !ok {
+			fail("'_' must separate successive digits")
+		}
+
+		if l.ch == '.' {
+			tok.Type = token.FLOAT
+			l.readChar()
+			if _, ok := l.readNumber(isDigit); !ok {
+				fail("'_' must separate successive digits")
+			}
+		}
+
+		if l.ch == 'e' || l.ch == 'E' {
+			tok.Type = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			count, ok := l.readNumber(isDigit)
+			if count == 0 {
+				fail("exponent has no digits")
+			} else if !ok {
+				fail("'_' must separate successive digits")
+			}
+		}
+
+		if isLetter(l.ch) {
+			fail(fmt.Sprintf("invalid char %q in numeric literal", l.ch))
+		}
+	}
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		fail(fmt.Sprintf("invalid char %q in numeric literal", l.ch))
+	}
+
+	for isLetter(l.ch) || isDigit(l.ch) || l.ch == '.' && isDigit(l.peekChar()) {
+		l.readChar()
+	}
+
+	if errMsg != "" {
+		return token.Token{Type: token.ERROR, Literal: errMsg}
 	}
+	tok.Literal = l.input[position:l.position]
+	return tok
 }
 
-// isDigit is helper function to check whether the given argument is integer or not.
-// This means that it doesn't support float, numbers in hex and octal notation in this stage.
-// TODO: Support float or other digit format.
-func isDigit(ch byte) bool {
+// isDigit is helper function to check whether the given argument is decimal digit or not.
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func isFloat(ch byte) bool {
-	return ch == '.'
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func isOctalDigit(ch rune) bool {
+	return '0' <= ch && ch <= '7'
+}
+
+func isBinaryDigit(ch rune) bool {
+	return ch == '0' || ch == '1'
 }
 
 // peekChar only looks ahead in the input and grasps what to be reuturned after readChar call in advance.
 // It doesn't move around in it.
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
-// readString reads character by calling readChar until encountering either a closing double quote or end of the input.
-func (l *Lexer) readString() string {
+// readString reads the string enclosed in double quotes and decodes its escape sequences.
+// The string must be closed in the same line. The invalid escape sequence is reported
+// after reading up to the closing double quote, so that lexing resumes after the string.
+//
+// When it encounters "${", it returns the part read so far as the token of the type part
+// and leaves the interpolated expression to be lexed as usual tokens, until the "}" closing it
+// resumes reading the string. The part ending with the closing double quote is of the type last.
+func (l *Lexer) readString(part, last token.TokenType) token.Token {
+	var out strings.Builder
+	var errMsg string
+
+	for {
+		l.readChar()
+
+		switch l.ch {
+		case '"':
+			if errMsg != "" {
+				return token.Token{Type: token.ERROR, Literal: errMsg}
+			}
+			return token.Token{Type: last, Literal: out.String()}
+
+		case '$':
+			if l.peekChar() != '{' {
+				out.WriteRune(l.ch)
+				continue
+			}
+
+			l.readChar()
+			l.interpolations = append(l.interpolations, 0)
+			if errMsg != "" {
+				return token.Token{Type: token.ERROR, Literal: errMsg}
+			}
+			return token.Token{Type: part, Literal: out.String()}
+
+		case '\n', 0:
+			return token.Token{Type: token.ERROR, Literal: "unterminated string"}
+
+		case '\\':
+			// The backslash at the end of the line leaves the string unterminated.
+			if l.peekChar() == '\n' || l.peekChar() == 0 {
+				continue
+			}
+
+			l.readChar()
+			s, msg := l.readEscape()
+			if msg != "" && errMsg == "" {
+				errMsg = msg
+			}
+			out.WriteString(s)
+
+		default:
+			// The bytes are copied as they are even if they are not valid UTF-8.
+			out.WriteString(l.input[l.position:l.readPosition])
+		}
+	}
+}
+
+// escapes maps the chars following a backslash to the chars they represent.
+var escapes = map[rune]string{
+	'n':  "\n",
+	't':  "\t",
+	'r':  "\r",
+	'\\': "\\",
+	'"':  "\"",
+	'$':  "$",
+}
+
+// readEscape decodes the escape sequence whose backslash precedes the current char.
+// It returns the message describing the problem if the sequence is invalid.
+func (l *Lexer) readEscape() (string, string) {
+	if s, ok := escapes[l.ch]; ok {
+		return s, ""
+	}
+
+	if l.ch != 'u' {
+		return "", fmt.Sprintf("invalid escape sequence \\%c", l.ch)
+	}
+
+	// \u{XXXX} consists of 1 to 6 hexadecimal digits of the code point.
+	if l.peekChar() != '{' {
+		return "", "invalid unicode escape sequence, want \\u{XXXX}"
+	}
+	l.readChar()
+
+	start := l.readPosition
+	for isHexDigit(l.peekChar()) {
+		l.readChar()
+	}
+	digits := l.input[start:l.readPosition]
+
+	if l.peekChar() != '}' || len(digits) == 0 || len(digits) > 6 {
+		return "", "invalid unicode escape sequence, want \\u{XXXX}"
+	}
+	l.readChar()
+
+	code, _ := strconv.ParseUint(digits, 16, 32)
+	if !utf8.ValidRune(rune(code)) {
+		return "", fmt.Sprintf("invalid unicode code point U+%04X", code)
+	}
+	return string(rune(code)), ""
+}
+
+// readRawString reads the string enclosed in backquotes as it is without decoding escape sequences.
+// It may span multiple lines.
+func (l *Lexer) readRawString() token.Token {
 	position := l.position + 1
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+
+		switch l.ch {
+		case '`':
+			return token.Token{Type: token.STRING, Literal: l.input[position:l.position]}
+		case 0:
+			return token.Token{Type: token.ERROR, Literal: "unterminated raw string"}
 		}
 	}
-	return l.input[position:l.position]
 }
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/toversus/monkey/token"
@@ -17,7 +18,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -38,6 +39,10 @@ macro(x, y) { x + y; };
 let pi = 3.14;
 [1, 3.14];
 3.14 == 3.14;
+x += 1; x -= 1; x *= 2; x /= 2;
+match (x) { _ => 1 }
+[a, ...b]
+a <= b >= c % 2 && d || e & |
 `
 
 	tests := []struct {
@@ -158,6 +163,50 @@ let pi = 3.14;
 		{token.EQ, "=="},
 		{token.FLOAT, "3.14"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.MATCH, "match"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "_"},
+		{token.ARROW, "=>"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.IDENT, "a"},
+		{token.COMMA, ","},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "b"},
+		{token.RBRACKET, "]"},
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.INT, "2"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
 		{token.EOF, ""},
 	}
 
@@ -178,3 +227,291 @@ let pi = 3.14;
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  "foo" == x;`
+
+	tests := []struct {
+		wantType   token.TokenType
+		wantPos    token.Position
+		wantEndCol int
+	}{
+		{token.LET, token.Position{Filename: "main.mk", Offset: 0, Line: 1, Column: 1}, 4},
+		{token.IDENT, token.Position{Filename: "main.mk", Offset: 4, Line: 1, Column: 5}, 6},
+		{token.ASSIGN, token.Position{Filename: "main.mk", Offset: 6, Line: 1, Column: 7}, 8},
+		{token.INT, token.Position{Filename: "main.mk", Offset: 8, Line: 1, Column: 9}, 10},
+		{token.SEMICOLON, token.Position{Filename: "main.mk", Offset: 9, Line: 1, Column: 10}, 11},
+		{token.STRING, token.Position{Filename: "main.mk", Offset: 13, Line: 2, Column: 3}, 8},
+		{token.EQ, token.Position{Filename: "main.mk", Offset: 19, Line: 2, Column: 9}, 11},
+		{token.IDENT, token.Position{Filename: "main.mk", Offset: 22, Line: 2, Column: 12}, 13},
+		{token.SEMICOLON, token.Position{Filename: "main.mk", Offset: 23, Line: 2, Column: 13}, 14},
+	}
+
+	l := NewWithFilename("main.mk", input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != test.wantType {
+			t.Fatalf("tests[%d] - wrong tokentype. want=%q, got=%q",
+				i, test.wantType, tok.Type)
+		}
+
+		if tok.Pos != test.wantPos {
+			t.Fatalf("tests[%d] - wrong position. want=%+v, got=%+v",
+				i, test.wantPos, tok.Pos)
+		}
+
+		if tok.End.Column != test.wantEndCol {
+			t.Fatalf("tests[%d] - wrong end column. want=%d, got=%d",
+				i, test.wantEndCol, tok.End.Column)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantType    token.TokenType
+		wantLiteral string
+	}{
+		{`"a\nb\tc\r"`, token.STRING, "a\nb\tc\r"},
+		{`"say \"hi\" \\ bye"`, token.STRING, `say "hi" \ bye`},
+		{`"\u{41}\u{3b1}\u{1F600}"`, token.STRING, "Aα😀"},
+		{`"héllo"`, token.STRING, "héllo"},
+		{"`raw \\n \"string\"`", token.STRING, `raw \n "string"`},
+		{"`first\nsecond`", token.STRING, "first\nsecond"},
+		{`"abc`, token.ERROR, "unterminated string"},
+		{"\"abc\ndef\"", token.ERROR, "unterminated string"},
+		{`"abc\`, token.ERROR, "unterminated string"},
+		{"`abc", token.ERROR, "unterminated raw string"},
+		{`"a\qb"`, token.ERROR, `invalid escape sequence \q`},
+		{`"\u41"`, token.ERROR, `invalid unicode escape sequence, want \u{XXXX}`},
+		{`"\u{}"`, token.ERROR, `invalid unicode escape sequence, want \u{XXXX}`},
+		{`"\u{1234567}"`, token.ERROR, `invalid unicode escape sequence, want \u{XXXX}`},
+		{`"\u{D800}"`, token.ERROR, "invalid unicode code point U+D800"},
+	}
+
+	for _, test := range tests {
+		tok := New(test.input).NextToken()
+
+		if tok.Type != test.wantType {
+			t.Errorf("wrong tokentype for %q. want=%q, got=%q", test.input, test.wantType, tok.Type)
+		}
+		if tok.Literal != test.wantLiteral {
+			t.Errorf("wrong literal for %q. want=%q, got=%q", test.input, test.wantLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let 名前 = \"こんにちは\";\nπ + 名前"
+
+	tests := []struct {
+		wantType    token.TokenType
+		wantLiteral string
+		wantPos     string
+		wantOffset  int
+	}{
+		{token.LET, "let", "1:1", 0},
+		{token.IDENT, "名前", "1:5", 4},
+		{token.ASSIGN, "=", "1:8", 11},
+		{token.STRING, "こんにちは", "1:10", 13},
+		{token.SEMICOLON, ";", "1:17", 30},
+		{token.IDENT, "π", "2:1", 32},
+		{token.PLUS, "+", "2:3", 35},
+		{token.IDENT, "名前", "2:5", 37},
+		{token.EOF, "", "2:7", 43},
+	}
+
+	l := New(input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != test.wantType || tok.Literal != test.wantLiteral {
+			t.Errorf("tests[%d] - wrong token. want=%s %q, got=%s %q",
+				i, test.wantType, test.wantLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.String() != test.wantPos || tok.Pos.Offset != test.wantOffset {
+			t.Errorf("tests[%d] - wrong position. want=%s (offset %d), got=%s (offset %d)",
+				i, test.wantPos, test.wantOffset, tok.Pos, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantType    token.TokenType
+		wantLiteral string
+	}{
+		{"0x1F", token.INT, "0x1F"},
+		{"0o17", token.INT, "0o17"},
+		{"0b1010", token.INT, "0b1010"},
+		{"1_000_000", token.INT, "1_000_000"},
+		{"1.5e-3", token.FLOAT, "1.5e-3"},
+		{"1E+3", token.FLOAT, "1E+3"},
+		{"3e10", token.FLOAT, "3e10"},
+		{".5", token.FLOAT, ".5"},
+		{"1.", token.FLOAT, "1."},
+		{"0x", token.ERROR, "hexadecimal literal has no digits"},
+		{"0o8", token.ERROR, "octal literal has no digits"},
+		{"0o78", token.ERROR, "invalid digit '8' in octal literal"},
+		{"0b12", token.ERROR, "invalid digit '2' in binary literal"},
+		{"0xfg", token.ERROR, "invalid digit 'g' in hexadecimal literal"},
+		{"1__000", token.ERROR, "'_' must separate successive digits"},
+		{"1_", token.ERROR, "'_' must separate successive digits"},
+		{"0x_1", token.ERROR, "'_' must separate successive digits"},
+		{"1.5e", token.ERROR, "exponent has no digits"},
+		{"1e+", token.ERROR, "exponent has no digits"},
+		{"12abc", token.ERROR, "invalid char 'a' in numeric literal"},
+	}
+
+	for _, test := range tests {
+		l := New(test.input + " x")
+		tok := l.NextToken()
+
+		if tok.Type != test.wantType {
+			t.Errorf("wrong tokentype for %q. want=%q, got=%q", test.input, test.wantType, tok.Type)
+		}
+		if tok.Literal != test.wantLiteral {
+			t.Errorf("wrong literal for %q. want=%q, got=%q", test.input, test.wantLiteral, tok.Literal)
+		}
+		if tok.End.Offset != len(test.input) {
+			t.Errorf("wrong end of %q. got=%d", test.input, tok.End.Offset)
+		}
+		if next := l.NextToken(); next.Type != token.IDENT {
+			t.Errorf("lexing doesn't resume after %q. got=%s", test.input, next.Type)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// doc\r\nlet x = 10 / 2; /* a\nb */ /*c*/x // end"
+
+	tests := []struct {
+		wantType     token.TokenType
+		wantPos      string
+		wantComments []string
+	}{
+		{token.LET, "2:1", []string{"// doc"}},
+		{token.IDENT, "2:5", nil},
+		{token.ASSIGN, "2:7", nil},
+		{token.INT, "2:9", nil},
+		{token.SLASH, "2:12", nil},
+		{token.INT, "2:14", nil},
+		{token.SEMICOLON, "2:15", nil},
+		{token.IDENT, "3:11", []string{"/* a\nb */", "/*c*/"}},
+		{token.EOF, "3:19", []string{"// end"}},
+	}
+
+	for _, keep := range []bool{false, true} {
+		l := New(input)
+		if keep {
+			l.KeepComments()
+		}
+
+		for i, test := range tests {
+			tok := l.NextToken()
+
+			if tok.Type != test.wantType || tok.Pos.String() != test.wantPos {
+				t.Errorf("tests[%d] - wrong token. want=%s at %s, got=%s at %s",
+					i, test.wantType, test.wantPos, tok.Type, tok.Pos)
+			}
+
+			var comments []string
+			for _, c := range tok.Comments {
+				comments = append(comments, c.Text)
+			}
+			if !keep && len(comments) != 0 {
+				t.Errorf("tests[%d] - comments must be discarded. got=%q", i, comments)
+			}
+			if keep && strings.Join(comments, "|") != strings.Join(test.wantComments, "|") {
+				t.Errorf("tests[%d] - wrong comments. want=%q, got=%q", i, test.wantComments, comments)
+			}
+		}
+	}
+
+	l := New("/* a\nb */ x").KeepComments()
+	c := l.NextToken().Comments[0]
+	if c.Pos.String() != "1:1" || c.End.String() != "2:5" {
+		t.Errorf("wrong comment span. got=%s-%s", c.Pos, c.End)
+	}
+
+	tok := New("/* open\n").NextToken()
+	if tok.Type != token.ERROR || tok.Literal != "unterminated comment" || tok.Pos.String() != "1:1" {
+		t.Errorf("wrong token for unterminated comment. got=%s %q at %s", tok.Type, tok.Literal, tok.Pos)
+	}
+}
+
+func TestTokensAfterStrings(t *testing.T) {
+	input := "`a\nb` \"c\\q\" x"
+
+	tests := []struct {
+		wantType token.TokenType
+		wantPos  string
+	}{
+		{token.STRING, "1:1"},
+		{token.ERROR, "2:4"},
+		{token.IDENT, "2:10"},
+		{token.EOF, "2:11"},
+	}
+
+	l := New(input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != test.wantType || tok.Pos.String() != test.wantPos {
+			t.Errorf("tests[%d] - wrong token. want=%s at %s, got=%s at %s",
+				i, test.wantType, test.wantPos, tok.Type, tok.Pos)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	input := `"a ${x} b ${ {"k": "v"}["k"] }" "${"in ${y}"}!" "$x \${y}"`
+
+	tests := []struct {
+		wantType    token.TokenType
+		wantLiteral string
+	}{
+		{token.STRING_HEAD, "a "},
+		{token.IDENT, "x"},
+		{token.STRING_MIDDLE, " b "},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.STRING, "v"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.STRING_TAIL, ""},
+		{token.STRING_HEAD, ""},
+		{token.STRING_HEAD, "in "},
+		{token.IDENT, "y"},
+		{token.STRING_TAIL, ""},
+		{token.STRING_TAIL, "!"},
+		{token.STRING, "$x ${y}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != test.wantType {
+			t.Fatalf("tests[%d] - wrong tokentype. want=%q, got=%q",
+				i, test.wantType, tok.Type)
+		}
+
+		if tok.Literal != test.wantLiteral {
+			t.Fatalf("tests[%d] - wrong literal. want=%q, got=%q",
+				i, test.wantLiteral, tok.Literal)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/user"
@@ -8,7 +9,54 @@ import (
 	"github.com/toversus/monkey/repl"
 )
 
+const usage = `Usage:
+  monkey [flags]                        start the interactive REPL
+  monkey [flags] run <file> [args...]   run the Monkey script or the bytecode built from it
+  monkey [flags] build [-o file] <file> compile the Monkey script into bytecode
+  monkey [flags] disasm <file>          print the bytecode compiled from the script
+
+Flags:
+`
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		startRepl()
+		return
+	}
+
+	switch args[0] {
+	case "run":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(run(args[1], args[2:]))
+
+	case "build":
+		os.Exit(build(args[1:]))
+
+	case "disasm":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(disasm(args[1]))
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func startRepl() {
 	user, err := user.Current()
 	if err != nil {
 		fmt.Fprint(os.Stderr, err)
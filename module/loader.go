@@ -0,0 +1,118 @@
+// Package module finds the modules imported by Monkey programs and reads them from the files.
+package module
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/toversus/monkey/ast"
+	"github.com/toversus/monkey/diagnostic"
+	"github.com/toversus/monkey/lexer"
+	"github.com/toversus/monkey/parser"
+)
+
+// Extension is the extension of the Monkey source files, which can be omitted from the imported names.
+const Extension = ".mk"
+
+// PathEnv is the environment variable listing the directories of the default search path,
+// separated in the same way as PATH.
+const PathEnv = "MONKEYPATH"
+
+// Loader implements object.Importer by reading the modules from the files.
+type Loader struct {
+	// SearchPath is the list of the directories searched for the module
+	// which is not found relative to the file importing it.
+	SearchPath []string
+
+	// Expand transforms the program of the module after parsing, such as the macro expansion.
+	// The program is used as it is parsed if Expand is nil.
+	Expand func(*ast.Program) (*ast.Program, []diagnostic.Diagnostic)
+
+	// Sources records the source code of the modules loaded so far by their paths if it is not nil,
+	// e.g. for the disassembler to show their lines.
+	Sources map[string]string
+}
+
+// NewLoader initializes the Loader searching the directories for the modules.
+func NewLoader(searchPath ...string) *Loader {
+	return &Loader{SearchPath: searchPath}
+}
+
+// DefaultSearchPath returns the directories listed in the environment variable MONKEYPATH.
+func DefaultSearchPath() []string {
+	return filepath.SplitList(os.Getenv(PathEnv))
+}
+
+// Resolve returns the absolute path of the module imported by the name from the file importer.
+// The name is a slash-separated path, which is relative to the directory of the importer
+// or the current directory if the importer is unknown. The name not starting with "./" or "../"
+// is also searched in the search path. The extension of the file can be omitted.
+func (l *Loader) Resolve(importer, name string) (string, error) {
+	file := filepath.FromSlash(name)
+	if filepath.Ext(file) == "" {
+		file += Extension
+	}
+
+	var candidates []string
+	if filepath.IsAbs(file) {
+		candidates = []string{file}
+	} else {
+		candidates = []string{filepath.Join(filepath.Dir(importer), file)}
+		if !strings.HasPrefix(name, "./") && !strings.HasPrefix(name, "../") {
+			for _, dir := range l.SearchPath {
+				candidates = append(candidates, filepath.Join(dir, file))
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("module %q not found", name)
+}
+
+// Load reads and parses the module at the path, which is recorded in the positions of the nodes.
+func (l *Loader) Load(path string) (*ast.Program, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Sources != nil {
+		l.Sources[path] = string(src)
+	}
+
+	p := parser.New(lexer.NewWithFilename(path, string(src)))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		return nil, &Error{Diagnostics: p.Diagnostics()}
+	}
+
+	if l.Expand != nil {
+		expanded, diagnostics := l.Expand(program)
+		if len(diagnostics) != 0 {
+			return nil, &Error{Diagnostics: diagnostics}
+		}
+		program = expanded
+	}
+
+	return program, nil
+}
+
+// Error is returned by Load when the module has the diagnostics of errors.
+type Error struct {
+	Diagnostics []diagnostic.Diagnostic
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
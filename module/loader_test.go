@@ -0,0 +1,110 @@
+package module
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app/main.mk":     "",
+		"app/util.mk":     "",
+		"app/lib/sub.mk":  "",
+		"lib/util.mk":     "",
+		"lib/shared.mk":   "",
+		"lib/data.monkey": "",
+		"lib/lib/sub.mk":  "",
+		"lib/dir.mk/x.mk": "",
+	})
+
+	main := filepath.Join(dir, "app", "main.mk")
+	loader := NewLoader(filepath.Join(dir, "lib"))
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"util", "app/util.mk"},
+		{"util.mk", "app/util.mk"},
+		{"shared", "lib/shared.mk"},
+		{"lib/sub", "app/lib/sub.mk"},
+		{"data.monkey", "lib/data.monkey"},
+		{"./util", "app/util.mk"},
+		{"../lib/shared", "lib/shared.mk"},
+		{filepath.ToSlash(filepath.Join(dir, "lib", "util")), "lib/util.mk"},
+	}
+
+	for _, test := range tests {
+		got, err := loader.Resolve(main, test.name)
+		if err != nil {
+			t.Errorf("Resolve(%q) failed: %s", test.name, err)
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(test.want)); got != want {
+			t.Errorf("Resolve(%q) wrong. want=%s, got=%s", test.name, want, got)
+		}
+	}
+
+	for _, name := range []string{"./shared", "missing", "dir.mk"} {
+		_, err := loader.Resolve(main, name)
+		if err == nil {
+			t.Errorf("Resolve(%q) expected an error but got none", name)
+			continue
+		}
+		if want := `module "` + name + `" not found`; err.Error() != want {
+			t.Errorf("wrong error for %q. want=%q, got=%q", name, want, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"good.mk": "let x = 1;",
+		"bad.mk":  "let x = 1;\nlet = 2;",
+	})
+
+	loader := NewLoader()
+
+	program, err := loader.Load(filepath.Join(dir, "good.mk"))
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	if program.String() != "let x = 1;" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+	if pos := program.Statements[0].Pos(); pos.Filename != filepath.Join(dir, "good.mk") {
+		t.Errorf("wrong filename in the position. got=%q", pos.Filename)
+	}
+
+	bad := filepath.Join(dir, "bad.mk")
+	_, err = loader.Load(bad)
+	loadErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error is not *Error. got=%T (%v)", err, err)
+	}
+	if len(loadErr.Diagnostics) != 1 || loadErr.Diagnostics[0].Pos.String() != bad+":2:5" {
+		t.Errorf("wrong diagnostics. got=%v", loadErr)
+	}
+
+	if _, err := loader.Load(filepath.Join(dir, "missing.mk")); err == nil {
+		t.Errorf("Load of the missing file expected an error but got none")
+	}
+}
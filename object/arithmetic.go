@@ -0,0 +1,61 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// OverflowPolicy decides what the integer arithmetic does when the result doesn't fit in int64.
+// Both the evaluator and the VM follow the policy chosen for each of their instances.
+type OverflowPolicy int
+
+const (
+	// OverflowError makes the overflow a runtime error. It is the default policy.
+	OverflowError OverflowPolicy = iota
+	// OverflowWrap wraps the result around in two's complement as Go does.
+	OverflowWrap
+)
+
+var (
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrIntegerOverflow = errors.New("integer overflow")
+)
+
+// IntegerArithmetic computes the integer operation "+", "-", "*", "/" or "%".
+// The division by zero is always ErrDivisionByZero, while the overflow is ErrIntegerOverflow
+// only under OverflowError.
+func IntegerArithmetic(operator string, left, right int64, policy OverflowPolicy) (int64, error) {
+	var result int64
+	var overflow bool
+
+	switch operator {
+	case "+":
+		result = left + right
+		overflow = (left^result)&(right^result) < 0
+	case "-":
+		result = left - right
+		overflow = (left^right)&(left^result) < 0
+	case "*":
+		result = left * right
+		overflow = left != 0 && (result/left != right || left == -1 && right == math.MinInt64)
+	case "/":
+		if right == 0 {
+			return 0, ErrDivisionByZero
+		}
+		result = left / right
+		overflow = left == math.MinInt64 && right == -1
+	case "%":
+		if right == 0 {
+			return 0, ErrDivisionByZero
+		}
+		result = left % right
+	default:
+		return 0, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+
+	if overflow && policy == OverflowError {
+		return 0, ErrIntegerOverflow
+	}
+	return result, nil
+}
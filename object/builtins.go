@@ -1,6 +1,9 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"unicode/utf8"
+)
 
 var Builtins = []struct {
 	Name    string
@@ -17,7 +20,7 @@ var Builtins = []struct {
 			case *Array:
 				return &Integer{Value: int64(len(arg.Elements))}
 			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 			default:
 				return newError("argument to 'len' not supported, got %s",
 					args[0].Type())
@@ -125,6 +128,81 @@ var Builtins = []struct {
 		},
 		},
 	},
+	{
+		"slice",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3",
+					len(args))
+			}
+
+			var length int
+			switch arg := args[0].(type) {
+			case *Array:
+				length = len(arg.Elements)
+			case *String:
+				length = utf8.RuneCountInString(arg.Value)
+			default:
+				return newError("argument to 'slice' must be ARRAY or STRING, got %s",
+					args[0].Type())
+			}
+
+			bounds := []int{0, length}
+			for i, arg := range args[1:] {
+				integer, ok := arg.(*Integer)
+				if !ok {
+					return newError("bounds of 'slice' must be INTEGER, got %s",
+						arg.Type())
+				}
+				// The bounds out of the range are clamped instead of being an error.
+				switch {
+				case integer.Value < 0:
+					bounds[i] = 0
+				case integer.Value > int64(length):
+					bounds[i] = length
+				default:
+					bounds[i] = int(integer.Value)
+				}
+			}
+			start, end := bounds[0], bounds[1]
+			if end < start {
+				end = start
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				newElements := make([]Object, end-start)
+				copy(newElements, arg.Elements[start:end])
+				return &Array{Elements: newElements}
+			default:
+				runes := []rune(arg.(*String).Value)
+				return &String{Value: string(runes[start:end])}
+			}
+		},
+		},
+	},
+	{
+		"bytes",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != STRING_OBJ {
+				return newError("argument to 'bytes' must be STRING, got %s",
+					args[0].Type())
+			}
+
+			str := args[0].(*String).Value
+			elements := make([]Object, len(str))
+			for i := 0; i < len(str); i++ {
+				elements[i] = &Integer{Value: int64(str[i])}
+			}
+
+			return &Array{Elements: elements}
+		},
+		},
+	},
 }
 
 func newError(format string, a ...interface{}) *Error {
@@ -1,5 +1,10 @@
 package object
 
+import (
+	"github.com/toversus/monkey/ast"
+	"github.com/toversus/monkey/token"
+)
+
 // Environment is used to keep track of value by associating them with a name.
 // It looks up in the outer scope if something is not found in the inner scope.
 // The outer scope encloses the inner scope, otherwise the inner scope extends the outer one.
@@ -8,6 +13,49 @@ type Environment struct {
 
 	// outer represents enclosing environment.
 	outer *Environment
+
+	// call is the function call which created the environment.
+	// It is nil for the environment of the main program and the nested scopes,
+	// while the environment of a module records the import which evaluates it.
+	call *CallFrame
+
+	// overflow is the policy of the integer arithmetic, which only the outermost environment holds.
+	overflow OverflowPolicy
+
+	// imports is the state of the imports, which only the outermost environment holds.
+	// It is shared with the environments of the modules imported by the program.
+	imports *Imports
+}
+
+// Importer finds and reads the modules imported by the program.
+type Importer interface {
+	// Resolve returns the path of the module imported by the name from the file importer,
+	// which identifies the module.
+	Resolve(importer, name string) (string, error)
+	// Load returns the program of the module at the path, ready to be evaluated or compiled.
+	Load(path string) (*ast.Program, error)
+}
+
+// Imports holds the modules imported by the program and the modules imported by them,
+// so that each module is evaluated only once.
+type Imports struct {
+	Importer Importer
+
+	// Modules are the exports of the modules evaluated so far by their paths.
+	Modules map[string]*Hash
+	// Loading are the paths of the modules being evaluated, from the outermost one,
+	// which are checked to detect the import cycle.
+	Loading []string
+}
+
+// CallFrame records a function call of the tree-walking evaluator for the stack traces of errors.
+type CallFrame struct {
+	// Function is the name of the called function.
+	Function string
+	// CallSite is the position of the call expression in the caller.
+	CallSite token.Position
+	// Caller is the call frame of the caller, which is nil if it is called from the main program.
+	Caller *CallFrame
 }
 
 // NewEncloseEnvironment makes enclosed environment.
@@ -17,6 +65,14 @@ func NewEncloseEnvironment(outer *Environment) *Environment {
 	return env
 }
 
+// NewFunctionEnvironment makes the environment for the body of a function called by the given call frame.
+// The outer environment is where the function was defined, not where it was called from.
+func NewFunctionEnvironment(outer *Environment, call *CallFrame) *Environment {
+	env := NewEncloseEnvironment(outer)
+	env.call = call
+	return env
+}
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
 	return &Environment{store: s, outer: nil}
@@ -31,7 +87,89 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Assign updates the binding in the innermost environment defining the name.
+// Unlike Set, it never creates a new binding and returns false if the name is not defined.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
+
 func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// CallFrame returns the function call being evaluated in the environment,
+// which is nil for the main program.
+func (e *Environment) CallFrame() *CallFrame {
+	for env := e; env != nil; env = env.outer {
+		if env.call != nil {
+			return env.call
+		}
+	}
+	return nil
+}
+
+// SetOverflowPolicy chooses the overflow policy of the integer arithmetic.
+// It is held by the outermost environment, so every scope enclosed by it follows the same policy.
+func (e *Environment) SetOverflowPolicy(policy OverflowPolicy) {
+	e.outermost().overflow = policy
+}
+
+// OverflowPolicy returns the overflow policy of the integer arithmetic evaluated in the environment.
+func (e *Environment) OverflowPolicy() OverflowPolicy {
+	return e.outermost().overflow
+}
+
+// SetImporter enables the imports in the program evaluated in the environment.
+func (e *Environment) SetImporter(importer Importer) {
+	e.outermost().imports = &Imports{Importer: importer, Modules: map[string]*Hash{}}
+}
+
+// Imports returns the state of the imports, which is nil if the imports are not enabled.
+func (e *Environment) Imports() *Imports {
+	return e.outermost().imports
+}
+
+// NewModuleEnvironment makes the outermost environment for the module imported from the environment.
+// The module has its own namespace, but shares the overflow policy and the imports with the importer.
+// The import is recorded as the call frame of the module for the stack traces.
+func (e *Environment) NewModuleEnvironment(call *CallFrame) *Environment {
+	env := NewEnvironment()
+	env.call = call
+	env.overflow = e.OverflowPolicy()
+	env.imports = e.Imports()
+	return env
+}
+
+func (e *Environment) outermost() *Environment {
+	env := e
+	for env.outer != nil {
+		env = env.outer
+	}
+	return env
+}
+
+// StackTrace builds the call frames from the main program to the function being evaluated,
+// where the innermost frame is at the given position.
+func (e *Environment) StackTrace(pos token.Position) []TraceFrame {
+	calls := []*CallFrame{}
+	for call := e.CallFrame(); call != nil; call = call.Caller {
+		calls = append(calls, call)
+	}
+
+	trace := make([]TraceFrame, 0, len(calls)+1)
+	function := "<main>"
+	for i := len(calls) - 1; i >= 0; i-- {
+		trace = append(trace, TraceFrame{Function: function, Pos: calls[i].CallSite})
+		function = calls[i].Function
+	}
+	trace = append(trace, TraceFrame{Function: function, Pos: pos})
+
+	return trace
+}
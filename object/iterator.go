@@ -0,0 +1,80 @@
+package object
+
+import "sort"
+
+// Iterator walks through the elements of the iterable object in a for loop.
+// Arrays yield their elements, strings their characters and hashes their keys in sorted order.
+// The elements are taken when the iterator is created, so changing the iterable in the body
+// of the loop doesn't affect the iteration.
+type Iterator struct {
+	elements []Object
+	next     int
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "iterator" }
+
+// NewIterator returns false if the object is not iterable.
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		elements := make([]Object, len(obj.Elements))
+		copy(elements, obj.Elements)
+		return &Iterator{elements: elements}, true
+
+	case *String:
+		elements := []Object{}
+		for _, ch := range obj.Value {
+			elements = append(elements, &String{Value: string(ch)})
+		}
+		return &Iterator{elements: elements}, true
+
+	case *Hash:
+		return &Iterator{elements: obj.sortedKeys()}, true
+
+	default:
+		return nil, false
+	}
+}
+
+// Next returns the next element, or false when the elements are exhausted.
+func (it *Iterator) Next() (Object, bool) {
+	if it.next >= len(it.elements) {
+		return nil, false
+	}
+
+	el := it.elements[it.next]
+	it.next++
+	return el, true
+}
+
+// sortedKeys orders the keys by their types first and then by their values,
+// because the order of the keys in the map is random.
+func (h *Hash) sortedKeys() []Object {
+	keys := make([]Object, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		keys = append(keys, pair.Key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+
+		switch a := a.(type) {
+		case *Integer:
+			return a.Value < b.(*Integer).Value
+		case *Float:
+			return a.Value < b.(*Float).Value
+		case *Boolean:
+			return !a.Value && b.(*Boolean).Value
+		case *String:
+			return a.Value < b.(*String).Value
+		default:
+			return a.Inspect() < b.Inspect()
+		}
+	})
+
+	return keys
+}
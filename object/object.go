@@ -11,6 +11,7 @@ import (
 	"github.com/toversus/monkey/code"
 
 	"github.com/toversus/monkey/ast"
+	"github.com/toversus/monkey/token"
 )
 
 type ObjectType string
@@ -31,6 +32,11 @@ const (
 	MACRO_OBJ             = "MACRO"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
+	ITERATOR_OBJ          = "ITERATOR"
+	CELL_OBJ              = "CELL"
+	TAIL_CALL_OBJ         = "TAIL_CALL"
 )
 
 // Object is implemented as interface because every value needs a diffrent internal representation
@@ -69,19 +75,77 @@ func (n *Null) Inspect() string  { return "null" }
 
 type ReturnValue struct {
 	Value Object
+
+	// Pos is the position of the return statement, which is reported when a finally block returns.
+	Pos token.Position
 }
 
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
-// Error object keeps simplicity because it doesn't implement stack trace.
-// Stack trace requires more fields such as line and column numbers, which are generally extracted from the lexer.
+// Cell holds a variable captured by closures in the VM. The closures and the function defining
+// the variable share the cell, so that assignments made by any of them are seen by the others.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return "cell" }
+
+// Break and Continue are the signals of the evaluator to leave the loop or go on to its next iteration.
+// They bubble up through the block statements like ReturnValue until the innermost loop catches them.
+// Pos is the position of the statement, which is reported when no loop catches the signal.
+type Break struct {
+	Pos token.Position
+}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct {
+	Pos token.Position
+}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// TailCall is the call in tail position which the evaluator returns from the function body
+// instead of applying it, so that the caller applies it in a loop without growing the stack.
+type TailCall struct {
+	Function  *Function
+	Arguments []Object
+
+	// CallSite is the position of the call, which is recorded for the stack traces
+	// when the call is applied without returning from the function, e.g. in the try block.
+	CallSite token.Position
+}
+
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+func (tc *TailCall) Inspect() string  { return "tail call" }
+
+// Error object carries the position of the node which raised the error, extracted from the tokens of the lexer,
+// and the call frames which were active at that time.
 type Error struct {
 	Message string
+	Pos     token.Position
+	Trace   []TraceFrame
+
+	// Value is the value thrown by the throw statement, which is nil for the errors raised by the evaluator.
+	Value Object
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// Traceback formats the error with the call frames, the most recent call last.
+func (e *Error) Traceback() string {
+	return FormatTraceback(e.Trace, "Error: "+e.Message)
+}
 
 // Function is used for evaluating body of function with its parameter.
 // It has environment field because functions in Monkey carry their own environment,
@@ -90,16 +154,21 @@ type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+
+	// Defaults and Rest are the default values of the parameters and the rest parameter
+	// like ast.FunctionLiteral has.
+	Defaults []ast.Expression
+	Rest     *ast.Identifier
+
+	// Name is the name the function is bound to, which is empty for anonymous functions.
+	Name string
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
-	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
-	}
+	params := ast.ParameterStrings(f.Parameters, f.Defaults, f.Rest)
 
 	out.WriteString("fn")
 	out.WriteString("(")
@@ -185,6 +254,27 @@ func (s *String) HashKey() HashKey {
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
 
+// Equal reports whether the objects are the integers, floats, strings or booleans of the same value.
+// The literal patterns of the match expression are compared with it, so an integer never matches a float.
+func Equal(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Float:
+		b, ok := b.(*Float)
+		return ok && a.Value == b.Value
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	default:
+		return false
+	}
+}
+
 type HashPair struct {
 	Key   Object
 	Value Object
@@ -201,7 +291,7 @@ func (h *Hash) Inspect() string {
 	pairs := []string{}
 	for _, pair := range h.Pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key, pair.Value.Inspect()))
+			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
 	out.WriteString("{")
@@ -249,6 +339,53 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+
+	// DefaultEntries are the offsets where the call starts by the number of the missing arguments:
+	// the instructions initializing each parameter with the default value, which are the last ones
+	// of the parameters, followed by the start of the body for the call with all of the arguments.
+	// It is nil if no parameter has the default value.
+	DefaultEntries []int
+	// Variadic reports whether the function has the rest parameter, the local following the parameters,
+	// which is bound to the array of the arguments beyond NumParameters.
+	Variadic bool
+
+	// Name is the name the function is bound to, which is empty for anonymous functions.
+	Name string
+	// Lines maps the instructions back to the source code for runtime errors.
+	Lines code.LineTable
+	// Handlers is the exception table of the try expressions in the function.
+	Handlers code.HandlerTable
+	// LocalNames and FreeNames are the names of the local and free variables by their indexes,
+	// which are used by the disassembler.
+	LocalNames []string
+	FreeNames  []string
+}
+
+// NumRequired returns the number of the parameters without default values.
+func (cf *CompiledFunction) NumRequired() int {
+	if len(cf.DefaultEntries) == 0 {
+		return cf.NumParameters
+	}
+	return cf.NumParameters - len(cf.DefaultEntries) + 1
+}
+
+// AcceptsArguments reports whether the function can be called with the number of arguments.
+func (cf *CompiledFunction) AcceptsArguments(n int) bool {
+	return n >= cf.NumRequired() && (n <= cf.NumParameters || cf.Variadic)
+}
+
+// ArityMessage formats the error of the call with the wrong number of arguments to the function
+// which takes the parameters, of which the required ones have no default values,
+// and any number of arguments more if it is variadic.
+func ArityMessage(required, params int, variadic bool, got int) string {
+	want := strconv.Itoa(required)
+	switch {
+	case variadic:
+		want = "at least " + want
+	case params > required:
+		want = fmt.Sprintf("%d to %d", required, params)
+	}
+	return fmt.Sprintf("wrong number of arguments: want=%s, got=%d", want, got)
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -258,7 +395,7 @@ func (cf *CompiledFunction) Inspect() string {
 
 type Closure struct {
 	Fn   *CompiledFunction
-	Free []Object // equivalent to the Env field in *object.Function
+	Free []*Cell // equivalent to the Env field in *object.Function
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
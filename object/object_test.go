@@ -1,6 +1,57 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
+
+func TestIntegerArithmetic(t *testing.T) {
+	const max, min = math.MaxInt64, math.MinInt64
+
+	tests := []struct {
+		operator    string
+		left, right int64
+		want        int64
+		wantErr     error
+	}{
+		{"+", max, 1, min, ErrIntegerOverflow},
+		{"+", min, -1, max, ErrIntegerOverflow},
+		{"+", max, min, -1, nil},
+		{"-", min, 1, max, ErrIntegerOverflow},
+		{"-", 0, min, min, ErrIntegerOverflow},
+		{"-", -1, max, min, nil},
+		{"*", max / 2, 3, -4611686018427387907, ErrIntegerOverflow},
+		{"*", -1, min, min, ErrIntegerOverflow},
+		{"*", min, -1, min, ErrIntegerOverflow},
+		{"*", min, 1, min, nil},
+		{"/", min, -1, min, ErrIntegerOverflow},
+		{"/", 7, -2, -3, nil},
+		{"%", min, -1, 0, nil},
+		{"/", 1, 0, 0, ErrDivisionByZero},
+		{"%", 1, 0, 0, ErrDivisionByZero},
+	}
+
+	for _, test := range tests {
+		got, err := IntegerArithmetic(test.operator, test.left, test.right, OverflowError)
+		if err != test.wantErr {
+			t.Errorf("wrong error for %d %s %d. want=%v, got=%v",
+				test.left, test.operator, test.right, test.wantErr, err)
+		}
+		if err == nil && got != test.want {
+			t.Errorf("wrong result for %d %s %d. want=%d, got=%d",
+				test.left, test.operator, test.right, test.want, got)
+		}
+
+		if test.wantErr == ErrDivisionByZero {
+			continue
+		}
+		got, err = IntegerArithmetic(test.operator, test.left, test.right, OverflowWrap)
+		if err != nil || got != test.want {
+			t.Errorf("wrong wrapped result for %d %s %d. want=%d, got=%d (%v)",
+				test.left, test.operator, test.right, test.want, got, err)
+		}
+	}
+}
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
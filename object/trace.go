@@ -0,0 +1,65 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/toversus/monkey/token"
+)
+
+// TraceFrame is a single call frame which was active when an error occurred.
+type TraceFrame struct {
+	// Function is the name of the function, "<main>" for the main program
+	// and "<anonymous>" for the function which is not bound to a name.
+	Function string
+	// Pos is the position being executed in the function.
+	Pos token.Position
+}
+
+// FormatTraceback formats the frames from the outermost to the innermost one like Python does,
+// followed by the summary line of the error.
+func FormatTraceback(trace []TraceFrame, summary string) string {
+	var out bytes.Buffer
+
+	out.WriteString("Traceback (most recent call last):\n")
+	for _, f := range trace {
+		filename := f.Pos.Filename
+		if filename == "" {
+			filename = "<input>"
+		}
+
+		if f.Pos.IsValid() {
+			fmt.Fprintf(&out, "  File %q, line %d, column %d, in %s\n",
+				filename, f.Pos.Line, f.Pos.Column, f.Function)
+		} else {
+			fmt.Fprintf(&out, "  File %q, in %s\n", filename, f.Function)
+		}
+	}
+	out.WriteString(summary)
+
+	return out.String()
+}
+
+// String formats the frame as the function followed by the position.
+func (f TraceFrame) String() string {
+	return fmt.Sprintf("%s at %s", f.Function, f.Pos)
+}
+
+// NewErrorHash makes the value which the try expression catches for the runtime error:
+// the hash of the message and the trace formatted from the outermost frame.
+func NewErrorHash(message string, trace []TraceFrame) *Hash {
+	frames := make([]Object, len(trace))
+	for i, f := range trace {
+		frames[i] = &String{Value: f.String()}
+	}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	set := func(key string, value Object) {
+		k := &String{Value: key}
+		hash.Pairs[k.HashKey()] = HashPair{Key: k, Value: value}
+	}
+	set("message", &String{Value: message})
+	set("trace", &Array{Elements: frames})
+
+	return hash
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/toversus/monkey/ast"
@@ -47,6 +48,60 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestLetDestructuring(t *testing.T) {
+	tests := []struct {
+		input     string
+		want      string
+		wantNames []string
+	}{
+		{"let [a, b, ...rest] = arr;", "let [a, b, ...rest] = arr;", []string{"a", "b", "rest"}},
+		{"let {name, age: years} = person;", "let {name:name, age:years} = person;", []string{"name", "years"}},
+		{`let [_, {"k": [x], 1: y}] = f();`, "let [_, {k:[x], 1:y}] = f();", []string{"x", "y"}},
+		{"let [] = [];", "let [] = [];", []string{}},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.want {
+			t.Errorf("wrong program. want=%q, got=%q", test.want, program.String())
+		}
+
+		stmt := program.Statements[0].(*ast.LetStatement)
+		if stmt.Name != nil {
+			t.Errorf("stmt.Name is not nil. got=%s", stmt.Name)
+		}
+		names := []string{}
+		for _, name := range stmt.Names() {
+			names = append(names, name.Value)
+		}
+		if strings.Join(names, ",") != strings.Join(test.wantNames, ",") {
+			t.Errorf("wrong names. want=%v, got=%v", test.wantNames, names)
+		}
+	}
+
+	errorTests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a, 1] = x;", "1:9: literal pattern 1 is not allowed in let statement"},
+		{`let {k: "v"} = x;`, "1:9: literal pattern v is not allowed in let statement"},
+		{"let [a, ...b, c] = x;", "1:13: expected next token to be ], got , instead"},
+		{"let [a] x;", "1:9: expected next token to be =, got IDENT instead"},
+	}
+
+	for _, test := range errorTests {
+		p := New(lexer.New(test.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != test.want {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%v", test.input, test.want, p.Errors())
+		}
+	}
+}
+
 // The aim to create this helper function will become clear.
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "let" {
@@ -191,6 +246,80 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestNumericLiterals(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"0x1F", int64(31)},
+		{"0XfF", int64(255)},
+		{"0o17", int64(15)},
+		{"0b1010", int64(10)},
+		{"1_000_000", int64(1000000)},
+		{"017", int64(17)},
+		{"9223372036854775807", int64(9223372036854775807)},
+		{"0x7fff_ffff_ffff_ffff", int64(9223372036854775807)},
+		{"1.5e-3", 0.0015},
+		{"2E3", 2000.0},
+		{".5", 0.5},
+		{"1.", 1.0},
+		{"1_0.2_5", 10.25},
+	}
+
+	for _, test := range tests {
+		p := New(lexer.New(test.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+		switch want := test.want.(type) {
+		case int64:
+			lit, ok := exp.(*ast.IntegerLiteral)
+			if !ok || lit.Value != want {
+				t.Errorf("wrong integer literal for %q. want=%d, got=%#v", test.input, want, exp)
+			}
+		case float64:
+			lit, ok := exp.(*ast.FloatLiteral)
+			if !ok || lit.Value != want {
+				t.Errorf("wrong float literal for %q. want=%g, got=%#v", test.input, want, exp)
+			}
+		}
+	}
+}
+
+func TestNumericLiteralErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantCode string
+		wantMsg  string
+	}{
+		{"9223372036854775808", CodeInvalidInteger, "integer literal 9223372036854775808 is out of range, the maximum is 9223372036854775807"},
+		{"0xffff_ffff_ffff_ffff", CodeInvalidInteger, "integer literal 0xffff_ffff_ffff_ffff is out of range, the maximum is 9223372036854775807"},
+		{"1e400", CodeInvalidFloat, "float literal 1e400 is out of range, the maximum is 1.7976931348623157e+308"},
+		{"0b102", CodeMalformedToken, "invalid digit '2' in binary literal"},
+		{"1__0", CodeMalformedToken, "'_' must separate successive digits"},
+		{"1.5.5", CodeMalformedToken, "invalid char '.' in numeric literal"},
+		{"1.5e3.5", CodeMalformedToken, "invalid char '.' in numeric literal"},
+		{"0x1.5", CodeMalformedToken, "invalid char '.' in numeric literal"},
+		{"let a = [1, 2]; a.5", CodeIllegalCharacter, "illegal character \".\""},
+	}
+
+	for _, test := range tests {
+		p := New(lexer.New(test.input))
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Errorf("wrong number of diagnostics for %q. got=%v", test.input, p.Errors())
+			continue
+		}
+		if diagnostics[0].Code != test.wantCode || diagnostics[0].Message != test.wantMsg {
+			t.Errorf("wrong diagnostic for %q. want=%s %q, got=%s %q",
+				test.input, test.wantCode, test.wantMsg, diagnostics[0].Code, diagnostics[0].Message)
+		}
+	}
+}
+
 func TestFloatLiteralExpression(t *testing.T) {
 	input := `3.14;`
 
@@ -316,6 +445,9 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5", 5, "==", 5},
 		{"5 != 5", 5, "!=", 5},
+		{"5 % 5", 5, "%", 5},
+		{"5 <= 5", 5, "<=", 5},
+		{"5 >= 5", 5, ">=", 5},
 		{"3.14 + 3.14", 3.14, "+", 3.14},
 		{"3.14 - 3.14", 3.14, "-", 3.14},
 		{"3.14 * 3.14", 3.14, "*", 3.14},
@@ -335,6 +467,8 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"true == true", true, "==", true},
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
+		{"true && false", true, "&&", false},
+		{"true || false", true, "||", false},
 	}
 
 	for _, test := range infixTests {
@@ -411,6 +545,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"5 > 4 == 3 < 4",
 			"((5 > 4) == (3 < 4))",
 		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a || b && c == d || e",
+			"((a || (b && (c == d))) || e)",
+		},
+		{
+			"x = a && !b",
+			"(x = (a && (!b)))",
+		},
 		{
 			"3 + 4 * 5 == 3 * 1 + 4 * 5",
 			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))",
@@ -643,6 +793,215 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestLoopExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"while (x < y) { x }", "while(x < y) x"},
+		{"for (x in [1, 2]) { break; continue; }", "for (x in [1, 2]) break;continue;"},
+		{"while (true) { for (c in \"ab\") { puts(c) } }", "whiletrue for (c in ab) puts(c)"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.want {
+			t.Errorf("wrong program. want=%q, got=%q", test.want, program.String())
+		}
+	}
+
+	l := lexer.New("for (x in xs) { x }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.ForExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.ForExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Variable, "x") || !testIdentifier(t, exp.Iterable, "xs") {
+		return
+	}
+	if len(exp.Body.Statements) != 1 {
+		t.Errorf("body is not 1 statements. got=%d", len(exp.Body.Statements))
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"throw x;", "throw x;"},
+		{"throw {\"code\": 1}", "throw {code:1};"},
+		{"try { f() } catch (e) { e }", "try f() catch (e) e"},
+		{"try { f() } catch { 1 }", "try f() catch 1"},
+		{"try { f() } finally { g() }", "try f() finally g()"},
+		{"let x = 1 + try { f() } catch (e) { 0 } finally { g() };", "let x = (1 + try f() catch (e) 0 finally g());"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.want {
+			t.Errorf("wrong program. want=%q, got=%q", test.want, program.String())
+		}
+	}
+
+	l := lexer.New("try { f() } catch (err) { err }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Parameter, "err") {
+		return
+	}
+	if exp.Finally != nil {
+		t.Errorf("exp.Finally is not nil. got=%+v", exp.Finally)
+	}
+
+	errorTests := []struct {
+		input string
+		want  string
+	}{
+		{"try { f() }", "expected next token to be CATCH or FINALLY, got EOF instead"},
+		{"throw;", "no prefix parse function for ; found"},
+	}
+
+	for _, test := range errorTests {
+		p := New(lexer.New(test.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], test.want) {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%v", test.input, test.want, p.Errors())
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"match (x) { 1 => a, _ => b }", "match x { 1 => a, _ => b }"},
+		{"match (x) { [a, [b, _]] => a + b, }", "match x { [a, [b, _]] => (a + b) }"},
+		{`match (e) { {"type": "key", 1: k} if k > 0 => { k } -1 => 2 }`, "match e { {type:key, 1:k} if (k > 0) => k, (-1) => 2 }"},
+		{"match (x) { -1.5 => true, \"s\" => false, true => [] }", "match x { (-1.5) => true, s => false, true => [] }"},
+		{"match (x) { }", "match x {  }"},
+		{"match (x) { [a, ...rest] => rest, {name, age: 1} => name }", "match x { [a, ...rest] => rest, {name:name, age:1} => name }"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.want {
+			t.Errorf("wrong program. want=%q, got=%q", test.want, program.String())
+		}
+	}
+
+	l := lexer.New(`match (x) { [a, {"k": b}] if a => a }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Subject, "x") || len(exp.Arms) != 1 {
+		t.Fatalf("wrong match expression. got=%s", exp)
+	}
+
+	arm := exp.Arms[0]
+	array, ok := arm.Pattern.(*ast.ArrayPattern)
+	if !ok || len(array.Elements) != 2 {
+		t.Fatalf("arm.Pattern is not ast.ArrayPattern of 2 elements. got=%T (%s)", arm.Pattern, arm.Pattern)
+	}
+	hash, ok := array.Elements[1].(*ast.HashPattern)
+	if !ok || len(hash.Pairs) != 1 {
+		t.Fatalf("array.Elements[1] is not ast.HashPattern of 1 pair. got=%T (%s)", array.Elements[1], array.Elements[1])
+	}
+	if !testIdentifier(t, hash.Pairs[0].Value, "b") || !testIdentifier(t, arm.Guard, "a") {
+		return
+	}
+	if len(arm.Body.Statements) != 1 {
+		t.Errorf("body is not 1 statements. got=%d", len(arm.Body.Statements))
+	}
+
+	errorTests := []struct {
+		input string
+		want  string
+	}{
+		{"match (x) { x + 1 => 1 }", "1:15: expected next token to be =>, got + instead"},
+		{"match (x) { f() => 1 }", "1:14: expected next token to be =>, got ( instead"},
+		{"match (x) { (1) => 1 }", "1:13: expected pattern, got ( instead"},
+		{"match (x) { {[a]: 1} => 1 }", "1:14: expected IDENT, STRING or INT as the key of hash pattern, got [ instead"},
+		{"match (x) { [...a, b] => 1 }", "1:18: expected next token to be ], got , instead"},
+		{"match (x) { 1 => 1 2 => 2 }", "1:20: expected next token to be ,, got INT instead"},
+	}
+
+	for _, test := range errorTests {
+		p := New(lexer.New(test.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != test.want {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%v", test.input, test.want, p.Errors())
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"x = 5;", "(x = 5)"},
+		{"x = y = 1 + 2;", "(x = (y = (1 + 2)))"},
+		{"x += 2 * 3;", "(x += (2 * 3))"},
+		{"arr[1] -= 1;", "((arr[1]) -= 1)"},
+		{`h["k"] *= f(x);`, "((h[k]) *= f(x))"},
+		{"x /= 2 == 1;", "(x /= (2 == 1))"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.want {
+			t.Errorf("wrong program. want=%q, got=%q", test.want, program.String())
+		}
+	}
+
+	l := lexer.New("f() = 1;")
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. want=1, got=%d", len(diagnostics))
+	}
+	if diagnostics[0].Code != CodeInvalidAssign || diagnostics[0].Message != "cannot assign to f()" {
+		t.Errorf("wrong diagnostic. got=%s", diagnostics[0])
+	}
+}
+
 func TestIfElseExpression(t *testing.T) {
 	input := `if (x < y) { x } else { y }`
 
@@ -779,6 +1138,60 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"fn(x, y = 10) { x + y }", "fn(x, y = 10)(x + y)"},
+		{"fn(x = 1, y = x * 2) { y }", "fn(x = 1, y = (x * 2))y"},
+		{"fn(first, ...rest) { rest }", "fn(first, ...rest)rest"},
+		{"fn(...args) { args }", "fn(...args)args"},
+		{"fn(a, b = 2, ...c) { c }", "fn(a, b = 2, ...c)c"},
+		{"f(...arr)", "f(...arr)"},
+		{"f(1, ...[2, 3], x)", "f(1, ...[2, 3], x)"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != test.want {
+			t.Errorf("wrong program. want=%q, got=%q", test.want, program.String())
+		}
+	}
+
+	program := New(lexer.New("fn(a, b = 2, ...c) { c }")).ParseProgram()
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(function.Parameters) != 2 || len(function.Defaults) != 2 || function.Defaults[0] != nil {
+		t.Fatalf("wrong parameters. got=%v, defaults=%v", function.Parameters, function.Defaults)
+	}
+	testLiteralExpression(t, function.Defaults[1], 2)
+	testIdentifier(t, function.Rest, "c")
+
+	errorTests := []struct {
+		input string
+		want  string
+	}{
+		{"fn(x = 1, y) { }", "1:11: parameter y without default value follows parameter with default value"},
+		{"fn(...a, b) { }", "1:8: expected next token to be ), got , instead"},
+		{"fn(...a = 1) { }", "1:9: expected next token to be ), got = instead"},
+		{"fn(x y) { }", "1:6: expected next token to be ,, got IDENT instead"},
+		{"fn(1) { }", "1:4: expected next token to be IDENT, got INT instead"},
+		{"[...a]", "1:2: no prefix parse function for ... found"},
+	}
+
+	for _, test := range errorTests {
+		p := New(lexer.New(test.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != test.want {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%v", test.input, test.want, p.Errors())
+		}
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := `add(1, 2 * 3, 4 + 5)`
 
@@ -890,6 +1303,42 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantParts int
+		want      string
+	}{
+		{`"hello ${name}, you have ${len(items)} items"`, 5, "hello ${name}, you have ${len(items)} items"},
+		{`"${a + b}"`, 1, "${(a + b)}"},
+		{`"${x}${y}!"`, 3, "${x}${y}!"},
+		{`"outer ${"inner ${x}"}"`, 2, "outer ${inner ${x}}"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		is, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+
+		if len(is.Parts) != test.wantParts {
+			t.Errorf("wrong number of parts for %q. want=%d, got=%d", test.input, test.wantParts, len(is.Parts))
+		}
+		if is.String() != test.want {
+			t.Errorf("wrong string for %q. want=%q, got=%q", test.input, test.want, is.String())
+		}
+		if is.End().Column != len(test.input)+1 {
+			t.Errorf("wrong end position for %q. got=%s", test.input, is.End())
+		}
+	}
+}
+
 func TestParseArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -1083,3 +1532,196 @@ func TestMacroLiteralParsing(t *testing.T) {
 
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
+
+func TestImportExpressionParsing(t *testing.T) {
+	input := `let lib = import("lib/math"); let _private = 1; let x = 2; let x = 3; let [y, {_z, w}] = x; x;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T",
+			program.Statements[0])
+	}
+
+	imp, ok := stmt.Value.(*ast.ImportExpression)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.ImportExpression. got=%T", stmt.Value)
+	}
+	if imp.Path.Value != "lib/math" {
+		t.Errorf("imp.Path.Value is not %q. got=%q", "lib/math", imp.Path.Value)
+	}
+	if imp.String() != `import("lib/math")` {
+		t.Errorf("imp.String() wrong. got=%q", imp.String())
+	}
+
+	exports := program.Exports()
+	if strings.Join(exports, ",") != "lib,x,y,w" {
+		t.Errorf("program.Exports() wrong. want=[lib x y w], got=%v", exports)
+	}
+
+	for _, input := range []string{`import(lib)`, `import "lib"`, `import("lib"`} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("no parser errors for %q", input)
+		}
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantPos string
+		wantEnd string
+	}{
+		{"foobar;", "1:1", "1:7"},
+		{"1 + 2 * 3", "1:1", "1:10"},
+		{"-x", "1:1", "1:3"},
+		{"add(1,\n  2)", "1:1", "2:5"},
+		{"[1, 2][0]", "1:1", "1:10"},
+		{`{"a": 1}`, "1:1", "1:9"},
+		{"if (x) {\n  y\n} else {\n  z\n}", "1:1", "5:2"},
+		{"fn(x) { x }", "1:1", "1:12"},
+		{"let x = 10;", "1:1", "1:11"},
+		{"return x;", "1:1", "1:9"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		stmt := program.Statements[0]
+		if stmt.Pos().String() != test.wantPos {
+			t.Errorf("wrong start position for %q. want=%s, got=%s",
+				test.input, test.wantPos, stmt.Pos())
+		}
+		if stmt.End().String() != test.wantEnd {
+			t.Errorf("wrong end position for %q. want=%s, got=%s",
+				test.input, test.wantEnd, stmt.End())
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// add returns the sum.
+let add = fn(x, y) { x /* left */ + y }; // trailing
+add(1, 2)`
+
+	for _, keep := range []bool{false, true} {
+		l := lexer.New(input)
+		if keep {
+			l.KeepComments()
+		}
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != "let add = fn(x, y)(x + y);add(1, 2)" {
+			t.Errorf("program.String() wrong. got=%q", got)
+		}
+
+		stmt := program.Statements[0].(*ast.LetStatement)
+		want := 0
+		if keep {
+			want = 1
+		}
+		if len(stmt.Token.Comments) != want {
+			t.Fatalf("let statement has wrong number of comments. want=%d, got=%d",
+				want, len(stmt.Token.Comments))
+		}
+		if keep && stmt.Token.Comments[0].Text != "// add returns the sum." {
+			t.Errorf("wrong comment. got=%q", stmt.Token.Comments[0].Text)
+		}
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantCodes []string
+		wantPos   []string
+	}{
+		{
+			"let = 5; let y = 10; let 838383;",
+			[]string{CodeUnexpectedToken, CodeUnexpectedToken},
+			[]string{"1:5", "1:26"},
+		},
+		{
+			"add(1, 2; let x = (3 + ; x",
+			[]string{CodeUnexpectedToken, CodeNoPrefixParseFn},
+			[]string{"1:9", "1:24"},
+		},
+		{
+			"let f = fn(x) {\n  let = 1;\n  x +\n};\nlet y = #;",
+			[]string{CodeUnexpectedToken, CodeNoPrefixParseFn, CodeIllegalCharacter},
+			[]string{"2:7", "4:1", "5:9"},
+		},
+		{
+			"let s = \"abc;\nlet t = \"a\\qb\";\nputs(s, t)",
+			[]string{CodeMalformedToken, CodeMalformedToken},
+			[]string{"1:9", "2:9"},
+		},
+		{
+			"let x = 1; /* never closed\nlet y = 2;",
+			[]string{CodeMalformedToken},
+			[]string{"1:12"},
+		},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != len(test.wantCodes) {
+			t.Errorf("wrong number of diagnostics for %q. want=%d, got=%d (%v)",
+				test.input, len(test.wantCodes), len(diagnostics), p.Errors())
+			continue
+		}
+
+		for i, d := range diagnostics {
+			if d.Code != test.wantCodes[i] {
+				t.Errorf("diagnostics[%d] has wrong code. want=%s, got=%s (%s)",
+					i, test.wantCodes[i], d.Code, d)
+			}
+			if d.Pos.String() != test.wantPos[i] {
+				t.Errorf("diagnostics[%d] has wrong position. want=%s, got=%s (%s)",
+					i, test.wantPos[i], d.Pos, d)
+			}
+		}
+	}
+}
+
+func TestParserSuggestedFix(t *testing.T) {
+	l := lexer.New("add(1, 2;")
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. want=1, got=%d", len(diagnostics))
+	}
+
+	fix := diagnostics[0].Fix
+	if fix == nil {
+		t.Fatalf("diagnostic has no suggested fix")
+	}
+	if fix.NewText != ")" {
+		t.Errorf("fix.NewText wrong. want=%q, got=%q", ")", fix.NewText)
+	}
+	if fix.Pos.String() != "1:9" || fix.End.String() != "1:9" {
+		t.Errorf("fix is not an insertion at 1:9. got=%s-%s", fix.Pos, fix.End)
+	}
+}
@@ -1,10 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 
 	"github.com/toversus/monkey/ast"
+	"github.com/toversus/monkey/diagnostic"
 	"github.com/toversus/monkey/flags"
 	"github.com/toversus/monkey/lexer"
 	"github.com/toversus/monkey/token"
@@ -16,10 +20,13 @@ const (
 
 	// The order and the relation to each other are critical for representing precedence.
 	LOWEST
+	ASSIGNMENT  // = or +=
+	LOGICALOR   // ||
+	LOGICALAND  // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
-	PRODUCT     // *
+	PRODUCT     // * or %
 	PREFIX      // -X or !X
 	CALL        //myFunction(X)
 	INDEX       // array[index]
@@ -28,23 +35,61 @@ const (
 // precedences represents precedence table for associating token type with their precedence.
 // For now, some values of the field are incorrect such as token.MINUS which has the same precedence as token.PLUS.
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOTEQ:    EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGNMENT,
+	token.PLUS_ASSIGN:     ASSIGNMENT,
+	token.MINUS_ASSIGN:    ASSIGNMENT,
+	token.ASTERISK_ASSIGN: ASSIGNMENT,
+	token.SLASH_ASSIGN:    ASSIGNMENT,
+	token.OR:              LOGICALOR,
+	token.AND:             LOGICALAND,
+	token.EQ:              EQUALS,
+	token.NOTEQ:           EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+}
+
+// Codes of the diagnostics reported by the parser.
+const (
+	CodeUnexpectedToken  = "E0001"
+	CodeNoPrefixParseFn  = "E0002"
+	CodeInvalidInteger   = "E0003"
+	CodeInvalidFloat     = "E0004"
+	CodeIllegalCharacter = "E0005"
+	CodeInvalidAssign    = "E0006"
+	CodeMalformedToken   = "E0007"
+	CodeInvalidPattern   = "E0008"
+	CodeInvalidParameter = "E0009"
+)
+
+// statementKeywords are the tokens which always start a new statement.
+// The parser resynchronizes at them after a syntax error.
+var statementKeywords = map[token.TokenType]bool{
+	token.LET:      true,
+	token.RETURN:   true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+	token.THROW:    true,
 }
 
 // Parser is used to construct AST.
 type Parser struct {
 	l *lexer.Lexer
 
-	errors []string
+	diagnostics []diagnostic.Diagnostic
+
+	// panicking is set after reporting a syntax error until the parser resynchronizes
+	// at the boundary of the statement. Errors reported in the meantime are suppressed
+	// because they are mostly caused by the first one.
+	panicking bool
 
 	// curToken is "pointers" (position and readPosition) to the current token.
 	curToken token.Token
@@ -66,8 +111,8 @@ type (
 // New initiates parser.
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []diagnostic.Diagnostic{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
@@ -80,21 +125,37 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.WHILE, p.parseWhileExpression)
+	p.registerPrefix(token.FOR, p.parseForExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.STRING_HEAD, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOTEQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -128,18 +189,52 @@ func (p *Parser) ParseProgram() *ast.Program {
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
+		if p.panicking {
+			p.synchronize()
+		}
 		p.nextToken()
 	}
 
 	return program
 }
 
+// synchronize skips tokens until the end of the broken statement, so that parsing can
+// continue with the next one and report every independent syntax error in one run.
+// It stops at the semicolon, the closing brace or right before the next statement keyword,
+// and leaves the boundary as the current token for the caller to step over.
+func (p *Parser) synchronize() {
+	for !p.curTokenIs(token.SEMICOLON) && !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		if p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) || statementKeywords[p.peekToken.Type] {
+			break
+		}
+		p.nextToken()
+	}
+	p.panicking = false
+}
+
+// parseStatement dispatches parsing by the current token.
+// Typed nil pointers are never returned as ast.Statement so that callers can check the result for nil.
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
 	case token.RETURN:
-		return p.parseReturnStatement()
+		if stmt := p.parseReturnStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.THROW:
+		if stmt := p.parseThrowStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
 
 	// Workaround for passing TestLetStatements at this time.
 	case token.SEMICOLON:
@@ -155,11 +250,19 @@ func (p *Parser) parseStatement() ast.Statement {
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
-		return nil
-	}
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil || !p.checkLetPattern(stmt.Pattern) {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -168,6 +271,12 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+
+	// Functions remember the name they are bound to for stack traces.
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -197,16 +306,73 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	return false
 }
 
-// Errors returns error messages.
+// Errors returns error messages prefixed with their positions.
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := make([]string, 0, len(p.diagnostics))
+	for _, d := range p.diagnostics {
+		errors = append(errors, d.Pos.String()+": "+d.Message)
+	}
+	return errors
+}
+
+// Diagnostics returns the structured errors found while parsing.
+func (p *Parser) Diagnostics() []diagnostic.Diagnostic {
+	return p.diagnostics
+}
+
+// report records the diagnostic of a syntax error found in the given token
+// and puts the parser in panic mode.
+func (p *Parser) report(tok token.Token, code, msg string, fix *diagnostic.SuggestedFix) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+
+	p.diagnostics = append(p.diagnostics, diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Code:     code,
+		Message:  msg,
+		Fix:      fix,
+	})
 }
 
 // peekError is helper function to detect mismatch of the type of peekToken.
+// It suggests inserting the expected token when it is a missing delimiter.
 func (p *Parser) peekError(t token.TokenType) {
+	if p.peekTokenIs(token.ILLEGAL) || p.peekTokenIs(token.ERROR) {
+		p.illegalTokenError(p.peekToken)
+		return
+	}
+
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+
+	var fix *diagnostic.SuggestedFix
+	switch t {
+	case token.RPAREN, token.RBRACKET, token.RBRACE, token.COLON, token.ASSIGN:
+		fix = &diagnostic.SuggestedFix{
+			Message: fmt.Sprintf("insert %q", string(t)),
+			Pos:     p.curToken.End,
+			End:     p.curToken.End,
+			NewText: string(t),
+		}
+	}
+
+	p.report(p.peekToken, CodeUnexpectedToken, msg, fix)
+}
+
+// illegalTokenError reports the char which the lexer couldn't recognize.
+func (p *Parser) illegalTokenError(tok token.Token) {
+	// The lexer describes the problem of the malformed token in its literal.
+	if tok.Type == token.ERROR {
+		p.report(tok, CodeMalformedToken, tok.Literal, nil)
+		return
+	}
+
+	msg := fmt.Sprintf("illegal character %q", tok.Literal)
+	p.report(tok, CodeIllegalCharacter, msg, nil)
 }
 
 // parseReturnStatement just constructs ast.ReturnStatement with the current token.
@@ -252,8 +418,13 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 
 // noPrefixParseFnError is the helper function to detect non-exsistence of prefix parse function.
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
+	if t == token.ILLEGAL || t == token.ERROR {
+		p.illegalTokenError(p.curToken)
+		return
+	}
+
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.report(p.curToken, CodeNoPrefixParseFn, msg, nil)
 }
 
 // parseExpression finds prefix parsing function using the current token,
@@ -270,6 +441,10 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		return nil
 	}
 	leftExp := prefix()
+	if leftExp == nil {
+		// The error is already reported, and the infix functions expect the left operand.
+		return nil
+	}
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
@@ -293,10 +468,14 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	}
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	value, err := parseInteger(p.curToken.Literal)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		if errors.Is(err, strconv.ErrRange) {
+			msg = fmt.Sprintf("integer literal %s is out of range, the maximum is %d",
+				p.curToken.Literal, int64(math.MaxInt64))
+		}
+		p.report(p.curToken, CodeInvalidInteger, msg, nil)
 	}
 
 	lit.Value = value
@@ -304,16 +483,41 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+// parseInteger converts the integer literal validated by the lexer, which can have the prefix of the base
+// and '_' between digits. Unlike strconv.ParseInt with base 0, a leading zero doesn't mean octal.
+func parseInteger(literal string) (int64, error) {
+	digits := strings.ReplaceAll(literal, "_", "")
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 10 {
+			digits = digits[2:]
+		}
+	}
+	return strconv.ParseInt(digits, base, 64)
+}
+
 func (p *Parser) parseFloatLiteral() ast.Expression {
 	if *flags.Debug {
 		defer untrace(trace("parseFloatLiteral"))
 	}
 	lit := &ast.FloatLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	value, err := strconv.ParseFloat(strings.ReplaceAll(p.curToken.Literal, "_", ""), 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		if errors.Is(err, strconv.ErrRange) {
+			msg = fmt.Sprintf("float literal %s is out of range, the maximum is %g",
+				p.curToken.Literal, math.MaxFloat64)
+		}
+		p.report(p.curToken, CodeInvalidFloat, msg, nil)
 	}
 
 	lit.Value = value
@@ -374,6 +578,30 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseAssignExpression parses the value with the lower precedence than assignment,
+// which makes the assignment right associative, e.g. a = b = 1 assigns 1 to b first.
+// Only identifiers and index expressions can be assigned to.
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   target,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		msg := fmt.Sprintf("cannot assign to %s", target.String())
+		p.report(p.curToken, CodeInvalidAssign, msg, nil)
+		return nil
+	}
+
+	p.nextToken()
+	expression.Value = p.parseExpression(LOWEST)
+
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -428,6 +656,378 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// parseWhileExpression parses the condition in parentheses followed by the body.
+//
+//	while (<condition>) { <body> }
+func (p *Parser) parseWhileExpression() ast.Expression {
+	expression := &ast.WhileExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	return expression
+}
+
+// parseForExpression parses the loop variable and the iterable in parentheses followed by the body.
+//
+//	for (<identifier> in <expression>) { <body> }
+func (p *Parser) parseForExpression() ast.Expression {
+	expression := &ast.ForExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	expression.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	return expression
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseThrowStatement parses the value thrown, which can't be omitted unlike the loops' statements.
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseTryExpression parses the try block followed by the catch block, the finally block or both.
+//
+//	try { <block> } catch (<parameter>) { <catch> } finally { <finally> }
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			expression.Parameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		msg := fmt.Sprintf("expected next token to be %s or %s, got %s instead",
+			token.CATCH, token.FINALLY, p.peekToken.Type)
+		p.report(p.peekToken, CodeUnexpectedToken, msg, nil)
+		return nil
+	}
+
+	return expression
+}
+
+// parseMatchExpression parses the subject in parentheses followed by the arms in braces.
+// The arms are separated by commas, which can be omitted after the body in braces.
+//
+//	match (<subject>) { <pattern> => <expression>, <pattern> if <guard> => { <body> } }
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		expression.Arms = append(expression.Arms, arm)
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !arm.Body.Rbrace.IsValid() && !p.peekTokenIs(token.RBRACE) {
+			p.peekError(token.COMMA)
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	expression.Rbrace = p.curToken.End
+
+	return expression
+}
+
+// parseMatchArm parses the pattern, the optional guard and the body, which is wrapped
+// into the block if it is the single expression. A hash literal as the body must be parenthesized.
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{Pattern: p.parsePattern()}
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		arm.Body = p.parseBlockStatement()
+		return arm
+	}
+
+	p.nextToken()
+	stmt := &ast.ExpressionStatement{Token: p.curToken, Expression: p.parseExpression(LOWEST)}
+	if stmt.Expression == nil {
+		return nil
+	}
+	arm.Body = &ast.BlockStatement{Token: stmt.Token, Statements: []ast.Statement{stmt}}
+
+	return arm
+}
+
+// parsePattern parses the literal, the identifier, the array pattern or the hash pattern
+// starting at the current token. The literals are integers, floats, strings and booleans,
+// and numbers can be negated.
+func (p *Parser) parsePattern() ast.Expression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return p.parseIdentifier()
+
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE:
+		return p.prefixParseFns[p.curToken.Type]()
+
+	case token.MINUS:
+		if !p.peekTokenIs(token.INT) && !p.peekTokenIs(token.FLOAT) {
+			break
+		}
+		exp := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+		p.nextToken()
+		exp.Right = p.prefixParseFns[p.curToken.Type]()
+		if exp.Right == nil {
+			return nil
+		}
+		return exp
+
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+
+	case token.LBRACE:
+		return p.parseHashPattern()
+	}
+
+	msg := fmt.Sprintf("expected pattern, got %s instead", p.curToken.Type)
+	p.report(p.curToken, CodeInvalidPattern, msg, nil)
+	return nil
+}
+
+func (p *Parser) parseArrayPattern() ast.Expression {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+
+		// The rest of the elements closes the pattern.
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = p.parseIdentifier().(*ast.Identifier)
+			break
+		}
+
+		element := p.parsePattern()
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	pattern.Rbracket = p.curToken.End
+
+	return pattern
+}
+
+// parseHashPattern parses the pairs of the keys, which must be identifiers, string or integer literals,
+// and the patterns. The identifier is the key of its name, and binds the value itself if no pattern follows.
+func (p *Parser) parseHashPattern() ast.Expression {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		pair := &ast.PatternPair{}
+		switch p.curToken.Type {
+		case token.IDENT:
+			pair.Key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.peekTokenIs(token.COLON) {
+				pair.Value = p.parseIdentifier()
+			}
+		case token.STRING, token.INT:
+			pair.Key = p.prefixParseFns[p.curToken.Type]()
+		default:
+			msg := fmt.Sprintf("expected IDENT, STRING or INT as the key of hash pattern, got %s instead", p.curToken.Type)
+			p.report(p.curToken, CodeInvalidPattern, msg, nil)
+			return nil
+		}
+		if pair.Key == nil {
+			return nil
+		}
+
+		if pair.Value == nil {
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.nextToken()
+			if pair.Value = p.parsePattern(); pair.Value == nil {
+				return nil
+			}
+		}
+		pattern.Pairs = append(pattern.Pairs, pair)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	pattern.Rbrace = p.curToken.End
+
+	return pattern
+}
+
+// checkLetPattern reports the literals in the pattern of the let statement,
+// which binds the parts of the value without testing them.
+func (p *Parser) checkLetPattern(pattern ast.Expression) bool {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		return true
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			if !p.checkLetPattern(el) {
+				return false
+			}
+		}
+		return true
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			if !p.checkLetPattern(pair.Value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	msg := fmt.Sprintf("literal pattern %s is not allowed in let statement", pattern)
+	p.report(token.Token{Literal: pattern.String(), Pos: pattern.Pos(), End: pattern.End()}, CodeInvalidPattern, msg, nil)
+	return false
+}
+
 // parseBlockStatement calls parseStatement until it encounters either a "}" (end of the block) or
 // EOF (no more tokens left to parse).
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
@@ -441,9 +1041,20 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if p.panicking {
+			p.synchronize()
+			// The broken statement has already swallowed the closing brace of this block.
+			if p.curTokenIs(token.RBRACE) {
+				break
+			}
+		}
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken.End
+	}
+
 	return block
 }
 
@@ -454,7 +1065,9 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	if !p.parseParameters(lit) {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -465,6 +1078,56 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
+// parseParameters parses the parameters of the function literal up to the closing parenthesis.
+// Once a parameter has a default value, the following ones must have it too,
+// and the rest parameter must be the last one.
+func (p *Parser) parseParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
+
+	for !p.peekTokenIs(token.RPAREN) {
+		if len(lit.Parameters) > 0 && !p.expectPeek(token.COMMA) {
+			return false
+		}
+
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
+		param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		lit.Parameters = append(lit.Parameters, param)
+
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			value := p.parseExpression(LOWEST)
+			if value == nil {
+				return false
+			}
+			if lit.Defaults == nil {
+				lit.Defaults = make([]ast.Expression, len(lit.Parameters)-1)
+			}
+			lit.Defaults = append(lit.Defaults, value)
+			continue
+		}
+
+		if lit.Defaults != nil {
+			msg := fmt.Sprintf("parameter %s without default value follows parameter with default value", param.Value)
+			p.report(p.curToken, CodeInvalidParameter, msg, nil)
+			return false
+		}
+	}
+
+	return p.expectPeek(token.RPAREN)
+}
+
 // parseFunctionParameters constructs the slice of parameters by repeatedly building identifiers
 // from the comman separated list.
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
@@ -499,12 +1162,14 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 // and uses it to construct an *ast.CallExpression node.
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
-	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Arguments = p.parseCallArguments()
+	exp.Rparen = p.curToken.End
 	return exp
 }
 
 // parseCallArguments parses the argument list, which looks similar to parseFunctionParameters,
 // except that it's more generic and returns a slice of ast.Expression.
+// The argument prefixed with "..." is the spread of an array.
 func (p *Parser) parseCallArguments() []ast.Expression {
 	args := []ast.Expression{}
 
@@ -514,12 +1179,12 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	}
 
 	p.nextToken()
-	args = append(args, p.parseExpression(LOWEST))
+	args = append(args, p.parseCallArgument())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		args = append(args, p.parseCallArgument())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -529,14 +1194,62 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	return args
 }
 
+func (p *Parser) parseCallArgument() ast.Expression {
+	if !p.curTokenIs(token.ELLIPSIS) {
+		return p.parseExpression(LOWEST)
+	}
+
+	spread := &ast.SpreadExpression{Token: p.curToken}
+	p.nextToken()
+	if spread.Value = p.parseExpression(LOWEST); spread.Value == nil {
+		return nil
+	}
+	return spread
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseInterpolatedString parses the expressions between the parts of the string,
+// which the lexer splits into the head, the middles and the tail.
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	is := &ast.InterpolatedString{Token: p.curToken}
+	is.Parts = appendText(is.Parts, p.curToken)
+
+	for {
+		p.nextToken()
+		is.Parts = append(is.Parts, p.parseExpression(LOWEST))
+
+		if p.peekTokenIs(token.STRING_TAIL) {
+			p.nextToken()
+			is.Parts = appendText(is.Parts, p.curToken)
+			is.Quote = p.curToken.End
+			return is
+		}
+
+		if !p.expectPeek(token.STRING_MIDDLE) {
+			return nil
+		}
+		is.Parts = appendText(is.Parts, p.curToken)
+	}
+}
+
+// appendText appends the part of the interpolated string unless it is empty.
+func appendText(parts []ast.Expression, tok token.Token) []ast.Expression {
+	if tok.Literal == "" {
+		return parts
+	}
+
+	tok.Type = token.STRING
+	return append(parts, &ast.StringLiteral{Token: tok, Value: tok.Literal})
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken.End
 
 	return array
 }
@@ -575,6 +1288,7 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken.End
 
 	return exp
 }
@@ -606,10 +1320,29 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken.End
 
 	return hash
 }
 
+// parseImportExpression parses the path of the module, which must be a string literal
+// because the compiler resolves the module before running the program.
+func (p *Parser) parseImportExpression() ast.Expression {
+	ie := &ast.ImportExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.STRING) {
+		return nil
+	}
+	ie.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	ie.Rparen = p.curToken.End
+
+	return ie
+}
+
 func (p *Parser) parseMacroLiteral() ast.Expression {
 	lit := &ast.MacroLiteral{Token: p.curToken}
 
@@ -8,7 +8,10 @@ import (
 	"github.com/toversus/monkey/object"
 
 	"github.com/toversus/monkey/compiler"
+	"github.com/toversus/monkey/diagnostic"
+	"github.com/toversus/monkey/evaluator"
 	"github.com/toversus/monkey/lexer"
+	"github.com/toversus/monkey/module"
 	"github.com/toversus/monkey/parser"
 	"github.com/toversus/monkey/vm"
 )
@@ -41,11 +44,18 @@ func Start(in io.Reader, out io.Writer) {
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
 
+	// macroEnv keeps the macros defined in the previous lines.
+	macroEnv := object.NewEnvironment()
+
 	symbolTables := compiler.NewSymbolTable()
 	for i, v := range object.Builtins {
 		symbolTables.DefineBuiltin(i, v.Name)
 	}
 
+	// The modules are imported relative to the current directory.
+	loader := module.NewLoader(module.DefaultSearchPath()...)
+	loader.Expand = evaluator.ExpandModule
+
 	for {
 		fmt.Print(PROMPT)
 		if !sc.Scan() {
@@ -62,8 +72,15 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
+		expanded, diagnostics := evaluator.ExpandProgram(program, macroEnv)
+		if len(diagnostics) != 0 {
+			printMacroErrors(out, diagnostics)
+			continue
+		}
+
 		comp := compiler.NewWithState(symbolTables, constants)
-		err := comp.Compile(program)
+		comp.SetImporter(loader)
+		err := comp.Compile(expanded)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
@@ -77,10 +94,15 @@ func Start(in io.Reader, out io.Writer) {
 		machine := vm.NewWithGlobalsStore(code, globals)
 		err = machine.Run()
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			printRuntimeError(out, err)
+			continue
 		}
 
+		// Nothing is printed for the line which only defines macros or has only comments.
 		lastPopped := machine.LastPoppedStackElem()
+		if len(expanded.Statements) == 0 || lastPopped == nil {
+			continue
+		}
 		io.WriteString(out, lastPopped.Inspect())
 		io.WriteString(out, "\n")
 	}
@@ -94,3 +116,20 @@ func printParseErrors(out io.Writer, errors []string) {
 		io.WriteString(out, "\t"+msg+"\n")
 	}
 }
+
+func printMacroErrors(out io.Writer, diagnostics []diagnostic.Diagnostic) {
+	io.WriteString(out, "Woops! Expanding macros failed:\n")
+	for _, d := range diagnostics {
+		io.WriteString(out, "\t"+d.String()+"\n")
+	}
+}
+
+// printRuntimeError prints the traceback of the runtime error if the VM captured it.
+func printRuntimeError(out io.Writer, err error) {
+	io.WriteString(out, "Woops! Executing bytecode failed:\n")
+	if rtErr, ok := err.(*vm.RuntimeError); ok {
+		io.WriteString(out, rtErr.Traceback()+"\n")
+		return
+	}
+	fmt.Fprintf(out, " %s\n", err)
+}
@@ -0,0 +1,24 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	input := `let m = macro(a) { quote(unquote(a) * 2) };
+// hi
+m(5)
+let x = 1;
+x + 1
+`
+
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out)
+
+	want := "10\n1\n2\n"
+	if out.String() != want {
+		t.Errorf("wrong output. want=%q, got=%q", want, out.String())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/toversus/monkey/compiler"
+	"github.com/toversus/monkey/evaluator"
+	"github.com/toversus/monkey/lexer"
+	"github.com/toversus/monkey/module"
+	"github.com/toversus/monkey/object"
+	"github.com/toversus/monkey/parser"
+	"github.com/toversus/monkey/vm"
+)
+
+// argsName is the global variable which holds the arguments passed to the script as an array of strings.
+// It is always the first global, so that the serialized bytecode can be run without the symbol table.
+const argsName = "args"
+
+// run executes the script on the VM. The script is either Monkey source code
+// or bytecode serialized by the build command.
+// It returns the exit code of the process, reporting the diagnostics on the standard error.
+func run(path string, args []string) int {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var bytecode *compiler.Bytecode
+	if compiler.IsSerialized(src) {
+		bytecode, err = compiler.Decode(bytes.NewReader(src))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return 1
+		}
+	} else {
+		var ok bool
+		if bytecode, ok = compileFile(path, string(src), nil); !ok {
+			return 1
+		}
+	}
+
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[0] = scriptArgs(args)
+
+	machine := vm.NewWithGlobalsStore(bytecode, globals)
+	if err := machine.Run(); err != nil {
+		if rtErr, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprintln(os.Stderr, rtErr.Traceback())
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// compileFile lexes, parses, expands macros and compiles the source code.
+// It reports the diagnostics on the standard error and returns false if any of the phases fails.
+// The source code of the imported modules is recorded in sources by their paths if it is not nil.
+func compileFile(path, src string, sources map[string]string) (*compiler.Bytecode, bool) {
+	l := lexer.NewWithFilename(path, src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		for _, d := range p.Diagnostics() {
+			fmt.Fprintln(os.Stderr, d)
+		}
+		return nil, false
+	}
+
+	expanded, diagnostics := evaluator.ExpandProgram(program, object.NewEnvironment())
+	if len(diagnostics) != 0 {
+		for _, d := range diagnostics {
+			fmt.Fprintln(os.Stderr, d)
+		}
+		return nil, false
+	}
+
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	symbolTable.Define(argsName)
+
+	loader := module.NewLoader(module.DefaultSearchPath()...)
+	loader.Expand = evaluator.ExpandModule
+	loader.Sources = sources
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	comp.SetImporter(loader)
+	if err := comp.Compile(expanded); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+
+	return comp.Bytecode(), true
+}
+
+// scriptArgs converts the command line arguments into an array of strings.
+func scriptArgs(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elements}
+}
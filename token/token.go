@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 // Whispace is not here because it just acts as a separator for other tokens.
 // Unlike Python, no interest towards the length of whitespace for the lexer (tokenizer).
 const (
@@ -7,16 +9,26 @@ const (
 	ILLEGAL = "ILLEGAL"
 	// EOF is used to pass on "end of file" to the parser.
 	EOF = "EOF"
+	// ERROR signifies malformed token such as unterminated string.
+	// Its literal is the message describing the problem instead of the source code.
+	ERROR = "ERROR"
 
 	// IDENT represents identifiers such as add, foobar, x, y, ...
 	IDENT = "IDENT"
 	// INT represents integer such as 123456.
 	INT = "INT"
 	// STRING represents string literal which are sequence of characters.
-	// TODO: support for character escaping.
+	// Its literal holds the characters after decoding the escape sequences.
 	STRING = "STRING"
 	FLOAT  = "FLOAT"
 
+	// STRING_HEAD, STRING_MIDDLE and STRING_TAIL are the parts of the string around the interpolated
+	// expressions such as "a ${x} b ${y} c". The head is the part up to the first "${",
+	// the middles are the parts between "}" and "${", and the tail is the part after the last "}".
+	STRING_HEAD   = "STRING_HEAD"
+	STRING_MIDDLE = "STRING_MIDDLE"
+	STRING_TAIL   = "STRING_TAIL"
+
 	// ASSIGN is used when binding some values to a name.
 	ASSIGN = "="
 	// PLUS represents to add left and right side of operator.
@@ -31,21 +43,42 @@ const (
 	ASTERISK = "*"
 	// SLASH represents division remainder.
 	SLASH = "/"
+	// PERCENT represents the remainder of division.
+	PERCENT = "%"
 
 	// LT represents "less than".
 	LT = "<"
 	// GT represents "greater than".
 	GT = ">"
+	// LT_EQ represents "less than or equal to".
+	LT_EQ = "<="
+	// GT_EQ represents "greater than or equal to".
+	GT_EQ = ">="
+
+	// AND and OR are the logical operators, which evaluate the right side
+	// only when the left side doesn't decide the result.
+	AND = "&&"
+	OR  = "||"
 
 	EQ    = "=="
 	NOTEQ = "!="
 
+	// Compound assignments apply the operator to the target and the value before assigning.
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
 	// COMMA is delimiter to separate multiple values.
 	COMMA = ","
 	// SEMICOLON is delimiter to represent the end of statement.
 	SEMICOLON = ";"
 	// COLON is delimiter to represent the hash map.
 	COLON = ":"
+	// ARROW separates the pattern of the match arm from its body.
+	ARROW = "=>"
+	// ELLIPSIS collects the rest of the elements in the array pattern.
+	ELLIPSIS = "..."
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -66,7 +99,26 @@ const (
 	ELSE   = "ELSE"
 	RETURN = "RETURN"
 
+	// WHILE and FOR start the loops, which are left early with BREAK
+	// or go on to the next iteration with CONTINUE.
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+
 	MACRO = "MACRO"
+
+	IMPORT = "IMPORT"
+
+	// THROW raises the exception, which TRY catches with CATCH. FINALLY runs in any case.
+	THROW   = "THROW"
+	TRY     = "TRY"
+	CATCH   = "CATCH"
+	FINALLY = "FINALLY"
+
+	// MATCH compares the value with the patterns of its arms.
+	MATCH = "MATCH"
 )
 
 // TokenType is used to distinguish between different type of tokens.
@@ -78,21 +130,78 @@ type TokenType string
 // Type attribute is used to distinguish between 'integers' and 'right bracket'
 // Literal attribute memorizes whether a 'number' token is a 5 or a 10,
 // and this information will be reused in AST flow.
+// Pos and End record where the token starts and the position just after its last char.
+// Comments holds the comments between the previous token and this one, only when the lexer keeps them.
 type Token struct {
 	Type    TokenType
 	Literal string
+
+	Pos Position
+	End Position
+
+	Comments []Comment
+}
+
+// Comment is the line comment or the block comment, which is trivia ignored by the parser.
+// Text includes the delimiters, "//" or "/*" and "*/", so tools can write it back as it is.
+type Comment struct {
+	Text string
+
+	Pos Position
+	End Position
+}
+
+// Position describes a location in the source code.
+// Line and Column start from 1, and Offset is the byte offset from the beginning of the input.
+// Column counts characters rather than bytes.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position has been set by the lexer.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns the position in the form of "file:line:column".
+// The file name is omitted when it is not known.
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+
+	s := fmt.Sprintf("%d:%d", p.Line, p.Column)
+	if p.Filename != "" {
+		s = p.Filename + ":" + s
+	}
+	return s
 }
 
 // keywords is the table of reserved keywords in language and its tokentype.
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"macro":  MACRO,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"macro":    MACRO,
+	"import":   IMPORT,
+	"throw":    THROW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"match":    MATCH,
 }
 
 // LookupIdent checks whether the given identifier is a reserved keyword or user-defined identifier.
@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/toversus/monkey/object"
+)
+
+// ErrorKind classifies the runtime errors so that hosts can react to them without parsing messages.
+type ErrorKind string
+
+const (
+	TypeError          ErrorKind = "TypeError"
+	ArgumentError      ErrorKind = "ArgumentError"
+	StackOverflowError ErrorKind = "StackOverflowError"
+	IndexError         ErrorKind = "IndexError"
+	ArithmeticError    ErrorKind = "ArithmeticError"
+	InternalError      ErrorKind = "InternalError"
+
+	// UncaughtException is the exception raised by the throw statement which no try expression catches.
+	UncaughtException ErrorKind = "UncaughtException"
+)
+
+// RuntimeError is returned by Run when the execution of bytecode fails.
+// Trace holds the call frames from the outermost to the innermost one,
+// where the innermost one is the frame that raised the error.
+type RuntimeError struct {
+	Kind    ErrorKind
+	Message string
+	Trace   []object.TraceFrame
+
+	// Value is the value thrown by the throw statement, which is nil for the errors raised by the VM.
+	Value object.Object
+}
+
+func (e *RuntimeError) Error() string { return e.Message }
+
+// Traceback formats the error like Python does, with the most recent call last.
+func (e *RuntimeError) Traceback() string {
+	return object.FormatTraceback(e.Trace, fmt.Sprintf("%s: %s", e.Kind, e.Message))
+}
+
+func newError(kind ErrorKind, format string, a ...interface{}) *RuntimeError {
+	return &RuntimeError{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// stackTrace captures the active call frames with the source positions of the instructions being executed.
+func (vm *VM) stackTrace() []object.TraceFrame {
+	trace := make([]object.TraceFrame, 0, vm.frameIndex)
+
+	for i := 0; i < vm.frameIndex; i++ {
+		frame := vm.frames[i]
+		fn := frame.cl.Fn
+
+		name := fn.Name
+		if i == 0 {
+			name = "<main>"
+		} else if name == "" {
+			name = "<anonymous>"
+		}
+
+		trace = append(trace, object.TraceFrame{
+			Function: name,
+			Pos:      fn.Lines.Lookup(frame.ip),
+		})
+	}
+
+	return trace
+}
+
+// exception is the exception being handled, which the handler finds on top of the stack.
+// The catch block replaces it with the value it receives, and the finally block raises it again.
+type exception struct {
+	err *RuntimeError
+}
+
+func (e *exception) Type() object.ObjectType { return "EXCEPTION" }
+func (e *exception) Inspect() string         { return "exception: " + e.err.Message }
+
+// caughtValue returns the value the catch block receives: the thrown value,
+// or the hash describing the runtime error.
+func (e *exception) caughtValue() object.Object {
+	if e.err.Value != nil {
+		return e.err.Value
+	}
+	return object.NewErrorHash(e.err.Message, e.err.Trace)
+}
+
+// handle looks for the handler of the error from the current frame outwards, dropping the frames
+// which don't handle it, and resumes the execution at the handler with the exception pushed.
+// It reports false if no try expression catches the error. The internal errors are never caught.
+func (vm *VM) handle(err error) bool {
+	rtErr, ok := err.(*RuntimeError)
+	if !ok || rtErr.Kind == InternalError {
+		return false
+	}
+
+	// The trace is captured before the frames are dropped, and kept when the exception is rethrown.
+	if rtErr.Trace == nil {
+		rtErr.Trace = vm.stackTrace()
+	}
+
+	for i := vm.frameIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+
+		h, ok := frame.cl.Fn.Handlers.Lookup(frame.ip)
+		if !ok {
+			continue
+		}
+
+		sp := frame.basePointer + frame.cl.Fn.NumLocals + h.Depth
+		if sp >= StackSize {
+			// There is no room for the exception only if the stack was already full
+			// at the start of the try expression, or the bytecode is corrupted.
+			return false
+		}
+
+		vm.frameIndex = i + 1
+		vm.sp = sp
+		frame.ip = h.Target - 1
+		vm.push(&exception{err: rtErr})
+		return true
+	}
+
+	return false
+}
@@ -1,7 +1,8 @@
 package vm
 
 import (
-	"fmt"
+	"math"
+	"strings"
 
 	"github.com/toversus/monkey/code"
 	"github.com/toversus/monkey/compiler"
@@ -30,10 +31,16 @@ type VM struct {
 
 	frames     []*Frame
 	frameIndex int
+
+	overflow object.OverflowPolicy // policy of the integer arithmetic, checked by default.
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Lines:        bytecode.Lines,
+		Handlers:     bytecode.Handlers,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
@@ -60,13 +67,48 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// SetOverflowPolicy chooses what the integer arithmetic does when the result doesn't fit in int64.
+func (vm *VM) SetOverflowPolicy(policy object.OverflowPolicy) {
+	vm.overflow = policy
+}
+
 // LastPoppedStackElem pops the next free slot in vm.stack,
 // where a new element would be pushed.
 func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// Run executes the bytecode. If the execution fails, it returns *RuntimeError
+// holding the call frames which were active at that time.
 func (vm *VM) Run() error {
+	err := vm.run()
+	if err == nil {
+		return nil
+	}
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		rtErr = newError(InternalError, "%s", err)
+	}
+	if rtErr.Trace == nil {
+		rtErr.Trace = vm.stackTrace()
+	}
+
+	return rtErr
+}
+
+// run executes the instructions until the end of the main program, resuming the execution
+// at the handler whenever a try expression catches the error.
+func (vm *VM) run() error {
+	for {
+		err := vm.execute()
+		if err == nil || !vm.handle(err) {
+			return err
+		}
+	}
+}
+
+func (vm *VM) execute() error {
 	var (
 		ip  int
 		ins code.Instructions
@@ -90,9 +132,9 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
 			if err := vm.executeBinaryOperation(op); err != nil {
-				return nil
+				return err
 			}
 
 		case code.OpPop:
@@ -108,7 +150,7 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
 			if err := vm.executeComparison(op); err != nil {
 				return err
 			}
@@ -139,7 +181,7 @@ func (vm *VM) Run() error {
 		case code.OpNull:
 			err := vm.push(Null)
 			if err != nil {
-				return nil
+				return err
 			}
 
 		case code.OpSetGlobal:
@@ -152,7 +194,12 @@ func (vm *VM) Run() error {
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			if err := vm.push(vm.globals[globalIndex]); err != nil {
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return newError(InternalError, "global %d is not set", globalIndex)
+			}
+
+			if err := vm.push(global); err != nil {
 				return err
 			}
 
@@ -168,6 +215,18 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpConcat:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			str := vm.buildString(vm.sp-numParts, vm.sp)
+			vm.sp = vm.sp - numParts
+
+			err := vm.push(str)
+			if err != nil {
+				return err
+			}
+
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -201,6 +260,93 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpCallSpread:
+			numParts := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip++
+
+			err := vm.executeSpreadCall(numParts)
+			if err != nil {
+				return err
+			}
+
+		case code.OpImport:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			constIndex := code.ReadUint16(ins[ip+3:])
+			vm.currentFrame().ip += 4
+
+			if err := vm.executeImport(int(globalIndex), int(constIndex)); err != nil {
+				return err
+			}
+
+		case code.OpThrow:
+			value := vm.pop()
+			err := newError(UncaughtException, "%s", value.Inspect())
+			err.Value = value
+			return err
+
+		case code.OpCatch:
+			exc, ok := vm.stack[vm.sp-1].(*exception)
+			if !ok {
+				return newError(InternalError, "expected exception on top of stack, got %s", vm.stack[vm.sp-1].Type())
+			}
+			vm.stack[vm.sp-1] = exc.caughtValue()
+
+		case code.OpRethrow:
+			exc, ok := vm.pop().(*exception)
+			if !ok {
+				return newError(InternalError, "expected exception on top of stack")
+			}
+			return exc.err
+
+		case code.OpMatchValue:
+			literal := vm.pop()
+			value := vm.pop()
+
+			if err := vm.push(nativeBoolToBooleanObject(object.Equal(literal, value))); err != nil {
+				return err
+			}
+
+		case code.OpMatchArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			hasRest := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			array, ok := vm.stack[vm.sp-1].(*object.Array)
+			matched := ok && (len(array.Elements) == numElements || hasRest && len(array.Elements) > numElements)
+			vm.stack[vm.sp-1] = nativeBoolToBooleanObject(matched)
+
+		case code.OpSlice:
+			start := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array, ok := vm.stack[vm.sp-1].(*object.Array)
+			if !ok {
+				return newError(TypeError, "rest of array pattern not supported: %s", vm.stack[vm.sp-1].Type())
+			}
+
+			elements := []object.Object{}
+			if start < len(array.Elements) {
+				elements = append(elements, array.Elements[start:]...)
+			}
+			vm.stack[vm.sp-1] = &object.Array{Elements: elements}
+
+		case code.OpMatchHash:
+			numKeys := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			matched := matchHash(vm.stack[vm.sp-numKeys-1], vm.stack[vm.sp-numKeys:vm.sp])
+			vm.sp = vm.sp - numKeys
+			vm.stack[vm.sp-1] = nativeBoolToBooleanObject(matched)
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
@@ -226,8 +372,14 @@ func (vm *VM) Run() error {
 			vm.currentFrame().ip++
 
 			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
 
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			// The local captured by closures lives in the cell shared with them.
+			if cell, ok := vm.stack[slot].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
 
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
@@ -235,7 +387,15 @@ func (vm *VM) Run() error {
 
 			frame := vm.currentFrame()
 
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			local := vm.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := local.(*object.Cell); ok {
+				local = cell.Value
+			}
+			if local == nil {
+				return newError(InternalError, "local %d is not set", localIndex)
+			}
+
+			err := vm.push(local)
 			if err != nil {
 				return err
 			}
@@ -265,8 +425,94 @@ func (vm *VM) Run() error {
 			freeIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip++
 
-			currentClosure := vm.currentFrame().cl
-			if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
+			free := vm.currentFrame().cl.Free[freeIndex].Value
+			if free == nil {
+				return newError(InternalError, "free variable %d is not set", freeIndex)
+			}
+
+			if err := vm.push(free); err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+
+			vm.currentFrame().cl.Free[freeIndex].Value = vm.pop()
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+
+			slot := vm.currentFrame().basePointer + int(localIndex)
+			cell, ok := vm.stack[slot].(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: vm.stack[slot]}
+				vm.stack[slot] = cell
+			}
+
+			if err := vm.push(cell); err != nil {
+				return err
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+
+			if err := vm.push(vm.currentFrame().cl.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			if err := vm.push(vm.currentFrame().cl); err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeSetIndex(left, index, value); err != nil {
+				return err
+			}
+
+		case code.OpDup:
+			count := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip++
+
+			for _, obj := range vm.stack[vm.sp-count : vm.sp] {
+				if err := vm.push(obj); err != nil {
+					return err
+				}
+			}
+
+		case code.OpGetIter:
+			iterable := vm.pop()
+			iter, ok := object.NewIterator(iterable)
+			if !ok {
+				return newError(TypeError, "cannot iterate over %s", iterable.Type())
+			}
+
+			if err := vm.push(iter); err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			// The iterator stays on the stack during the loop and is popped after it.
+			iter, ok := vm.stack[vm.sp-1].(*object.Iterator)
+			if !ok {
+				return newError(InternalError, "expected iterator on top of stack, got %s", vm.stack[vm.sp-1].Type())
+			}
+
+			if el, ok := iter.Next(); ok {
+				if err := vm.push(el); err != nil {
+					return err
+				}
+				if err := vm.push(True); err != nil {
+					return err
+				}
+			} else if err := vm.push(False); err != nil {
 				return err
 			}
 		}
@@ -277,7 +523,7 @@ func (vm *VM) Run() error {
 
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+		return newError(StackOverflowError, "stack overflow")
 	}
 
 	vm.stack[vm.sp] = o
@@ -307,41 +553,51 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
 
-	case leftType == object.FLOAT_OBJ && rightType == object.FLOAT_OBJ:
+	case isNumber(left) && isNumber(right):
 		return vm.executeBinaryFloatOperation(op, left, right)
 
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	}
 
-	return fmt.Errorf("unsupported types for binary operation: %s %s",
+	return newError(TypeError, "unsupported types for binary operation: %s %s",
 		leftType, rightType)
 }
 
+// integerOperators maps the opcodes of the integer arithmetic to their operators.
+var integerOperators = map[code.Opcode]string{
+	code.OpAdd: "+",
+	code.OpSub: "-",
+	code.OpMul: "*",
+	code.OpDiv: "/",
+	code.OpMod: "%",
+}
+
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
 	leftValue := left.(*object.Integer).Value
 	rightValue := right.(*object.Integer).Value
 
-	var result int64
+	operator, ok := integerOperators[op]
+	if !ok {
+		return newError(InternalError, "unknown integer operator: %d", op)
+	}
 
-	switch op {
-	case code.OpAdd:
-		result = leftValue + rightValue
-	case code.OpSub:
-		result = leftValue - rightValue
-	case code.OpMul:
-		result = leftValue * rightValue
-	case code.OpDiv:
-		result = leftValue / rightValue
+	result, err := object.IntegerArithmetic(operator, leftValue, rightValue, vm.overflow)
+	switch err {
+	case nil:
+		return vm.push(&object.Integer{Value: result})
+	case object.ErrIntegerOverflow:
+		return newError(ArithmeticError, "%s: %d %s %d", err, leftValue, operator, rightValue)
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return newError(ArithmeticError, "%s", err)
 	}
-	return vm.push(&object.Integer{Value: result})
 }
 
+// executeBinaryFloatOperation converts the operands into float values, so that an integer
+// is promoted to a float when the other operand is a float, then pushes the result.
 func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Float).Value
-	rightValue := right.(*object.Float).Value
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
 
 	var result float64
 
@@ -354,15 +610,17 @@ func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Obj
 		result = leftValue * rightValue
 	case code.OpDiv:
 		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
 	default:
-		return fmt.Errorf("unknown float operator: %d", op)
+		return newError(InternalError, "unknown float operator: %d", op)
 	}
 	return vm.push(&object.Float{Value: result})
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operation: %d", op)
+		return newError(TypeError, "unknown string operation: %d", op)
 	}
 
 	leftValue := left.(*object.String).Value
@@ -378,9 +636,9 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ || right.Type() == object.INTEGER_OBJ {
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
-	} else if left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ {
+	} else if isNumber(left) && isNumber(right) {
 		return vm.executeFloatComparison(op, left, right)
 	}
 
@@ -390,7 +648,7 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	case code.OpNotEqual:
 		return vm.push(nativeBoolToBooleanObject(right != left))
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)",
+		return newError(TypeError, "unknown operator: %d (%s %s)",
 			op, left.Type(), right.Type())
 	}
 }
@@ -408,16 +666,18 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return newError(InternalError, "unknown operator: %d", op)
 	}
 }
 
-// executeFloatComparison unwraps the float values and compares the operands
-// then returns the resulting bool into True or False.
+// executeFloatComparison converts the operands into float values and compares them,
+// so that an integer can be compared with a float, then returns the resulting bool into True or False.
 func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Float).Value
-	rightValue := right.(*object.Float).Value
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
 
 	switch op {
 	case code.OpEqual:
@@ -426,9 +686,23 @@ func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object)
 		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return newError(InternalError, "unknown operator: %d", op)
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat returns the value of the integer or the float as float64.
+func toFloat(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return float64(integer.Value)
 	}
+	return obj.(*object.Float).Value
 }
 
 // executeMinusOperator pops the operand off the stack and negates its value.
@@ -437,13 +711,17 @@ func (vm *VM) executeMinusOperator() error {
 
 	if operand.Type() == object.INTEGER_OBJ {
 		value := operand.(*object.Integer).Value
-		return vm.push(&object.Integer{Value: -value})
+		result, err := object.IntegerArithmetic("-", 0, value, vm.overflow)
+		if err != nil {
+			return newError(ArithmeticError, "%s: -(%d)", err, value)
+		}
+		return vm.push(&object.Integer{Value: result})
 	} else if operand.Type() == object.FLOAT_OBJ {
 		value := operand.(*object.Float).Value
 		return vm.push(&object.Float{Value: -value})
 	}
 
-	return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	return newError(TypeError, "unsupported type for negation: %s", operand.Type())
 }
 
 // executeBangOperator pops the operand off the stack and negates its value
@@ -469,13 +747,42 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
-		return fmt.Errorf("index operator not supported: %s", left.Type())
+		return newError(TypeError, "index operator not supported: %s", left.Type())
 	}
 }
 
+// executeSetIndex stores the value into the element of array or hash and pushes the value back
+// as the result of the assignment. Unlike reading, writing out of the range of array is an error.
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		array := left.(*object.Array)
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(array.Elements)) {
+			return newError(IndexError, "index out of range: %d", i)
+		}
+		array.Elements[i] = value
+
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(TypeError, "unusable as hash key: %s", index.Type())
+		}
+		hash := left.(*object.Hash)
+		hash.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+
+	default:
+		return newError(TypeError, "index assignment not supported for %s: %s", left.Type(), index.Type())
+	}
+
+	return vm.push(value)
+}
+
 // executeArrayIndex checks the bounds of array being indexed
 // and if the index doesn't match an element of an array, it puhses Null on the stack,
 // whereas push the element.
@@ -491,6 +798,19 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	return vm.push(arrayObject.Elements[i])
 }
 
+// executeStringIndex pushes the character at the index counted in runes, not in bytes,
+// or Null if the index is out of range.
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	runes := []rune(str.(*object.String).Value)
+	i := index.(*object.Integer).Value
+
+	if i < 0 || i >= int64(len(runes)) {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.String{Value: string(runes[i])})
+}
+
 // executeHashIndex checks whether the given index can be used an object.HashKey
 // and if the given index can be turned into an object.Hashable, it fetches
 // matching element from hashObject.Pairs and push the element.
@@ -499,7 +819,7 @@ func (vm *VM) executeHashIndex(hash, index object.Object) error {
 
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return fmt.Errorf("unusuable as hash key: %s", index.Type())
+		return newError(TypeError, "unusuable as hash key: %s", index.Type())
 	}
 
 	pair, ok := hashObject.Pairs[key.HashKey()]
@@ -522,6 +842,18 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 	return &object.Array{Elements: elements}
 }
 
+// buildString concatenates the string representations of the values on the stack,
+// which are the parts of the interpolated string.
+func (vm *VM) buildString(startIndex, endIndex int) object.Object {
+	var out strings.Builder
+
+	for i := startIndex; i < endIndex; i++ {
+		out.WriteString(vm.stack[i].Inspect())
+	}
+
+	return &object.String{Value: out.String()}
+}
+
 // buildHash adds the keys and values to a newly built *object.Hash,
 // which is then pushed onto the stack after the elements have been taken off.
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
@@ -535,7 +867,7 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			return nil, newError(TypeError, "unusable as hash key: %s", key.Type())
 		}
 		hashedPairs[hashKey.HashKey()] = pair
 	}
@@ -557,6 +889,24 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.frameIndex]
 }
 
+// executeImport pushes the exports of the module cached in the global. At the first import,
+// it calls the function of the module instead, which returns the exports after caching them.
+func (vm *VM) executeImport(globalIndex, constIndex int) error {
+	if exports := vm.globals[globalIndex]; exports != nil {
+		return vm.push(exports)
+	}
+
+	fn, ok := vm.constants[constIndex].(*object.CompiledFunction)
+	if !ok {
+		return newError(InternalError, "not a module: %+v", vm.constants[constIndex])
+	}
+
+	if err := vm.push(&object.Closure{Fn: fn}); err != nil {
+		return err
+	}
+	return vm.executeCall(0)
+}
+
 func (vm *VM) executeCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
@@ -565,29 +915,135 @@ func (vm *VM) executeCall(numArgs int) error {
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
 	default:
-		return fmt.Errorf("calling non-function and non-builtin")
+		return newError(TypeError, "calling non-function and non-builtin")
+	}
+}
+
+// executeSpreadCall calls the function with the elements of the arrays on the stack as the arguments,
+// which replace the arrays.
+func (vm *VM) executeSpreadCall(numParts int) error {
+	parts := vm.stack[vm.sp-numParts : vm.sp]
+
+	args := []object.Object{}
+	for _, part := range parts {
+		array, ok := part.(*object.Array)
+		if !ok {
+			return newError(TypeError, "spread of non-array argument: %s", part.Type())
+		}
+		args = append(args, array.Elements...)
+	}
+
+	vm.sp -= numParts
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return err
+		}
 	}
+
+	return vm.executeCall(len(args))
 }
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
-	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
-			cl.Fn.NumParameters, numArgs)
+	if !cl.Fn.AcceptsArguments(numArgs) {
+		return arityError(cl.Fn, numArgs)
+	}
+
+	if vm.frameIndex >= MaxFrames {
+		return newError(StackOverflowError, "stack overflow: exceeded %d call frames", MaxFrames)
+	}
+
+	if err := checkLocals(cl.Fn, vm.sp-numArgs); err != nil {
+		return err
 	}
 
 	frame := NewFrame(cl, vm.sp-numArgs)
 	vm.pushFrame(frame)
+	vm.enterFrame(frame, numArgs)
 
-	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
 
+func arityError(fn *object.CompiledFunction, numArgs int) error {
+	return newError(ArgumentError, "%s", object.ArityMessage(fn.NumRequired(), fn.NumParameters, fn.Variadic, numArgs))
+}
+
+// checkLocals reports the stack overflow if the locals of the function don't fit in the stack
+// from the base pointer, which must be checked before any slot of them is touched.
+func checkLocals(fn *object.CompiledFunction, basePointer int) error {
+	if basePointer+fn.NumLocals > StackSize {
+		return newError(StackOverflowError, "stack overflow")
+	}
 	return nil
 }
 
+// enterFrame lays out the locals of the frame called with the arguments at its base pointer.
+// The arguments beyond the parameters are collected into the rest parameter, and the slots
+// of the other locals are cleared, which may hold the cells left by the previous calls.
+// The execution starts at the initializer of the default value of the first missing argument, if any.
+func (vm *VM) enterFrame(frame *Frame, numArgs int) {
+	fn := frame.cl.Fn
+	bp := frame.basePointer
+
+	var rest *object.Array
+	if fn.Variadic {
+		rest = &object.Array{Elements: []object.Object{}}
+		if numArgs > fn.NumParameters {
+			rest.Elements = append(rest.Elements, vm.stack[bp+fn.NumParameters:bp+numArgs]...)
+			numArgs = fn.NumParameters
+		}
+	}
+
+	for i := bp + numArgs; i < bp+fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	if rest != nil {
+		vm.stack[bp+fn.NumParameters] = rest
+	}
+	vm.sp = bp + fn.NumLocals
+
+	if n := len(fn.DefaultEntries); n > 0 {
+		frame.ip = fn.DefaultEntries[n-1-(fn.NumParameters-numArgs)] - 1
+	}
+}
+
+// executeTailCall calls the closure in the current frame instead of pushing a new one,
+// so that the recursion in tail position runs in constant stack space.
+// The frame of the caller doesn't appear in the stack trace afterwards.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		// The result of the builtin is returned by the instruction following the call.
+		return vm.executeCall(numArgs)
+	}
+
+	if !cl.Fn.AcceptsArguments(numArgs) {
+		return arityError(cl.Fn, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	if err := checkLocals(cl.Fn, frame.basePointer); err != nil {
+		return err
+	}
+
+	// Move the callee and the arguments down to where the current callee and its arguments are.
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = cl
+	frame.ip = -1
+	vm.enterFrame(frame, numArgs)
+
+	return nil
+}
+
+// callBuiltin calls the builtin function with the arguments on the stack. The error the builtin
+// returns is raised as the runtime error, so that the try expression catches it.
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
 	result := builtin.Fn(args...)
-	vm.sp -= -numArgs - 1
+	if err, ok := result.(*object.Error); ok {
+		return newError(ArgumentError, "%s", err.Message)
+	}
+	vm.sp = vm.sp - numArgs - 1
 
 	if result != nil {
 		vm.push(result)
@@ -602,12 +1058,18 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
 	if !ok {
-		return fmt.Errorf("not a function: %+v", constant)
+		return newError(TypeError, "not a function: %+v", constant)
 	}
 
-	free := make([]object.Object, numFree)
+	free := make([]*object.Cell, numFree)
 	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+		// The closure referring to itself is pushed as it is, and is given a new cell.
+		obj := vm.stack[vm.sp-numFree+i]
+		cell, ok := obj.(*object.Cell)
+		if !ok {
+			cell = &object.Cell{Value: obj}
+		}
+		free[i] = cell
 	}
 	vm.sp -= numFree
 
@@ -615,6 +1077,25 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 	return vm.push(closure)
 }
 
+// matchHash reports whether the value is a hash having all of the keys.
+func matchHash(value object.Object, keys []object.Object) bool {
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return false
+	}
+
+	for _, key := range keys {
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return false
+		}
+		if _, ok := hash.Pairs[hashKey.HashKey()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return True
@@ -6,6 +6,7 @@ import (
 
 	"github.com/toversus/monkey/ast"
 	"github.com/toversus/monkey/compiler"
+	"github.com/toversus/monkey/evaluator"
 	"github.com/toversus/monkey/lexer"
 	"github.com/toversus/monkey/object"
 	"github.com/toversus/monkey/parser"
@@ -29,6 +30,9 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"1 + 10 % 4 * 2", 5},
 	}
 
 	runVmTests(t, tests)
@@ -50,6 +54,14 @@ func TestFloatArithmetic(t *testing.T) {
 		{"5.0 * (2.0 + 10.0)", 60.0},
 		{"-5.4", -5.4},
 		{"-10.9", -10.9},
+		{"7.5 % 2.0", 1.5},
+		{"7.5 % 2", 1.5},
+		{"1 + 2.5", 3.5},
+		{"2.5 + 1", 3.5},
+		{"3 - 0.5", 2.5},
+		{"2.5 * 2", 5.0},
+		{"3 / 2.0", 1.5},
+		{"(5 + 10.0 * 2.5 + 15.0 / 3) * 2 + -10", 60.0},
 	}
 
 	runVmTests(t, tests)
@@ -92,6 +104,26 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!5", true},
 		{"!!5.5", true},
 		{"!(if (false) { 5; })", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1.5", true},
+		{"1.5 >= 2.5", false},
+		{"1 < 1.5", true},
+		{"2 >= 1.5", true},
+		{"1 == true", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{`1 && "a"`, true},
+		{"!(1 > 2) && 3 >= 3 || false", true},
+		{"let x = 0; let f = fn() { x = 1; true }; false && f(); x == 0", true},
+		{"let x = 0; let f = fn() { x = 1; true }; true && f(); x == 1", true},
+		{"let x = 0; let f = fn() { x = 1; true }; true || f(); x == 0", true},
+		{"let x = 0; let f = fn() { x = 1; true }; false || f(); x == 1", true},
 	}
 
 	runVmTests(t, tests)
@@ -114,6 +146,122 @@ func TestConditionals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i", 10},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let n = 0; for (c in \"abc\") { let n = n + 1; }; n", 3},
+		{"let sum = 0; for (k in {1: true, 2: true, 3: false}) { let sum = sum + k; }; sum", 6},
+		{"let i = 0; while (true) { if (i == 5) { break; } let i = i + 1; }; i", 5},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let sum = sum + x; }; sum", 8},
+		{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { if (y == 2) { break; } let n = n + 1; } }; n", 2},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"let f = fn(n) { let i = 0; while (i < n) { let i = i + 1; } i }; f(100000)", 100000},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + if (x == 2) { continue; } else { x } }; s", 4},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + if (x == 2) { break; } else { x } }; s", 1},
+		{"let f = fn(a, b) { a + b }; let s = 0; for (x in [1, 2, 3]) { s = s + f(x, if (x == 2) { break; } else { 10 }) }; s", 11},
+		{"let r = 0; while (r < 10) { r = r + len([1, 2, if (r > 3) { break; } else { 3 }]) }; r", 6},
+		{`let n = 0; for (x in [1, 2, 3]) { let h = {"a": x, "b": if (x == 2) { continue; } else { x }}; n = n + h["b"] }; n`, 4},
+		{"let c = 0; for (x in [1, 2]) { [x, for (y in [1, 2, 3]) { c = c + [y, if (y == 2) { break; } else { 1 }][1] }] }; c", 2},
+		{"while (false) { 1 }", Null},
+		{"for (x in []) { x }", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let x = 1; let y = 2; x = y = 3; x + y", 6},
+		{"let arr = [1, 2, 3]; arr[1] = 5; arr[1]", 5},
+		{"let arr = [1, 2, 3]; arr[2] += 10; arr[2]", 13},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`let h = {"a": 1}; h["a"] *= 7`, 7},
+		{"let x = 1; let f = fn() { x = 5; }; f(); x", 5},
+		{"let f = fn() { let n = 0; n += 2; n }; f()", 2},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let f = fn() { let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n }; f()", 2},
+		{"let f = fn() { let n = 1; let g = fn() { fn() { n *= 10 } }; g()(); g()(); n }; f()", 100},
+		{"let f = fn() { let a = [0]; let set = fn(v) { a[0] = v }; set(7); a[0] }; f()", 7},
+		{"let i = 0; let sum = 0; while (i < 5) { i += 1; sum += i; }; sum", 15},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) { return 0; } else { countDown(x - 1); }
+				};
+				countDown(5);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let fibonacci = fn(x) {
+					if (x < 2) { return x; }
+					fibonacci(x - 1) + fibonacci(x - 2);
+				};
+				fibonacci(15);
+			};
+			wrapper();
+			`,
+			expected: 610,
+		},
+		{
+			input: `
+			let wrapper = fn(n) {
+				let sum = fn(xs) {
+					if (len(xs) == 0) { return 0; }
+					let head = fn() { first(xs) };
+					head() + sum(rest(xs));
+				};
+				sum(n);
+			};
+			wrapper([1, 2, 3, 4]);
+			`,
+			expected: 10,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let repeat = fn(x) { fn(n) { if (n == 0) { [] } else { push(repeat(x)(n - 1), x) } } };
+				repeat(7)(3);
+			};
+			wrapper();
+			`,
+			expected: []int{7, 7, 7},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRebindingRecursiveFunctions(t *testing.T) {
+	// The function refers to the variable it is bound to, which may be bound to another value.
+	tests := []vmTestCase{
+		{"let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } }; let g = f; f = fn(n) { 2 }; g(1)", 2},
+		{"let w = fn() { let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } }; let g = f; f = fn(n) { 2 }; g(1) }; w()", 2},
+		{"let w = fn() { let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } }; let g = f; let f = fn(n) { 2 }; g(1) }; w()", 2},
+		{"let f = fn() { f = 5; f }; f()", 5},
+		{"let w = fn() { let f = fn() { f = 5; f }; f() }; w()", 5},
+		{"let w = fn() { let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } }; let g = f; g(3) }; w()", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{"let one = 1; one", 1},
@@ -129,6 +277,13 @@ func TestStringExpression(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"{\"id\": 1}\n"`, "{\"id\": 1}\n"},
+		{"`line 1\nline \\2`", "line 1\nline \\2"},
+		{`let name = "monkey"; let items = [1, 2]; "hello ${name}, you have ${len(items)} items"`, "hello monkey, you have 2 items"},
+		{`"${1.5} ${true} ${[1, "a"]} ${if (false) { 1 }}"`, "1.5 true [1, a] null"},
+		{`let h = {"a": "b"}; "${h} ${{1: [2]}}"`, "{a: b} {1: [2]}"},
+		{`let f = fn(x) { "<${x}>" }; "${f("${f(1)}")}"`, "<<1>>"},
+		{"`${raw}`", "${raw}"},
 	}
 
 	runVmTests(t, tests)
@@ -196,6 +351,11 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
 		{`{"one": 1, "two": 2, "three": 3}["o" + "ne"]`, 1},
+		{`"こんにちは"[0]`, "こ"},
+		{`"héllo"[1]`, "é"},
+		{`let s = "日本語"; s[len(s) - 1]`, "語"},
+		{`"abc"[3]`, Null},
+		{`"abc"[-1]`, Null},
 	}
 
 	runVmTests(t, tests)
@@ -395,6 +555,46 @@ func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
 			`,
 			expected: 10,
 		},
+		{
+			// The parameter repeating the name shadows the earlier one.
+			input: `
+			let f = fn(a, a) { a };
+			f(1, 2);
+			`,
+			expected: 2,
+		},
+		{
+			input: `
+			let f = fn(a, a = 5) { a };
+			f(1) * 10 + f(1, 2);
+			`,
+			expected: 52,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", 3},
+		{"let f = fn(x = 1, y = x * 2) { x + y }; f()", 3},
+		{"let f = fn(x = 1, y = x * 2) { x + y }; f(5)", 15},
+		{"let n = 3; let f = fn(x, y = n) { x * y }; f(2)", 6},
+		{"let f = fn(x, y = fn() { x }) { y() }; f(4)", 4},
+		{"let f = fn(first, ...rest) { rest }; f(1, 2, 3)", []int{2, 3}},
+		{"let f = fn(first, ...rest) { len(rest) }; f(1)", 0},
+		{"let f = fn(a, b = 2, ...c) { a + b + len(c) }; f(1)", 3},
+		{"let f = fn(a, b = 2, ...c) { a + b + len(c) }; f(1, 5, 7, 7)", 8},
+		{"let sum = fn(...xs) { let s = 0; for (x in xs) { s += x }; s }; sum(1, 2, 3, 4)", 10},
+		{"let f = fn(x, y) { x - y }; f(...[5, 3])", 2},
+		{"let f = fn(x, y, z) { x * 100 + y * 10 + z }; f(1, ...[2], ...[3])", 123},
+		{"let f = fn(...xs) { xs }; f(...[], 1, ...[2, 3])", []int{1, 2, 3}},
+		{"let f = fn(x, y = 1) { x + y }; f(...[1])", 2},
+		{"len(...[[1, 2]])", 2},
+		{"let count = fn(n, acc = 0) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(10000)", 10000},
+		{"let f = fn(n, ...rest) { if (n == 0) { len(rest) } else { f(n - 1, ...rest, n) } }; f(3)", 3},
 	}
 
 	runVmTests(t, tests)
@@ -414,6 +614,22 @@ func TestCallingFunctionWithWrongArguments(t *testing.T) {
 			input:    `fn(a, b) { a + b; }(1);`,
 			expected: `wrong number of arguments: want=2, got=1`,
 		},
+		{
+			input:    `fn(a, b = 1) { a + b; }(1, 2, 3);`,
+			expected: `wrong number of arguments: want=1 to 2, got=3`,
+		},
+		{
+			input:    `fn(a, ...b) { a; }();`,
+			expected: `wrong number of arguments: want=at least 1, got=0`,
+		},
+		{
+			input:    `let f = fn(a) { a; }; let g = fn() { f(...[1, 2]) }; g();`,
+			expected: `wrong number of arguments: want=1, got=2`,
+		},
+		{
+			input:    `fn(a) { a; }(...1);`,
+			expected: `spread of non-array argument: INTEGER`,
+		},
 	}
 
 	for _, test := range tests {
@@ -436,50 +652,337 @@ func TestCallingFunctionWithWrongArguments(t *testing.T) {
 	}
 }
 
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+let wrapper = fn() {
+  let sum = add(1, true);
+};
+wrapper();`
+
+	program := parse(input)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatal("expected VM error but resulted in none.")
+	}
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	if rtErr.Kind != TypeError {
+		t.Errorf("wrong error kind. want=%s, got=%s", TypeError, rtErr.Kind)
+	}
+
+	expected := []struct {
+		function string
+		pos      string
+	}{
+		{"<main>", "7:1"},
+		{"wrapper", "5:13"},
+		{"add", "2:3"},
+	}
+
+	if len(rtErr.Trace) != len(expected) {
+		t.Fatalf("wrong number of frames. want=%d, got=%d (%+v)",
+			len(expected), len(rtErr.Trace), rtErr.Trace)
+	}
+
+	for i, frame := range expected {
+		actual := rtErr.Trace[i]
+		if actual.Function != frame.function || actual.Pos.String() != frame.pos {
+			t.Errorf("wrong frame at %d. want=%s at %s, got=%s at %s",
+				i, frame.function, frame.pos, actual.Function, actual.Pos)
+		}
+	}
+
+	traceback := `Traceback (most recent call last):
+  File "<input>", line 7, column 1, in <main>
+  File "<input>", line 5, column 13, in wrapper
+  File "<input>", line 2, column 3, in add
+TypeError: unsupported types for binary operation: INTEGER BOOLEAN`
+
+	if rtErr.Traceback() != traceback {
+		t.Errorf("wrong traceback.\nwant=%s\ngot=%s", traceback, rtErr.Traceback())
+	}
+}
+
+func TestCallStackOverflow(t *testing.T) {
+	program := parse("let f = fn() { f() + 1 }; f();")
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	if rtErr.Kind != StackOverflowError {
+		t.Errorf("wrong error kind. want=%s, got=%s", StackOverflowError, rtErr.Kind)
+	}
+	if len(rtErr.Trace) != MaxFrames {
+		t.Errorf("wrong number of frames. want=%d, got=%d", MaxFrames, len(rtErr.Trace))
+	}
+}
+
+func TestValueStackOverflow(t *testing.T) {
+	// The recursion runs out of the value stack before the call frames,
+	// while entering the frame whose locals no longer fit in it.
+	input := `
+let f = fn(n) {
+  if (n == 0) {
+    0
+  } else {
+    let res = 1 + (1 + f(n - 1));
+    let a = 0; let b = 0; let c = 0; let d = 0;
+    res
+  }
+};
+f(2000);
+`
+	program := parse(input)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	if rtErr.Kind != StackOverflowError {
+		t.Errorf("wrong error kind. want=%s, got=%s", StackOverflowError, rtErr.Kind)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    "let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(1000000, 0)",
+			expected: 500000500000,
+		},
+		{
+			input:    "let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(1000000, 0)",
+			expected: 500000500000,
+		},
+		{
+			input: `
+			let odd = 0;
+			let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+			odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+			even(100001)
+			`,
+			expected: false,
+		},
+		{
+			// The cells of the caller don't leak into the callee reusing the frame.
+			input: `
+			let g = fn(h) { let x = 5; h() };
+			let f = fn(n) { let a = n; let b = fn() { a }; g(b) };
+			f(1)
+			`,
+			expected: 1,
+		},
+		{
+			input:    "let f = fn(n) { let g = fn() { n * 2 }; g() }; f(21)",
+			expected: 42,
+		},
+		{
+			input:    "let l = len; let f = fn(xs) { l(xs) }; f([1, 2, 3])",
+			expected: 3,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCallStackTrace(t *testing.T) {
+	input := `let fail = fn() { 1 + true };
+let middle = fn() { fail() };
+let outer = fn() { let x = middle(); x };
+outer();`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	rtErr, ok := New(comp.Bytecode()).Run().(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError.")
+	}
+
+	// The frame of middle is replaced by the call to fail in tail position.
+	expected := []string{"<main> at 4:1", "outer at 3:28", "fail at 1:19"}
+
+	if len(rtErr.Trace) != len(expected) {
+		t.Fatalf("wrong number of frames. want=%d, got=%d (%+v)",
+			len(expected), len(rtErr.Trace), rtErr.Trace)
+	}
+
+	for i, want := range expected {
+		got := rtErr.Trace[i].Function + " at " + rtErr.Trace[i].Pos.String()
+		if got != want {
+			t.Errorf("wrong frame at %d. want=%s, got=%s", i, want, got)
+		}
+	}
+}
+
+func TestIntegerArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		policy   object.OverflowPolicy
+		wantKind ErrorKind
+		wantMsg  string
+	}{
+		{"1 / 0", object.OverflowError, ArithmeticError, "division by zero"},
+		{"let x = 0; 5 % x", object.OverflowWrap, ArithmeticError, "division by zero"},
+		{"9223372036854775807 + 1", object.OverflowError, ArithmeticError, "integer overflow: 9223372036854775807 + 1"},
+		{"let x = 4611686018427387904; x *= 2", object.OverflowError, ArithmeticError, "integer overflow: 4611686018427387904 * 2"},
+		{"let min = -9223372036854775807 - 1; -min", object.OverflowError, ArithmeticError, "integer overflow: -(-9223372036854775808)"},
+		{"let f = fn(x) { x / 0 }; f(1)", object.OverflowError, ArithmeticError, "division by zero"},
+	}
+
+	for _, test := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(test.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		machine := New(comp.Bytecode())
+		machine.SetOverflowPolicy(test.policy)
+		err := machine.Run()
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError for %q. got=%T (%+v)", test.input, err, err)
+		}
+		if rtErr.Kind != test.wantKind || rtErr.Message != test.wantMsg {
+			t.Errorf("wrong error. want=%s: %s, got=%s: %s",
+				test.wantKind, test.wantMsg, rtErr.Kind, rtErr.Message)
+		}
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(parse("9223372036854775807 + 1")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	machine.SetOverflowPolicy(object.OverflowWrap)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, -9223372036854775808, machine.LastPoppedStackElem())
+}
+
+func TestIndexAssignErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantKind ErrorKind
+		wantMsg  string
+	}{
+		{"let a = [1]; a[1] = 2", IndexError, "index out of range: 1"},
+		{`let s = "ab"; s[0] = "c"`, TypeError, "index assignment not supported for STRING: INTEGER"},
+		{`let h = {}; h[fn() {}] = 1`, TypeError, "unusable as hash key: CLOSURE"},
+	}
+
+	for _, test := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(test.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+		}
+		if rtErr.Kind != test.wantKind || rtErr.Message != test.wantMsg {
+			t.Errorf("wrong error. want=%s: %s, got=%s: %s",
+				test.wantKind, test.wantMsg, rtErr.Kind, rtErr.Message)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{
-				Message: "argument to 'len' not supported, got INTEGER",
-			},
-		},
-		{`len("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
-		},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
+		{`len("こんにちは")`, 5},
 		{`puts("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{`first(1)`,
-			&object.Error{
-				Message: "argument to 'first' must be ARRAY, got INTEGER",
-			},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{`last(1)`,
-			&object.Error{
-				Message: "argument to 'last' must be ARRAY, got INTEGER",
-			},
-		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`,
-			&object.Error{
-				Message: "argument to 'push' must be ARRAY, got INTEGER",
-			},
-		},
+		{`slice([1, 2, 3], 1)`, []int{2, 3}},
+		{`slice([1, 2, 3], -1, 2)`, []int{1, 2}},
+		{`slice([1, 2, 3], 2, 1)`, []int{}},
+		{`slice("こんにちは", 1, 3)`, "んに"},
+		{`slice("abc", 1, 99)`, "bc"},
+		{`bytes("é")`, []int{0xc3, 0xa9}},
 	}
 
 	runVmTests(t, tests)
+
+	errorTests := []struct {
+		input    string
+		wantKind ErrorKind
+		wantMsg  string
+	}{
+		{`len(1)`, ArgumentError, "argument to 'len' not supported, got INTEGER"},
+		{`len("one", "two")`, ArgumentError, "wrong number of arguments. got=2, want=1"},
+		{`first(1)`, ArgumentError, "argument to 'first' must be ARRAY, got INTEGER"},
+		{`last(1)`, ArgumentError, "argument to 'last' must be ARRAY, got INTEGER"},
+		{`push(1, 1)`, ArgumentError, "argument to 'push' must be ARRAY, got INTEGER"},
+		{`slice(1, 0)`, ArgumentError, "argument to 'slice' must be ARRAY or STRING, got INTEGER"},
+		{`bytes(1)`, ArgumentError, "argument to 'bytes' must be STRING, got INTEGER"},
+	}
+
+	for _, test := range errorTests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(test.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError for %q. got=%T (%+v)", test.input, err, err)
+		}
+		if rtErr.Kind != test.wantKind || rtErr.Message != test.wantMsg {
+			t.Errorf("wrong error. want=%s: %s, got=%s: %s",
+				test.wantKind, test.wantMsg, rtErr.Kind, rtErr.Message)
+		}
+	}
 }
 
 func TestClosures(t *testing.T) {
@@ -584,6 +1087,278 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// mapImporter imports the modules from the source code in the map by their names.
+type mapImporter map[string]string
+
+func (m mapImporter) Resolve(importer, name string) (string, error) {
+	if _, ok := m[name]; !ok {
+		return "", fmt.Errorf("module %q not found", name)
+	}
+	return name, nil
+}
+
+func (m mapImporter) Load(path string) (*ast.Program, error) {
+	p := parser.New(lexer.NewWithFilename(path, m[path]))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", p.Errors()[0])
+	}
+	return program, nil
+}
+
+var testModules = mapImporter{
+	"counter": `let count = 0;
+let inc = fn() { count = count + 1; count };
+let _secret = 42;`,
+	"user": `let counter = import("counter"); let inc = counter["inc"];`,
+	"failing": `let fail = fn(x) {
+  x + true
+};
+let failed = fail(1);`,
+}
+
+func TestImports(t *testing.T) {
+	tests := []vmTestCase{
+		{`import("counter")["count"]`, 0},
+		{`let a = import("counter"); let b = import("counter"); a["inc"](); b["inc"]()`, 2},
+		{`let u = import("user"); u["inc"](); import("counter")["inc"]()`, 2},
+		{`import("counter")["_secret"]`, Null},
+		{`let count = 10; import("counter")["inc"](); count`, 10},
+	}
+
+	for _, test := range tests {
+		comp := compiler.New()
+		comp.SetImporter(testModules)
+		if err := comp.Compile(parse(test.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, test.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestImportStackTrace(t *testing.T) {
+	comp := compiler.New()
+	comp.SetImporter(testModules)
+	if err := comp.Compile(parse(`import("failing")`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err := New(comp.Bytecode()).Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	traceback := `Traceback (most recent call last):
+  File "<input>", line 1, column 1, in <main>
+  File "failing", line 4, column 14, in <module>
+  File "failing", line 2, column 3, in fail
+TypeError: unsupported types for binary operation: INTEGER BOOLEAN`
+
+	if rtErr.Traceback() != traceback {
+		t.Errorf("wrong traceback.\nwant=%s\ngot=%s", traceback, rtErr.Traceback())
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { throw 1; 2 } catch (e) { e + 1 }", 2},
+		{"try { throw \"oops\"; } catch { 3 }", 3},
+		{"try { } catch (e) { 1 }", Null},
+		{"let x = try { throw 1; } catch (e) { }; x", Null},
+		{`try { 1 + true } catch (e) { e["message"] }`, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{`try { 1 + true } catch (e) { e["trace"] }`, []string{"<main> at 1:7"}},
+		{`let f = fn() { [1][5] }; try { f() } catch (e) { e["trace"] }`, []string{"<main> at 1:32", "f at 1:17"}},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { len(1) } catch (e) { "caught" }`, "caught"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to 'len' not supported, got INTEGER"},
+		{`let f = fn(x) { first(x) }; try { f(1) } catch (e) { e["trace"] }`, []string{"<main> at 1:37", "f at 1:22"}},
+		{`let f = fn() { try { push(1, 2) } catch (e) { 3 } }; f()`, 3},
+		{"let f = fn(n) { if (n == 0) { throw \"bottom\"; } f(n - 1) + 1 }; try { f(10) } catch (e) { e }", "bottom"},
+		{"let f = fn() { f() + 1 }; try { f() } catch (e) { e[\"message\"] }", "stack overflow: exceeded 1024 call frames"},
+		{"try { try { throw 1; } catch (e) { throw e + 1; } } catch (e) { e }", 2},
+		{"try { try { throw 1; } finally { 5 } } catch (e) { e }", 1},
+		// The handler is entered with the stack of the try expression, wherever the exception comes from.
+		{"1 + try { 2 + [3, 4, fn() { throw 5; }()][0] } catch (e) { e }", 6},
+		{"let s = 0; for (x in [1, 2, 3]) { s += try { if (x == 2) { throw 10; } x } catch (e) { e } }; s", 14},
+		{"let log = []; try { log = push(log, 1); } finally { log = push(log, 2); }; log", []int{1, 2}},
+		{"let log = []; try { throw 1; } catch (e) { log = push(log, e); } finally { log = push(log, 2); }; log", []int{1, 2}},
+		{"let log = []; try { try { throw 1; } finally { log = push(log, 2); } } catch (e) { log = push(log, e); }; log", []int{2, 1}},
+		{"let log = []; try { try { throw 1; } catch (e) { throw 3; } finally { log = push(log, 2); } } catch (e) { log = push(log, e); }; log", []int{2, 3}},
+		{"try { throw 1; } catch (e) { 2 } finally { 3 }", 2},
+		{"try { try { throw 1; } finally { throw 2; } } catch (e) { e }", 2},
+		{"let log = []; let f = fn() { try { return 1; } finally { log = push(log, 2); } }; let r = f(); push(log, r)", []int{2, 1}},
+		{"let log = []; let f = fn() { try { try { return 1; } finally { log = push(log, 2); } } finally { log = push(log, 3); } }; let r = f(); push(log, r)", []int{2, 3, 1}},
+		{"let log = []; for (x in [1, 2, 3]) { try { if (x == 2) { break; } } finally { log = push(log, x); } }; log", []int{1, 2}},
+		{"let log = []; for (x in [1, 2, 3]) { try { if (x == 2) { continue; } log = push(log, 0); } finally { log = push(log, x); } }; log", []int{0, 1, 2, 0, 3}},
+		// The finally block inlined for return is not protected by its own handler.
+		{"let n = 0; let f = fn() { try { return 1; } finally { n += 1; } }; try { f() } catch (e) { }; n", 1},
+		{"let f = fn() { try { return 1; } finally { throw 2; } }; try { f() } catch (e) { e }", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtException(t *testing.T) {
+	input := `let f = fn() {
+  throw "bad input";
+};
+f();`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	rtErr, ok := New(comp.Bytecode()).Run().(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError.")
+	}
+
+	if rtErr.Kind != UncaughtException {
+		t.Errorf("wrong error kind. want=%s, got=%s", UncaughtException, rtErr.Kind)
+	}
+	if _, ok := rtErr.Value.(*object.String); !ok {
+		t.Errorf("value is not String. got=%T (%+v)", rtErr.Value, rtErr.Value)
+	}
+
+	traceback := `Traceback (most recent call last):
+  File "<input>", line 4, column 1, in <main>
+  File "<input>", line 2, column 3, in f
+UncaughtException: bad input`
+
+	if rtErr.Traceback() != traceback {
+		t.Errorf("wrong traceback.\nwant=%s\ngot=%s", traceback, rtErr.Traceback())
+	}
+}
+
+func TestLetDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; rest", []int{3, 4}},
+		{"let [a, ...rest] = [1]; len(rest)", 0},
+		{"let [a, b] = [1]; b", Null},
+		{"let [_, b] = [1, 2]; b", 2},
+		{`let {name, age: years} = {"name": "monkey", "age": 3}; years`, 3},
+		{`let {name, age: years} = {"name": "monkey", "age": 3}; name`, "monkey"},
+		{`let {missing} = {}; missing`, Null},
+		{`let {"k": [x, {y}], 1: z} = {"k": [1, {"y": 2}], 1: 3}; x + y + z`, 6},
+		{"let pair = fn(x) { [x, x * 2] }; let [a, b] = pair(3); a + b", 9},
+		{"let f = fn(p) { let [a, b] = p; let {c} = b; a + c }; f([1, {\"c\": 2}])", 3},
+		{"let f = fn(x) { let [a] = x; fn() { a } }; f([5])()", 5},
+		{"let [a, b] = [1, 2]; let [a, b] = [b, a]; a", 2},
+		{"match ([1, 2, 3]) { [a, ...rest] => rest[1], _ => 0 }", 3},
+		{"match ([1]) { [a, b, ...rest] => 1, [a, ...rest] => len(rest) }", 0},
+		{`match ({"name": "m"}) { {name} => name }`, "m"},
+	}
+
+	runVmTests(t, tests)
+
+	errorTests := []struct {
+		input    string
+		wantKind ErrorKind
+		wantMsg  string
+	}{
+		{"let [a] = 1;", TypeError, "index operator not supported: INTEGER"},
+		{`let {a} = [1];`, TypeError, "index operator not supported: ARRAY"},
+		{`let [a, ...b] = "ab";`, TypeError, "rest of array pattern not supported: STRING"},
+	}
+
+	for _, test := range errorTests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(test.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+		}
+		if rtErr.Kind != test.wantKind || rtErr.Message != test.wantMsg {
+			t.Errorf("wrong error. want=%s: %s, got=%s: %s",
+				test.wantKind, test.wantMsg, rtErr.Kind, rtErr.Message)
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"match (1) { 1 => 10, _ => 20 }", 10},
+		{"match (2) { 1 => 10, _ => 20 }", 20},
+		{"match (3) { 1 => 10 }", Null},
+		{"match (-1) { -1 => 1, _ => 2 }", 1},
+		{"match (1.5) { 1.5 => 1, _ => 2 }", 1},
+		{"match (1) { 1.0 => 1, _ => 2 }", 2},
+		{`match ("ab") { "a" => 1, "ab" => 2, _ => 3 }`, 2},
+		{"match (true) { false => 1, true => 2 }", 2},
+		{"match (5) { x => x * 2 }", 10},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b, _ => 0 }", 3},
+		{"match ([1, 2, 3]) { [a, b] => a + b, _ => 0 }", 0},
+		{"match ([1, [2, 3]]) { [1, [a, 3]] => a, _ => 0 }", 2},
+		{"match ([1, [2, 4]]) { [1, [a, 3]] => a, _ => 0 }", 0},
+		{"match ([[1, 2], [3, 4]]) { [[_, b], [c, _]] => b + c }", 5},
+		{`match ({"type": "click", "x": 3}) { {"type": "key"} => 1, {"type": "click", "x": x} => x }`, 3},
+		{`match ({"type": "click"}) { {"type": "click", "x": x} => x, {"type": t} => t }`, "click"},
+		{`match ({1: [2, 3]}) { {1: [a, b]} => a * b }`, 6},
+		{`match ([1, 2]) { {"a": a} => a, _ => 0 }`, 0},
+		{`match ("ab") { [a, b] => a, _ => 0 }`, 0},
+		{"match (5) { x if x > 10 => 1, x if x > 1 => 2, _ => 3 }", 2},
+		{"match ([1, 2]) { [a, b] if a > b => 1, [a, b] => b }", 2},
+		{"match (1) { 1 => { let y = 2; y + 1 } _ => 0 }", 3},
+		{"match (1) { 1 => { } }", Null},
+		{"match (match (1) { 1 => 2 }) { 2 => match (3) { 3 => 4 }, _ => 0 }", 4},
+		{"let r = []; for (x in [1, [2, 3], 4]) { r = push(r, match (x) { [a, b] => a + b, _ => x }) }; r[1]", 5},
+		{"let f = fn(x) { match (x) { [a, b] => a + f(b), [a] => a, _ => 0 } }; f([1, [2, [3]]])", 6},
+		{"let f = fn(n, acc) { match (n) { 0 => acc, _ => f(n - 1, acc + n) } }; f(10000, 0)", 50005000},
+		{"let f = fn(x) { let g = match (x) { [a] => fn() { a } }; g() }; f([7])", 7},
+		{"1 + match (2) { 2 => 3 }", 4},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMacros(t *testing.T) {
+	input := `
+	let unless = macro(condition, consequence, alternative) {
+		quote(if (!(unquote(condition))) {
+			unquote(consequence);
+		} else {
+			unquote(alternative);
+		});
+	};
+
+	unless(10 > 5, "not greater", "greater");
+	`
+
+	program, diagnostics := evaluator.ExpandProgram(parse(input), object.NewEnvironment())
+	if len(diagnostics) != 0 {
+		t.Fatalf("macro error: %s", diagnostics[0])
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, "greater", vm.LastPoppedStackElem())
+}
+
 type vmTestCase struct {
 	input    string
 	expected interface{}